@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+)
+
+// startAnnotating opens the annotation editor for the highlighted row
+func (m *model) startAnnotating() tea.Cmd {
+	if m.db == nil {
+		m.flashMessage = "Annotations aren't available for this history source"
+		return nil
+	}
+
+	id, ok := rowid(m.table.HighlightedRow().Data)
+	if !ok {
+		m.flashMessage = "Nothing to annotate"
+		return nil
+	}
+
+	a, err := annotation.Get(m.db, id)
+	if err != nil {
+		slog.Error("unable to get annotation", "rowid", id, "error", err)
+		m.flashMessage = fmt.Sprintf("Unable to get annotation: %v", err)
+		return nil
+	}
+
+	input := textinput.New()
+	input.Prompt = "note (#tags): "
+	input.SetValue(a.String())
+
+	m.annotationInput = input
+	m.annotatingRowid = id
+	m.annotating = true
+
+	return m.annotationInput.Focus()
+}
+
+func (m *model) updateAnnotating(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "ctrl+c":
+		return tea.Quit
+	case "esc":
+		m.annotating = false
+		m.flashMessage = "Annotation cancelled"
+	case "enter":
+		m.annotating = false
+
+		a := annotation.Parse(m.annotationInput.Value())
+		if err := annotation.Set(m.db, m.annotatingRowid, a); err != nil {
+			slog.Error("unable to save annotation", "rowid", m.annotatingRowid, "error", err)
+			m.flashMessage = fmt.Sprintf("Unable to save annotation: %v", err)
+			return nil
+		}
+
+		m.queryCache.Clear()
+
+		slog.Info("annotated entry", "rowid", m.annotatingRowid, "tags", a.Tags)
+		if a.IsZero() {
+			m.flashMessage = "Annotation removed"
+		} else {
+			m.flashMessage = "Annotation saved"
+		}
+		m.refreshRows()
+	default:
+		var cmd tea.Cmd
+		m.annotationInput, cmd = m.annotationInput.Update(msg)
+		return cmd
+	}
+
+	return nil
+}
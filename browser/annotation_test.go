@@ -0,0 +1,189 @@
+package main_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+func TestAnnotationParse(t *testing.T) {
+	a := annotation.Parse("  deploy   staging #deploy #k8s #deploy # ")
+	require.Equal(t, annotation.Annotation{Note: "deploy staging #", Tags: []string{"deploy", "k8s"}}, a)
+	require.Equal(t, "deploy staging # #deploy #k8s", a.String())
+
+	require.True(t, annotation.Parse("   ").IsZero())
+	require.Equal(t, "#dns", annotation.Parse("#dns").String())
+}
+
+// annotationTestDB sets up a database with a plain view standing in for the h
+// virtual table, which is enough for queries that don't use MATCH
+func annotationTestDB(t *testing.T) (*sql.DB, []int64) {
+	t.Helper()
+
+	db, rowids := plainTestDB(t)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	return db, rowids
+}
+
+// plainTestDB is annotationTestDB, but with nothing besides the history table
+// and the view
+func plainTestDB(t *testing.T) (*sql.DB, []int64) {
+	t.Helper()
+
+	rows := make([]history.Row, 0)
+	for i, entry := range []string{"kubectl rollout restart deploy/api", "sudo resolvectl flush-caches", "ls"} {
+		rows = append(rows, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+	}
+	db, rowids := testDB(t, rows...)
+
+	_, err := db.Exec("CREATE VIEW h AS SELECT rowid, session_id, timestamp, timestamp AS raw_timestamp, cwd, entry, hostname, duration, exit_status FROM history")
+	require.NoError(t, err)
+
+	return db, rowids
+}
+
+func TestAnnotationSetAndGet(t *testing.T) {
+	db, rowids := annotationTestDB(t)
+
+	a, err := annotation.Get(db, rowids[0])
+	require.NoError(t, err)
+	require.True(t, a.IsZero())
+
+	require.NoError(t, annotation.Set(db, rowids[0], annotation.Parse("deploy staging #deploy #k8s")))
+
+	a, err = annotation.Get(db, rowids[0])
+	require.NoError(t, err)
+	require.Equal(t, annotation.Annotation{Note: "deploy staging", Tags: []string{"deploy", "k8s"}}, a)
+
+	// setting replaces everything
+	require.NoError(t, annotation.Set(db, rowids[0], annotation.Parse("#deploy")))
+	a, err = annotation.Get(db, rowids[0])
+	require.NoError(t, err)
+	require.Equal(t, annotation.Annotation{Tags: []string{"deploy"}}, a)
+
+	require.NoError(t, annotation.Forget(db, []int64{rowids[0]}))
+	a, err = annotation.Get(db, rowids[0])
+	require.NoError(t, err)
+	require.True(t, a.IsZero())
+}
+
+func TestAnnotationQuery(t *testing.T) {
+	db, rowids := annotationTestDB(t)
+
+	require.NoError(t, annotation.Set(db, rowids[0], annotation.Parse("deploy staging #deploy #k8s")))
+	require.NoError(t, annotation.Set(db, rowids[1], annotation.Parse("fix docker dns #DNS")))
+
+	f := query.Filter{
+		Columns:            []string{"tags", "note"},
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+	}
+
+	_, rows, err := query.Search(db, f)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, "", rows[0]["tags"])
+	require.Equal(t, "#DNS", rows[1]["tags"])
+	require.Equal(t, "fix docker dns", rows[1]["note"])
+	require.Equal(t, "#deploy #k8s", rows[2]["tags"])
+
+	for q, expected := range map[string][]string{
+		"tag:deploy":         {"kubectl rollout restart deploy/api"},
+		"tag:#dns":           {"sudo resolvectl flush-caches"},
+		"tag:deploy tag:k8s": {"kubectl rollout restart deploy/api"},
+		"tag:deploy tag:dns": {},
+		"tag:no-such-tag":    {},
+	} {
+		f.Query = q
+		_, rows, err := query.Search(db, f)
+		require.NoError(t, err, q)
+
+		entries := make([]string, 0)
+		for _, row := range rows {
+			entries = append(entries, row["entry"])
+		}
+		require.Equal(t, expected, entries, q)
+	}
+}
+
+func TestAnnotationsCreatedLazily(t *testing.T) {
+	db, rowids := plainTestDB(t)
+	s := store.NewSQLite(db, query.VTableBackend{})
+
+	tablesExist := func() (bool, bool) {
+		annotations, err := annotation.Exists(db)
+		require.NoError(t, err)
+		pinned, err := pins.Exists(db)
+		require.NoError(t, err)
+		return annotations, pinned
+	}
+
+	// browsing doesn't create anything
+	f := query.Filter{
+		Columns:            []string{"tags", "note"},
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+		FloatPins:          true,
+	}
+	_, rows, err := s.Search(f)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, "", rows[0]["tags"])
+	require.Equal(t, "", rows[0]["note"])
+
+	f.Query = "tag:deploy"
+	_, rows, err = s.Search(f)
+	require.NoError(t, err)
+	require.Empty(t, rows)
+
+	_, err = s.Stats(f)
+	require.NoError(t, err)
+
+	a, err := annotation.Get(db, rowids[0])
+	require.NoError(t, err)
+	require.True(t, a.IsZero())
+
+	pinned, err := pins.IsPinned(db, "ls")
+	require.NoError(t, err)
+	require.False(t, pinned)
+	require.NoError(t, pins.Unpin(db, "ls"))
+
+	_, err = s.Delete([]int64{rowids[2]})
+	require.NoError(t, err)
+
+	annotationsExist, pinsExist := tablesExist()
+	require.False(t, annotationsExist)
+	require.False(t, pinsExist)
+
+	adjusted, err := f.ForDatabase(db)
+	require.NoError(t, err)
+	require.True(t, adjusted.NoAnnotations)
+	require.True(t, adjusted.NoPins)
+
+	// adjusting it again doesn't go back to the database
+	again, err := adjusted.ForDatabase(nil)
+	require.NoError(t, err)
+	require.Equal(t, adjusted, again)
+
+	// but annotating or pinning something does
+	require.NoError(t, annotation.Set(db, rowids[0], annotation.Parse("#deploy")))
+	require.NoError(t, pins.Pin(db, "ls", rowids[2], time.Unix(1700000000, 0)))
+
+	annotationsExist, pinsExist = tablesExist()
+	require.True(t, annotationsExist)
+	require.True(t, pinsExist)
+
+	_, rows, err = s.Search(f)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "#deploy", rows[0]["tags"])
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+// backendFlags are the flags shared by the subcommands that query history
+// through h
+type backendFlags struct {
+	name string
+}
+
+func (bf *backendFlags) register(flags *pflag.FlagSet) {
+	flags.StringVar(&bf.name, "backend", defaultBackend, "How to query history: vtable (the Lua virtual table) or go (plain SQL against the history table)")
+}
+
+func (bf *backendFlags) backend() (query.Backend, error) {
+	switch bf.name {
+	case "vtable":
+		return query.VTableBackend{}, nil
+	case "go":
+		return query.HistoryTableBackend{ExcludeSessionID: os.Getenv("HISTDB_SESSION_ID")}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q (expected vtable or go)", bf.name)
+	}
+}
@@ -0,0 +1,198 @@
+package main_test
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/stats"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+func TestHistoryTableBackendSearch(t *testing.T) {
+	recorded := []history.Row{
+		{SessionID: "1", Cwd: "/home/rob/histdb", Entry: "git commit -m 'Fix the browser'", ExitStatus: ptr(int64(0))},
+		{SessionID: "1", Cwd: "/home/rob/histdb", Entry: "git push origin", ExitStatus: ptr(int64(1))},
+		{SessionID: "2", Cwd: "/tmp", Entry: "make test"},
+		{SessionID: "3", Cwd: "/home/rob", Entry: "git status"},
+	}
+	for i := range recorded {
+		recorded[i].Hostname = "host1"
+		recorded[i].Timestamp = time.Unix(1641340800+int64(i), 0)
+	}
+
+	db, rowids := testDB(t, recorded...)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	// a row the virtual table skips, since it has no usable timestamp
+	_, err := db.Exec("INSERT INTO history (session_id, timestamp, entry) VALUES ('1', 'yesterday', 'git log')")
+	require.NoError(t, err)
+
+	require.NoError(t, annotation.Set(db, rowids[0], annotation.Parse("#release")))
+
+	f := query.Filter{
+		Query:              "git",
+		Columns:            []string{"session_id", "tags"},
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+		Backend:            query.HistoryTableBackend{ExcludeSessionID: "3"},
+	}
+
+	_, rows, err := query.Search(db, f)
+	require.NoError(t, err)
+	require.Len(t, rows, 2, "the excluded session's commands and unusable rows are left out")
+	require.Equal(t, "git push origin", rows[0]["entry"])
+	require.Equal(t, "1", rows[0]["exit_status"])
+	require.Equal(t, "git commit -m 'Fix the browser'", rows[1]["entry"])
+	require.Equal(t, "#release", rows[1]["tags"])
+
+	// every word has to appear, in any order
+	f.Query = "browser GIT"
+	_, rows, err = query.Search(db, f)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "git commit -m 'Fix the browser'", rows[0]["entry"])
+
+	f.Query = "tag:release"
+	f.ShowFailedCommands = false
+	f.ExcludeRowids = []int64{rowids[0]}
+	_, rows, err = query.Search(db, f)
+	require.NoError(t, err)
+	require.Empty(t, rows)
+
+	s, err := stats.Collect(db, query.Filter{
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+		Backend:            query.HistoryTableBackend{},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, s.Total)
+}
+
+func TestHistoryTableBackendMatch(t *testing.T) {
+	now := time.Date(2022, 3, 18, 14, 0, 0, 0, time.Local)
+	b := query.HistoryTableBackend{Now: func() time.Time { return now }}
+
+	predicate, params := b.Match("cwd", "rob/histdb")
+	require.Equal(t, "cwd LIKE ?", predicate)
+	require.Equal(t, []any{"%rob/histdb%"}, params)
+
+	predicate, params = b.Match("timestamp", "yesterday")
+	require.Equal(t, "raw_timestamp BETWEEN ? AND ?", predicate)
+	require.Equal(t, []any{
+		time.Date(2022, 3, 17, 0, 0, 0, 0, time.Local).Unix(),
+		time.Date(2022, 3, 18, 0, 0, 0, 0, time.Local).Unix(),
+	}, params)
+
+	predicate, params = b.Match("timestamp", "next tuesday")
+	require.Equal(t, "0", predicate)
+	require.Empty(t, params)
+}
+
+func TestResolveTimestampMatch(t *testing.T) {
+	now := time.Date(2022, 3, 18, 14, 0, 0, 0, time.Local)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2022, month, d, 0, 0, 0, 0, time.Local)
+	}
+
+	for expr, expected := range map[string][2]time.Time{
+		"today":                   {day(3, 18), day(3, 19)},
+		"yesterday":               {day(3, 17), day(3, 18)},
+		"1 day ago":               {day(3, 17), day(3, 18)},
+		"3 days ago":              {day(3, 15), day(3, 16)},
+		"1 week ago":              {day(3, 6), day(3, 13)},
+		"1d ago":                  {day(3, 17), day(3, 18)},
+		"-1 days":                 {day(3, 17), day(3, 18)},
+		"2022-03-01":              {day(3, 1), day(3, 2)},
+		"03-01":                   {day(3, 1), day(3, 2)},
+		"on 03-01":                {day(3, 1), day(3, 2)},
+		"since 03-01":             {day(3, 1), now},
+		"between 03-01 and 03-10": {day(3, 1), day(3, 11)},
+	} {
+		start, end, err := query.ResolveTimestampMatch(expr, now)
+		require.NoError(t, err, expr)
+		require.Equal(t, expected[0], start, expr)
+		require.Equal(t, expected[1], end, expr)
+	}
+
+	for _, expr := range []string{"since now", "", "since "} {
+		_, _, err := query.ResolveTimestampMatch(expr, now)
+		require.Error(t, err, expr)
+	}
+}
+
+var registerScanCountingDriver sync.Once
+
+func TestHistoryTableBackendCountsScans(t *testing.T) {
+	registerScanCountingDriver.Do(func() {
+		sql.Register("sqlite3-scan-counting-test", &sqlite3.SQLiteDriver{ConnectHook: query.RegisterScanCounter})
+	})
+
+	path := filepath.Join(t.TempDir(), "history.db")
+	writer, err := history.Open(path)
+	require.NoError(t, err)
+	defer writer.Close()
+
+	for i := 0; i < 50; i++ {
+		entry := fmt.Sprintf("make test-%d", i)
+		if i%25 == 0 {
+			entry = "grep needle haystack"
+		}
+		_, err := history.Insert(writer, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry, ExitStatus: ptr(int64(0))})
+		require.NoError(t, err)
+	}
+
+	db, err := sql.Open("sqlite3-scan-counting-test", history.DSN(path, nil))
+	require.NoError(t, err)
+	defer db.Close()
+
+	f := query.Filter{Query: "needle", ShowGlobalCommands: true, Backend: query.HistoryTableBackend{CountScans: true}}
+
+	// without an index, every row is looked at
+	s := store.NewSQLite(db, f.Backend)
+	_, rows, err := s.Search(f)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, 50, store.RowsScanned(s))
+
+	// other queries on the connection don't count towards the next search's
+	_, err = stats.Collect(db, f)
+	require.NoError(t, err)
+	_, _, scanned, err := query.SearchCounted(db, f)
+	require.NoError(t, err)
+	require.Equal(t, 50, scanned)
+
+	// the index narrows them down to the matches
+	if fts.Create(writer) == nil {
+		indexed, err := fts.Refresh(writer)
+		require.NoError(t, err)
+
+		indexedFilter := f
+		indexedFilter.Backend = query.HistoryTableBackend{CountScans: true, FullTextIndexedThrough: int64(indexed)}
+		s := store.NewSQLite(db, indexedFilter.Backend)
+		_, rows, err := s.Search(indexedFilter)
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		require.Equal(t, 2, store.RowsScanned(s))
+	}
+
+	// without counting, it isn't known
+	f.Backend = query.HistoryTableBackend{}
+	_, _, scanned, err = query.SearchCounted(db, f)
+	require.NoError(t, err)
+	require.Equal(t, -1, scanned)
+	require.Equal(t, -1, store.RowsScanned(store.NewSQLite(db, f.Backend)))
+
+	// and a store that has to look at every row says so
+	require.Equal(t, 3, store.RowsScanned(store.NewMemory(storeTestRows()[:3]...)))
+}
@@ -0,0 +1,281 @@
+package main
+
+// The benchmarks live in package main, rather than main_test like the tests,
+// since they drive the model directly.
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/store"
+	"hoelz.ro/histdb-browser/internal/table"
+)
+
+// benchmarkSizes are the sizes of the synthetic histories benchmarked against
+var benchmarkSizes = []int{10_000, 100_000, 1_000_000}
+
+// syntheticHistorySQL fills history with ? commands: a mix of everyday
+// commands, one in a thousand of which is "grep needle haystack", spread
+// over a few hosts and directories, with one in twenty failing
+const syntheticHistorySQL = `WITH RECURSIVE
+	commands(id, entry) AS (VALUES
+		(0, 'git status'), (1, 'make test'), (2, 'ls -la'), (3, 'cd ..'),
+		(4, 'vim main.go'), (5, 'go test ./...'), (6, 'docker ps'), (7, 'ssh build-host')),
+	n(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM n WHERE i < ? - 1)
+INSERT INTO history (hostname, session_id, timestamp, history_id, cwd, entry, duration, exit_status)
+	SELECT
+		'host' || (i % 3),
+		i / 50,
+		1641340800 + i * 30,
+		i % 50,
+		'/home/rob/project' || (i % 13),
+		CASE WHEN i % 1000 = 0 THEN 'grep needle haystack' ELSE (SELECT entry FROM commands WHERE id = i % 8) || ' # ' || i END,
+		i % 7,
+		CASE WHEN i % 20 = 0 THEN 1 ELSE 0 END
+	FROM n`
+
+// benchmarkBackends are the ways of querying history benchmarked against:
+// the virtual table, and the go backend with and without a full-text index
+var benchmarkBackends = []string{"vtable", "go", "go-fts"}
+
+// syntheticDatabase creates a database holding n synthetic commands, opened
+// for one of benchmarkBackends; it's skipped if this build or SQLite doesn't
+// support the backend
+func syntheticDatabase(b *testing.B, n int, backendName string) (*sql.DB, query.Backend) {
+	b.Helper()
+
+	// slow queries are the point of some of these benchmarks, so don't warn
+	// about them
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	b.Cleanup(func() { slog.SetDefault(previousLogger) })
+
+	path := filepath.Join(b.TempDir(), "history.db")
+
+	db, err := history.Open(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if _, err := db.Exec(syntheticHistorySQL, n); err != nil {
+		b.Fatal(err)
+	}
+
+	if backendName == "go-fts" {
+		if err := fts.Create(db); err != nil {
+			b.Skip(err)
+		}
+	}
+
+	db.Close()
+
+	var backend query.Backend = query.HistoryTableBackend{}
+	if backendName == "vtable" {
+		backend = query.VTableBackend{}
+	}
+
+	db, backend, err = openDatabase(path, backend)
+	if err != nil {
+		if backendName == "vtable" {
+			b.Skip(err)
+		}
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	return db, backend
+}
+
+func sizeName(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%dM", n/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%dk", n/1_000)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// syntheticMatches is how many of n synthetic commands each benchmarked
+// search matches, before any limit
+func syntheticMatches(q string, n int) int {
+	switch q {
+	case "needle", "needle hay":
+		return (n + 999) / 1000
+	case "git st":
+		// every eighth command, besides the needles
+		return (n+7)/8 - (n+999)/1000
+	default:
+		panic("no expected count for " + q)
+	}
+}
+
+// BenchmarkSearch compares searching with each backend
+func BenchmarkSearch(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		for _, backendName := range benchmarkBackends {
+			b.Run(sizeName(n)+"/"+backendName, func(b *testing.B) {
+				db, backend := syntheticDatabase(b, n, backendName)
+				s := store.NewSQLite(db, backend)
+
+				for _, q := range []string{"needle", "needle hay", "git st"} {
+					b.Run(q, func(b *testing.B) {
+						f := query.Filter{
+							Query:              q,
+							ShowFailedCommands: true,
+							ShowGlobalCommands: true,
+						}
+
+						// make sure that the search is finding what it should
+						// before timing it
+						_, rows, err := s.Search(f)
+						if err != nil {
+							b.Fatal(err)
+						}
+						if expected := min(syntheticMatches(q, n), query.DefaultLimit); len(rows) != expected {
+							b.Fatalf("%q found %d rows, expected %d", q, len(rows), expected)
+						}
+
+						for b.Loop() {
+							if _, _, err := s.Search(f); err != nil {
+								b.Fatal(err)
+							}
+						}
+					})
+				}
+			})
+		}
+	}
+}
+
+// benchmarkModel creates a browser over a synthetic history of n commands,
+// sized for a typical terminal
+func benchmarkModel(b *testing.B, n int, backendName string) *model {
+	db, backend := syntheticDatabase(b, n, backendName)
+
+	m := newModel(store.NewSQLite(db, backend), db, modelOptions{
+		initialQueryCursor: -1,
+		backend:            backend,
+	})
+	m.Init()
+
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 160, Height: 40})
+
+	return updated.(*model)
+}
+
+// BenchmarkGetRows measures running the query for the browser's state and
+// turning the results into table rows, without the query cache
+func BenchmarkGetRows(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		for _, backendName := range benchmarkBackends {
+			b.Run(sizeName(n)+"/"+backendName, func(b *testing.B) {
+				m := benchmarkModel(b, n, backendName)
+				m.input.SetValue("git")
+
+				m.refreshRows()
+				if rows := len(m.table.GetVisibleRows()); rows != query.DefaultLimit {
+					b.Fatalf("got %d rows, expected %d", rows, query.DefaultLimit)
+				}
+
+				for b.Loop() {
+					m.queryCache.Clear()
+					m.refreshRows()
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkKeystroke measures the model handling a keypress that changes the
+// query, as happens while typing a search
+func BenchmarkKeystroke(b *testing.B) {
+	typed := "git st"
+	keys := make([]tea.KeyMsg, 0, len(typed)*2)
+	for _, r := range typed {
+		keys = append(keys, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	for range typed {
+		keys = append(keys, tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+
+	for _, n := range benchmarkSizes {
+		for _, backendName := range benchmarkBackends {
+			b.Run(sizeName(n)+"/"+backendName, func(b *testing.B) {
+				var m tea.Model = benchmarkModel(b, n, backendName)
+
+				i := 0
+				for b.Loop() {
+					// otherwise the backspaces would only ever hit the cache
+					m.(*model).queryCache.Clear()
+					m, _ = m.Update(keys[i%len(keys)])
+					i++
+				}
+			})
+		}
+	}
+}
+
+// benchmarkTable creates a table of n rows, like the browser's, every tenth
+// of which spans several lines
+func benchmarkTable(n int) *table.Table {
+	rows := make([]table.Row, n)
+	for i := range rows {
+		entry := fmt.Sprintf("git commit -m 'Change number %d'", i)
+		if i%10 == 0 {
+			entry = fmt.Sprintf("for i in %d; do\n  echo $i\ndone", i)
+		}
+
+		rows[i] = table.NewRow(table.RowData{
+			"timestamp": "2022-01-05 00:00:00",
+			"entry":     entry,
+		})
+	}
+
+	return table.New([]table.Column{
+		table.NewColumn("timestamp", "timestamp", 20),
+		table.NewFlexColumn("entry", "entry", 1),
+	}).WithRows(rows).WithTargetWidth(160).WithTargetHeight(20)
+}
+
+var benchmarkTableSizes = []int{query.DefaultLimit, 10_000, 100_000}
+
+func BenchmarkTableView(b *testing.B) {
+	for _, n := range benchmarkTableSizes {
+		b.Run(sizeName(n), func(b *testing.B) {
+			t := benchmarkTable(n)
+
+			for b.Loop() {
+				_ = t.View()
+			}
+		})
+	}
+}
+
+func BenchmarkTableMoveHighlight(b *testing.B) {
+	for _, n := range benchmarkTableSizes {
+		b.Run(sizeName(n), func(b *testing.B) {
+			t := benchmarkTable(n)
+
+			i := 0
+			for b.Loop() {
+				// down through every row, then back up
+				if (i/(n-1))%2 == 0 {
+					t = t.MoveHighlight(1)
+				} else {
+					t = t.MoveHighlight(-1)
+				}
+				i++
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+)
+
+func runKeygen(args []string) error {
+	flags := pflag.NewFlagSet("keygen", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser keygen KEY-FILE")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Generates a key for encrypting exports and sync changesets with --key-file.")
+		fmt.Fprintln(os.Stderr, "Every host syncing through the same directory needs a copy of it.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one key file")
+	}
+
+	// O_EXCL so that we never clobber a key that something was encrypted with
+	f, err := os.OpenFile(flags.Arg(0), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(f, bundle.GenerateKeyFile()); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func runDecrypt(args []string) error {
+	kf := keyFlags{}
+	outputFilename := ""
+
+	flags := pflag.NewFlagSet("decrypt", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser decrypt [flags] [BUNDLE]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Decrypts an encrypted export or sync changeset (standard input by default).")
+		flags.PrintDefaults()
+	}
+	kf.register(flags, false)
+	flags.StringVarP(&outputFilename, "output-file", "o", outputFilename, "The file to decrypt to (defaults to standard output)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("expected at most one bundle")
+	}
+
+	key, err := kf.requiredKey()
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+
+	if flags.NArg() == 1 {
+		inputFile, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer inputFile.Close()
+		in = inputFile
+	}
+
+	r, err := bundle.NewReader(in, key)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+
+	if outputFilename != "" {
+		// write to a temporary file first so that a tampered bundle doesn't
+		// leave a partial plaintext behind
+		outputFile, err := os.OpenFile(outputFilename+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(outputFile.Name())
+		defer outputFile.Close()
+		out = outputFile
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		return err
+	}
+
+	if outputFilename != "" {
+		outputFile := out.(*os.File)
+		if err := outputFile.Close(); err != nil {
+			return err
+		}
+		return os.Rename(outputFile.Name(), outputFilename)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+)
+
+func testKey(t *testing.T) *bundle.Key {
+	t.Helper()
+
+	key, err := bundle.ParseKeyFile([]byte(bundle.GenerateKeyFile()))
+	require.NoError(t, err)
+	return key
+}
+
+func encryptBundle(t *testing.T, key *bundle.Key, plaintext []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := bundle.NewWriter(&buf, key)
+	require.NoError(t, err)
+
+	_, err = w.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func decryptBundle(key *bundle.Key, encrypted []byte) ([]byte, error) {
+	r, err := bundle.NewReader(bytes.NewReader(encrypted), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	key := testKey(t)
+
+	for _, size := range []int{0, 1, 64*1024 - 1, 64 * 1024, 64*1024 + 1, 200 * 1024} {
+		plaintext := bytes.Repeat([]byte("git status\n"), size/11+1)[:size]
+
+		encrypted := encryptBundle(t, key, plaintext)
+		require.True(t, bundle.IsEncrypted(bufio.NewReader(bytes.NewReader(encrypted))))
+		require.NotContains(t, string(encrypted), "git status")
+
+		decrypted, err := decryptBundle(key, encrypted)
+		require.NoError(t, err, "size %d", size)
+		require.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestBundlePassphrase(t *testing.T) {
+	plaintext := []byte(`{"entry":"ls"}` + "\n")
+	encrypted := encryptBundle(t, bundle.PassphraseKey("correct horse battery staple"), plaintext)
+
+	decrypted, err := decryptBundle(bundle.PassphraseKey("correct horse battery staple"), encrypted)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+
+	_, err = decryptBundle(bundle.PassphraseKey("incorrect horse battery staple"), encrypted)
+	require.ErrorIs(t, err, bundle.ErrWrongKey)
+
+	_, err = decryptBundle(testKey(t), encrypted)
+	require.ErrorIs(t, err, bundle.ErrWrongKey)
+}
+
+func TestBundleWrongKey(t *testing.T) {
+	encrypted := encryptBundle(t, testKey(t), []byte("ls\n"))
+
+	_, err := decryptBundle(testKey(t), encrypted)
+	require.ErrorIs(t, err, bundle.ErrWrongKey)
+}
+
+func TestBundleTampering(t *testing.T) {
+	key := testKey(t)
+	plaintext := bytes.Repeat([]byte("make test\n"), 20000)
+	encrypted := encryptBundle(t, key, plaintext)
+
+	const headerSize = 8 + 1 + 1 + 4 + 16 + 32
+	const sealedChunkSize = 64*1024 + 16
+
+	tests := map[string]func([]byte) []byte{
+		"flipped ciphertext bit": func(b []byte) []byte {
+			b[headerSize+100] ^= 1
+			return b
+		},
+		"flipped tag bit": func(b []byte) []byte {
+			b[len(b)-1] ^= 1
+			return b
+		},
+		"truncated to a chunk boundary": func(b []byte) []byte {
+			return b[:headerSize+sealedChunkSize]
+		},
+		"truncated mid-chunk": func(b []byte) []byte {
+			return b[:len(b)-10]
+		},
+		"header only": func(b []byte) []byte {
+			return b[:headerSize]
+		},
+		"partial header": func(b []byte) []byte {
+			return b[:headerSize-1]
+		},
+		"appended data": func(b []byte) []byte {
+			return append(b, 0)
+		},
+		"swapped chunks": func(b []byte) []byte {
+			first := bytes.Clone(b[headerSize : headerSize+sealedChunkSize])
+			copy(b[headerSize:], b[headerSize+sealedChunkSize:headerSize+2*sealedChunkSize])
+			copy(b[headerSize+sealedChunkSize:], first)
+			return b
+		},
+		"dropped chunk": func(b []byte) []byte {
+			return append(b[:headerSize:headerSize], b[headerSize+sealedChunkSize:]...)
+		},
+	}
+
+	for name, tamper := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decryptBundle(key, tamper(bytes.Clone(encrypted)))
+			require.ErrorIs(t, err, bundle.ErrTampered)
+		})
+	}
+
+	// the iterations and salt change the derived keys, and so can't be told
+	// apart from the wrong key
+	for name, offset := range map[string]int{"iterations": 13, "salt": 20, "commitment": headerSize - 1} {
+		t.Run("modified "+name, func(t *testing.T) {
+			b := bytes.Clone(encrypted)
+			b[offset] ^= 1
+			_, err := decryptBundle(key, b)
+			require.ErrorIs(t, err, bundle.ErrWrongKey)
+		})
+	}
+
+	t.Run("absurd iterations", func(t *testing.T) {
+		b := bytes.Clone(encrypted)
+		b[10] = 0xff
+		_, err := decryptBundle(key, b)
+		require.ErrorIs(t, err, bundle.ErrTampered)
+	})
+}
+
+func TestBundleVersions(t *testing.T) {
+	key := testKey(t)
+	encrypted := encryptBundle(t, key, []byte("ls\n"))
+
+	encrypted[8] = 2
+	_, err := decryptBundle(key, encrypted)
+	require.ErrorIs(t, err, bundle.ErrUnsupportedVersion)
+
+	_, err = decryptBundle(key, []byte(`{"entry":"ls"}`+"\n"+strings.Repeat(" ", 100)))
+	require.ErrorIs(t, err, bundle.ErrNotEncrypted)
+	require.False(t, bundle.IsEncrypted(bufio.NewReader(strings.NewReader(`{"entry":"ls"}`))))
+}
+
+func TestBundleKeyFile(t *testing.T) {
+	_, err := bundle.ParseKeyFile([]byte("not a key"))
+	require.Error(t, err)
+
+	_, err = bundle.ParseKeyFile([]byte("histdb-key-v1:c2hvcnQ=\n"))
+	require.Error(t, err)
+
+	require.NotEqual(t, bundle.GenerateKeyFile(), bundle.GenerateKeyFile())
+}
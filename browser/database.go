@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+func defaultDatabasePath() string {
+	if path := os.Getenv("HISTDB_PATH"); path != "" {
+		return path
+	}
+	return "/home/rob/.zsh_history.db"
+}
+
+// scanCountingDriver is the go-sqlite3 driver with the functions the go
+// backend counts the rows its queries scan with (see query.RegisterScanCounter)
+const scanCountingDriver = "sqlite3-histdb-scan-counting"
+
+func init() {
+	sql.Register(scanCountingDriver, &sqlite3.SQLiteDriver{ConnectHook: query.RegisterScanCounter})
+}
+
+// openDatabase opens the history database at path, set up for querying
+// through backend, which it returns adjusted for the database - using its
+// full-text index, if it has one
+func openDatabase(path string, backend query.Backend) (*sql.DB, query.Backend, error) {
+	// SQLite would happily create a database that isn't there
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	}
+
+	driverName := "sqlite3"
+
+	if _, isHistoryTable := backend.(query.HistoryTableBackend); isHistoryTable {
+		driverName = scanCountingDriver
+	}
+
+	if _, isVTable := backend.(query.VTableBackend); isVTable {
+		var err error
+		driverName, err = vtableDriver()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	db, err := sql.Open(driverName, history.DSN(path, nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// sql.Open doesn't actually connect, so do that now to surface any errors
+	// from loading the extension, if there is one
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	if b, isHistoryTable := backend.(query.HistoryTableBackend); isHistoryTable {
+		indexedThrough, err := refreshIndex(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		b.FullTextIndexedThrough = indexedThrough
+		b.CountScans = true
+		backend = b
+	}
+
+	return db, backend, nil
+}
+
+// refreshIndex brings db's full-text index (if it has one) up to date,
+// returning the highest rowid it covers, or zero without one
+func refreshIndex(db *sql.DB) (int64, error) {
+	exists, err := fts.Exists(db)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	// the backend copes with an index that's behind, so failing to refresh it
+	// (if the database is read-only, say) isn't fatal - unless rows it has
+	// indexed have since changed, in which case it's not to be trusted
+	if added, err := fts.Refresh(db); err != nil {
+		slog.Warn("unable to refresh the full-text index", "error", err)
+
+		if stale, err := fts.Stale(db); err != nil || stale {
+			slog.Warn("not using the stale full-text index")
+			return 0, err
+		}
+	} else if added > 0 {
+		slog.Debug("refreshed the full-text index", "added", added)
+	}
+
+	return fts.IndexedThrough(db)
+}
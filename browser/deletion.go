@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// deleted rows are only hidden for this long before they're actually removed,
+// so that a slip of the finger can be undone
+const undoWindow = 5 * time.Second
+
+type pendingDeletion struct {
+	id     int
+	rowids []int64
+}
+
+// deletionTimeoutMsg says that the undo window for a deletion has passed
+type deletionTimeoutMsg struct {
+	id int
+}
+
+// rowid extracts the rowid from a row's data, which the query hands back as a
+// string
+func rowid(data map[string]any) (int64, bool) {
+	s, isString := data["rowid"].(string)
+	if !isString {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	return id, err == nil
+}
+
+// deletionTargets are the rows a deletion would affect: the marked ones if
+// there are any, or the highlighted one otherwise.  Only marked rows that are
+// shown count, so that nothing the query has since filtered out is deleted
+// sight unseen.
+func (m *model) deletionTargets() []int64 {
+	if len(m.marked) > 0 {
+		targets := make([]int64, 0, len(m.marked))
+		for _, row := range m.table.GetVisibleRows() {
+			if id, ok := rowid(row.Data); ok && m.marked[id] {
+				targets = append(targets, id)
+			}
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+		slices.Sort(targets)
+		return targets
+	}
+
+	if id, ok := rowid(m.table.HighlightedRow().Data); ok {
+		return []int64{id}
+	}
+
+	return nil
+}
+
+func (m *model) toggleMark() {
+	id, ok := rowid(m.table.HighlightedRow().Data)
+	if !ok {
+		return
+	}
+
+	// copy so that earlier models don't see the change
+	marked := make(map[int64]bool, len(m.marked)+1)
+	for k := range m.marked {
+		marked[k] = true
+	}
+
+	if marked[id] {
+		delete(marked, id)
+	} else {
+		marked[id] = true
+	}
+
+	m.marked = marked
+}
+
+// startDeletion hides rowids from the results, deleting them for real once
+// the undo window passes
+func (m *model) startDeletion(rowids []int64) tea.Cmd {
+	// only one deletion can be undone at a time
+	if err := m.commitPendingDeletion(); err != nil {
+		m.flashMessage = fmt.Sprintf("Unable to delete entries: %v", err)
+		return nil
+	}
+
+	m.nextDeletionID++
+	id := m.nextDeletionID
+
+	m.pendingDeletion = &pendingDeletion{id: id, rowids: rowids}
+
+	// marks on rows that weren't shown stay put
+	marked := make(map[int64]bool)
+	for k := range m.marked {
+		if !slices.Contains(rowids, k) {
+			marked[k] = true
+		}
+	}
+	m.marked = marked
+
+	slog.Info("deleting entries", "rowids", rowids, "undo_window", undoWindow)
+	m.flashMessage = fmt.Sprintf("Deleted %d %s - ctrl+z to undo", len(rowids), pluralize(len(rowids), "entry", "entries"))
+
+	return tea.Tick(undoWindow, func(time.Time) tea.Msg {
+		return deletionTimeoutMsg{id: id}
+	})
+}
+
+func (m *model) undoDeletion() {
+	if m.pendingDeletion == nil {
+		m.flashMessage = "Nothing to undo"
+		return
+	}
+
+	n := len(m.pendingDeletion.rowids)
+	slog.Info("undoing deletion", "rowids", m.pendingDeletion.rowids)
+	m.pendingDeletion = nil
+	m.flashMessage = fmt.Sprintf("Restored %d %s", n, pluralize(n, "entry", "entries"))
+}
+
+// commitPendingDeletion removes the rows pending deletion (if any) from the
+// store
+func (m *model) commitPendingDeletion() error {
+	if m.pendingDeletion == nil {
+		return nil
+	}
+
+	rowids := m.pendingDeletion.rowids
+	m.pendingDeletion = nil
+
+	deleted, err := m.store.Delete(rowids)
+	if err != nil {
+		return err
+	}
+	m.queryCache.Clear()
+
+	slog.Info("deleted entries", "rowids", rowids, "deleted", deleted)
+
+	return nil
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
@@ -0,0 +1,72 @@
+package main
+
+// Like the benchmarks, this lives in package main rather than main_test,
+// since it drives the model directly.
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/replay"
+)
+
+func TestDeletionFlow(t *testing.T) {
+	var m tea.Model = memoryModel(t, modelOptions{initialQueryCursor: -1}, "git status", "make test", "git push", "ls")
+
+	press := func(keys ...string) tea.Cmd {
+		var cmd tea.Cmd
+		for _, k := range keys {
+			m, cmd = m.Update(replay.KeyMsg(k))
+		}
+		return cmd
+	}
+	entries := func() []string {
+		return visibleEntries(m)
+	}
+
+	require.Equal(t, []string{"ls", "git push", "make test", "git status"}, entries())
+
+	// mark "git push" and "make test", then search for something that only
+	// one of them matches
+	press("down", "f8", "down", "f8")
+	press("g", "i", "t")
+	require.Equal(t, []string{"git push", "git status"}, entries())
+
+	// only the marked row that's shown is deleted
+	press("f9")
+	require.Equal(t, []int64{3}, m.(*model).confirmingDeletion)
+	require.Contains(t, m.View(), "Delete 1 entry from history (not counting 1 marked entry out of view)? [y/N]")
+
+	cmd := press("y")
+	require.NotNil(t, cmd, "the undo window starts")
+	require.Equal(t, []string{"git status"}, entries())
+	require.Equal(t, map[int64]bool{2: true}, m.(*model).marked, "the other mark stays put")
+
+	// which can be undone
+	press("ctrl+z")
+	require.Equal(t, []string{"git push", "git status"}, entries())
+	require.Nil(t, m.(*model).pendingDeletion)
+
+	// and isn't committed once the undo window passes
+	stored := func() int {
+		session, err := m.(*model).store.Session("1")
+		require.NoError(t, err)
+		return len(session)
+	}
+	m, _ = m.Update(deletionTimeoutMsg{id: 1})
+	require.Equal(t, 4, stored())
+
+	// the restored row isn't marked any more, and the marked one still isn't
+	// shown, so there's nothing to delete
+	press("f9")
+	require.Nil(t, m.(*model).confirmingDeletion)
+	require.Contains(t, m.View(), "None of the marked entries are shown")
+
+	// without an undo, it's deleted for real
+	press("f8", "f9", "y")
+	m, _ = m.Update(deletionTimeoutMsg{id: 2})
+	require.Equal(t, 3, stored())
+	require.Equal(t, []string{"git status"}, entries())
+}
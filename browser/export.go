@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+	"hoelz.ro/histdb-browser/internal/export"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+func runExport(args []string) error {
+	format := "jsonl"
+	outputFilename := ""
+	since := ""
+	until := ""
+	databasePath := defaultDatabasePath()
+	kf := keyFlags{}
+	rf := redactFlags{}
+	f := history.Filter{}
+
+	flags := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser export [flags]")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", format, "The format to export in ("+strings.Join(export.Formats, ", ")+")")
+	flags.StringVarP(&outputFilename, "output-file", "o", outputFilename, "The file to export to (defaults to standard output)")
+	flags.StringVar(&since, "since", "", "Only export commands run at or after this time (e.g. 2022-03-01 or 7d)")
+	flags.StringVar(&until, "until", "", "Only export commands run before this time (e.g. 2022-03-01 or 7d)")
+	flags.StringVar(&f.Hostname, "host", "", "Only export commands run on this host")
+	flags.StringVar(&f.SessionID, "session-id", "", "Only export commands run in this session")
+	flags.StringVar(&f.CwdPrefix, "cwd", "", "Only export commands run in this directory or beneath it")
+	kf.register(flags, true)
+	rf.register(flags, true)
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to export from (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+
+	if since != "" {
+		t, err := query.ParseTime(since, now)
+		if err != nil {
+			return err
+		}
+		f.Since = t
+	}
+
+	if until != "" {
+		t, err := query.ParseTime(until, now)
+		if err != nil {
+			return err
+		}
+		f.Until = t
+	}
+
+	key, err := kf.key()
+	if err != nil {
+		return err
+	}
+
+	redactor, err := rf.redactor()
+	if err != nil {
+		return err
+	}
+
+	// check everything that can go wrong before the output file is touched
+	if _, err := export.NewWriter(format, io.Discard); err != nil {
+		return err
+	}
+
+	db, err := history.OpenReadOnly(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	var outputFile *os.File
+
+	if outputFilename != "" {
+		// write to a temporary file alongside the output file, so that a failed
+		// export doesn't clobber a previous one
+		outputFile, err = os.CreateTemp(filepath.Dir(outputFilename), "."+filepath.Base(outputFilename)+"-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(outputFile.Name())
+		defer outputFile.Close()
+		out = outputFile
+	}
+
+	var encrypted io.WriteCloser
+
+	if key != nil {
+		encrypted, err = bundle.NewWriter(out, key)
+		if err != nil {
+			return err
+		}
+		out = encrypted
+	}
+
+	w, err := export.NewWriter(format, out)
+	if err != nil {
+		return err
+	}
+
+	err = history.Each(db, f, func(r history.Row) error {
+		r.Entry = redactor.Redact(r.Entry)
+		return w.Write(r)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if encrypted != nil {
+		if err := encrypted.Close(); err != nil {
+			return err
+		}
+	}
+
+	if outputFile != nil {
+		if err := outputFile.Close(); err != nil {
+			return err
+		}
+		return os.Rename(outputFile.Name(), outputFilename)
+	}
+
+	return nil
+}
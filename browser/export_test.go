@@ -0,0 +1,106 @@
+package main_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/export"
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+var exportRows = []history.Row{
+	{Hostname: "host1", SessionID: "737207", Timestamp: time.Unix(1641340800, 0), HistoryID: ptr(int64(7)), Cwd: "/home/rob/src", Entry: "make test", Duration: ptr(int64(5)), ExitStatus: ptr(int64(0))},
+	{Hostname: "host1", SessionID: "737207", Timestamp: time.Unix(1641340860, 0), HistoryID: ptr(int64(8)), Cwd: "/home/rob/src/histdb", Entry: "for f in *; do\n  echo \"$f, a—b\"\ndone", Duration: ptr(int64(1)), ExitStatus: ptr(int64(1))},
+	{Hostname: "host2", SessionID: "017e2262", Timestamp: time.Unix(1641427200, 0), Cwd: "/home/rob/srcfoo", Entry: "pwd"},
+	{Hostname: "host2", SessionID: "017e2262", Timestamp: time.Unix(1641513600, 0), Cwd: "/tmp", Entry: "ls"},
+}
+
+func exportTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	// insert out of order to make sure exports are chronological
+	rows := slices.Clone(exportRows)
+	slices.Reverse(rows)
+
+	db, _ := testDB(t, rows...)
+	return db
+}
+
+func exportString(t *testing.T, db *sql.DB, format string, f history.Filter) string {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	w, err := export.NewWriter(format, &b)
+	require.NoError(t, err)
+	require.NoError(t, history.Each(db, f, w.Write))
+	require.NoError(t, w.Close())
+
+	return b.String()
+}
+
+func TestExportJSONL(t *testing.T) {
+	db := exportTestDB(t)
+
+	lines := strings.Split(strings.TrimSuffix(exportString(t, db, "jsonl", history.Filter{}), "\n"), "\n")
+	require.Len(t, lines, len(exportRows))
+
+	for i, line := range lines {
+		var got export.Record
+		require.NoError(t, json.Unmarshal([]byte(line), &got))
+		require.Equal(t, export.NewRecord(exportRows[i]), got)
+	}
+
+	require.Contains(t, lines[2], `"duration":null`)
+}
+
+func TestExportCSV(t *testing.T) {
+	db := exportTestDB(t)
+
+	records, err := csv.NewReader(strings.NewReader(exportString(t, db, "csv", history.Filter{}))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, len(exportRows)+1)
+	require.Equal(t, history.Columns, records[0])
+	require.Equal(t, exportRows[1].Entry, records[2][5])
+	require.Equal(t, []string{"host2", "017e2262", "1641427200", "", "/home/rob/srcfoo", "pwd", "", ""}, records[3])
+}
+
+func TestExportZshRoundTrip(t *testing.T) {
+	db := exportTestDB(t)
+
+	entries, err := histfile.ParseZsh(strings.NewReader(exportString(t, db, "zsh", history.Filter{})))
+	require.NoError(t, err)
+	require.Len(t, entries, len(exportRows))
+
+	for i, e := range entries {
+		require.Equal(t, exportRows[i].Entry, e.Command)
+		require.Equal(t, exportRows[i].Timestamp, e.Timestamp)
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	db := exportTestDB(t)
+
+	entries := func(f history.Filter) []string {
+		result := make([]string, 0)
+		require.NoError(t, history.Each(db, f, func(r history.Row) error {
+			result = append(result, r.Entry)
+			return nil
+		}))
+		return result
+	}
+
+	require.Equal(t, []string{"make test", exportRows[1].Entry}, entries(history.Filter{CwdPrefix: "/home/rob/src/"}))
+	require.Equal(t, []string{"pwd", "ls"}, entries(history.Filter{Hostname: "host2"}))
+	require.Equal(t, []string{"make test", exportRows[1].Entry}, entries(history.Filter{SessionID: "737207"}))
+	require.Equal(t, []string{exportRows[1].Entry, "pwd"}, entries(history.Filter{Since: time.Unix(1641340860, 0), Until: time.Unix(1641513600, 0)}))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+// filterFlags are the flags shared by the subcommands that select history
+// the same way the browser does
+type filterFlags struct {
+	horizonTimestamp   uint64
+	hideFailedCommands bool
+	hideGlobalCommands bool
+	since              string
+	until              string
+}
+
+func (ff *filterFlags) register(flags *pflag.FlagSet, f *query.Filter) {
+	flags.StringVar(&f.Query, "query", "", "The query to search for (in addition to any positional arguments)")
+	flags.BoolVar(&ff.hideFailedCommands, "hide-failed", false, "Hide commands that failed")
+	flags.BoolVar(&ff.hideGlobalCommands, "hide-global", false, "Hide commands from other sessions newer than --horizon-timestamp")
+	flags.Uint64Var(&ff.horizonTimestamp, "horizon-timestamp", 0, "The maximum timestamp to consider for results outside of this session")
+	flags.StringVar(&f.SessionID, "session-id", strconv.Itoa(os.Getppid()), "The current session ID")
+	flags.StringVar(&ff.since, "since", "", "Only include commands run at or after this time (e.g. 2022-03-01 or 7d)")
+	flags.StringVar(&ff.until, "until", "", "Only include commands run before this time (e.g. 2022-03-01 or 7d)")
+	flags.StringVar(&f.Hostname, "host", "", "Only include commands run on this host")
+}
+
+// apply fills in the parts of f that need parsing or inverting once the flags
+// have been parsed
+func (ff *filterFlags) apply(f *query.Filter) error {
+	now := time.Now()
+
+	f.ShowFailedCommands = !ff.hideFailedCommands
+	f.ShowGlobalCommands = !ff.hideGlobalCommands
+	f.HorizonTimestamp = time.Unix(int64(ff.horizonTimestamp), 0)
+
+	if ff.since != "" {
+		since, err := query.ParseTime(ff.since, now)
+		if err != nil {
+			return err
+		}
+		f.Since = since
+	}
+
+	if ff.until != "" {
+		until, err := query.ParseTime(ff.until, now)
+		if err != nil {
+			return err
+		}
+		f.Until = until
+	}
+
+	return f.Validate()
+}
@@ -0,0 +1,218 @@
+package main_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+// indexedTestDB creates a database holding rows, along with a full-text
+// index of them, skipping the test if this SQLite doesn't have FTS5
+func indexedTestDB(t *testing.T, rows []history.Row) *sql.DB {
+	db, _ := testDB(t, rows...)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	if err := fts.Create(db); err != nil {
+		t.Skip(err)
+	}
+
+	_, err := fts.Refresh(db)
+	require.NoError(t, err)
+
+	return db
+}
+
+// matchingRowids returns the rowids of the rows matching column MATCH expr
+// through backend, in order
+func matchingRowids(t *testing.T, db *sql.DB, backend query.Backend, column, expr string) []int64 {
+	from, params := backend.From()
+	match, matchParams := backend.Match(column, expr)
+
+	rows, err := db.Query("SELECT rowid FROM "+from+" WHERE "+match+" ORDER BY rowid", append(params, matchParams...)...)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	rowids := make([]int64, 0)
+	for rows.Next() {
+		var rowid int64
+		require.NoError(t, rows.Scan(&rowid))
+		rowids = append(rowids, rowid)
+	}
+	require.NoError(t, rows.Err())
+
+	return rowids
+}
+
+func TestFullTextIndex(t *testing.T) {
+	rows := make([]history.Row, 0)
+	for i, entry := range []string{
+		"git commit -m 'Fix the browser'",
+		"git push origin",
+		"make test",
+		`echo "quoted words"`,
+		"GIT STATUS",
+		"ls",
+	} {
+		rows = append(rows, history.Row{
+			SessionID: "1",
+			Hostname:  "host1",
+			Cwd:       []string{"/home/rob/histdb", "/tmp"}[i%2],
+			Entry:     entry,
+			Timestamp: time.Unix(1641340800+int64(i), 0),
+		})
+	}
+
+	db := indexedTestDB(t, rows)
+
+	indexedThrough, err := fts.IndexedThrough(db)
+	require.NoError(t, err)
+	require.EqualValues(t, len(rows), indexedThrough)
+
+	unindexed := query.HistoryTableBackend{}
+	indexed := query.HistoryTableBackend{FullTextIndexedThrough: indexedThrough}
+
+	for _, test := range []struct {
+		column, expr string
+	}{
+		{"entry", "git"},
+		{"entry", "browser GIT"},
+		{"entry", "it"},
+		{"entry", "git m"},
+		{"entry", `"quoted`},
+		{"entry", "nothing"},
+		{"cwd", "histdb"},
+		{"cwd", "mp"},
+	} {
+		t.Run(test.column+" "+test.expr, func(t *testing.T) {
+			expected := matchingRowids(t, db, unindexed, test.column, test.expr)
+			require.Equal(t, expected, matchingRowids(t, db, indexed, test.column, test.expr))
+		})
+	}
+
+	// rows added since the index was refreshed are still found
+	_, err = history.Insert(db, history.Row{SessionID: "2", Cwd: "/tmp", Entry: "git log", Timestamp: time.Unix(1641340900, 0)})
+	require.NoError(t, err)
+	require.Len(t, matchingRowids(t, db, indexed, "entry", "git log"), 1)
+
+	added, err := fts.Refresh(db)
+	require.NoError(t, err)
+	require.Equal(t, 1, added)
+
+	added, err = fts.Refresh(db)
+	require.NoError(t, err)
+	require.Zero(t, added, "refreshing is incremental")
+
+	// deleting commands removes them from the index
+	deleted, err := store.NewSQLite(db, indexed).Delete([]int64{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM histdb_fts").Scan(&count))
+	require.Equal(t, len(rows)-1, count)
+
+	// as does pruning, for commands deleted without the browser
+	_, err = db.Exec("DELETE FROM history WHERE rowid = 3")
+	require.NoError(t, err)
+	pruned, err := fts.Prune(db)
+	require.NoError(t, err)
+	require.Equal(t, 1, pruned)
+
+	// changed commands are picked up by reindexing them
+	require.NoError(t, history.SetEntry(db, 5, "git status --short"))
+	require.NoError(t, fts.Reindex(db, []int64{5}))
+	require.Equal(t, []int64{5}, matchingRowids(t, db, indexed, "entry", "short"))
+
+	require.NoError(t, fts.Drop(db))
+	exists, err := fts.Exists(db)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// and without an index, there's nothing to keep up to date
+	require.NoError(t, fts.Forget(db, []int64{4}))
+	require.NoError(t, fts.Reindex(db, []int64{4}))
+}
+
+func TestFullTextIndexReusedRowids(t *testing.T) {
+	rows := make([]history.Row, 0)
+	for i, entry := range []string{"make test", "git status", "git push --force"} {
+		rows = append(rows, history.Row{
+			SessionID: "1",
+			Cwd:       "/tmp",
+			Entry:     entry,
+			Timestamp: time.Unix(1641340800+int64(i), 0),
+		})
+	}
+
+	db := indexedTestDB(t, rows)
+	indexed := query.HistoryTableBackend{FullTextIndexedThrough: 3}
+
+	// deleting the newest row behind the index's back frees its rowid up for
+	// the next one
+	_, err := db.Exec("DELETE FROM history WHERE rowid = 3")
+	require.NoError(t, err)
+	rowid, err := history.Insert(db, history.Row{SessionID: "2", Cwd: "/tmp", Entry: "echo hello", Timestamp: time.Unix(1641340900, 0)})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, rowid)
+
+	require.Empty(t, matchingRowids(t, db, indexed, "entry", "force"), "stale text in the index doesn't match")
+
+	stale, err := fts.Stale(db)
+	require.NoError(t, err)
+	require.True(t, stale)
+
+	added, err := fts.Refresh(db)
+	require.NoError(t, err)
+	require.Equal(t, 1, added, "only the reused row is re-indexed")
+
+	require.Equal(t, []int64{3}, matchingRowids(t, db, indexed, "entry", "hello"))
+	require.Empty(t, matchingRowids(t, db, indexed, "entry", "force"))
+
+	stale, err = fts.Stale(db)
+	require.NoError(t, err)
+	require.False(t, stale)
+
+	// rows other than the newest can change too, say if history is
+	// renumbered, while the newest still matches
+	_, err = db.Exec("UPDATE history SET entry = 'git push --force' WHERE rowid = 1")
+	require.NoError(t, err)
+
+	stale, err = fts.Stale(db)
+	require.NoError(t, err)
+	require.True(t, stale)
+
+	added, err = fts.Refresh(db)
+	require.NoError(t, err)
+	require.Equal(t, 1, added)
+
+	require.Equal(t, []int64{1}, matchingRowids(t, db, indexed, "entry", "force"))
+
+	// as can rows deleted from the middle of history
+	_, err = db.Exec("DELETE FROM history WHERE rowid = 2")
+	require.NoError(t, err)
+
+	stale, err = fts.Stale(db)
+	require.NoError(t, err)
+	require.True(t, stale)
+
+	added, err = fts.Refresh(db)
+	require.NoError(t, err)
+	require.Zero(t, added)
+
+	stale, err = fts.Stale(db)
+	require.NoError(t, err)
+	require.False(t, stale)
+}
+
+func TestFullTextQuery(t *testing.T) {
+	require.Equal(t, `entry : "git" AND entry : "say ""hi"""`, fts.Query([]string{"entry"}, []string{"git", `say "hi"`}))
+	require.Equal(t, `{entry cwd} : "tmp"`, fts.Query([]string{"entry", "cwd"}, []string{"tmp"}))
+}
@@ -0,0 +1,178 @@
+package main_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+// testDB creates a database holding rows, returning their rowids alongside it
+func testDB(t *testing.T, rows ...history.Row) (*sql.DB, []int64) {
+	t.Helper()
+
+	db, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	rowids := make([]int64, 0, len(rows))
+	for _, r := range rows {
+		rowid, err := history.Insert(tx, r)
+		require.NoError(t, err)
+		rowids = append(rowids, rowid)
+	}
+	require.NoError(t, tx.Commit())
+
+	return db, rowids
+}
+
+func TestHistoryRecord(t *testing.T) {
+	db, _ := testDB(t)
+
+	historyID := int64(7)
+	startedAt := time.Unix(1641340800, 0)
+
+	rowid, err := history.Insert(db, history.Row{
+		Hostname:  "host1",
+		SessionID: "737207",
+		Timestamp: startedAt,
+		HistoryID: &historyID,
+		Cwd:       "/home/rob",
+		Entry:     "make test",
+	})
+	require.NoError(t, err)
+
+	r, err := history.Scan(db.QueryRow("SELECT "+history.SelectColumns+" FROM history WHERE rowid = ?", rowid))
+	require.NoError(t, err)
+	require.Equal(t, "make test", r.Entry)
+	require.Nil(t, r.Duration, "duration isn't known until the command finishes")
+	require.Nil(t, r.ExitStatus, "exit status isn't known until the command finishes")
+
+	require.NoError(t, history.Finish(db, rowid, startedAt.Add(5*time.Second), 2))
+
+	r, err = history.Scan(db.QueryRow("SELECT "+history.SelectColumns+" FROM history WHERE rowid = ?", rowid))
+	require.NoError(t, err)
+	require.Equal(t, history.Row{
+		Rowid:      rowid,
+		Hostname:   "host1",
+		SessionID:  "737207",
+		Timestamp:  startedAt,
+		HistoryID:  &historyID,
+		Cwd:        "/home/rob",
+		Entry:      "make test",
+		Duration:   ptr(int64(5)),
+		ExitStatus: ptr(int64(2)),
+	}, r)
+
+	require.ErrorIs(t, history.Finish(db, rowid+1, time.Now(), 0), history.ErrNoSuchRow)
+}
+
+func TestHistoryRecordDuringRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	writer, err := history.Open(path)
+	require.NoError(t, err)
+	defer writer.Close()
+
+	for i := range 10 {
+		_, err := history.Insert(writer, history.Row{Timestamp: time.Unix(int64(i), 0), Entry: "ls"})
+		require.NoError(t, err)
+	}
+
+	reader, err := history.Open(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	// hold a read transaction open, like the browser does while iterating over results
+	rows, err := reader.Query("SELECT entry FROM history")
+	require.NoError(t, err)
+	defer rows.Close()
+	require.True(t, rows.Next())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := history.Insert(writer, history.Row{Timestamp: time.Now(), Entry: "make test"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("recording a command blocked on an open read")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestHistoryDelete(t *testing.T) {
+	rows := make([]history.Row, 0)
+	for i, entry := range []string{"ls", "hunter2", "git status"} {
+		rows = append(rows, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+	}
+	db, rowids := testDB(t, rows...)
+
+	deleted, err := history.Delete(db, []int64{rowids[1], rowids[2] + 100})
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted, "rows that don't exist aren't counted")
+
+	entries := make([]string, 0)
+	require.NoError(t, history.Each(db, history.Filter{}, func(r history.Row) error {
+		entries = append(entries, r.Entry)
+		return nil
+	}))
+	require.Equal(t, []string{"ls", "git status"}, entries)
+}
+
+func TestHistoryInsertAfterOverlap(t *testing.T) {
+	db, _ := testDB(t)
+
+	rows := func(hostname string, entries ...string) []history.Row {
+		rows := make([]history.Row, 0, len(entries))
+		for i, entry := range entries {
+			rows = append(rows, history.Row{Hostname: hostname, SessionID: "import", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+		}
+		return rows
+	}
+
+	inserted, err := history.InsertAfterOverlap(db, rows("host1", "a", "b", "a", "b", "a"))
+	require.NoError(t, err)
+	require.Equal(t, 5, inserted)
+
+	// "a b a" is the longest run that ends the session and starts these
+	inserted, err = history.InsertAfterOverlap(db, rows("host1", "a", "b", "a", "c"))
+	require.NoError(t, err)
+	require.Equal(t, 1, inserted)
+
+	inserted, err = history.InsertAfterOverlap(db, rows("host1", "b", "a", "c"))
+	require.NoError(t, err)
+	require.Equal(t, 0, inserted)
+
+	// another host's session of the same name is a session of its own
+	inserted, err = history.InsertAfterOverlap(db, rows("host2", "a", "c"))
+	require.NoError(t, err)
+	require.Equal(t, 2, inserted)
+}
+
+func TestHistorySetEntry(t *testing.T) {
+	db, rowids := testDB(t, history.Row{Hostname: "host", SessionID: "1", Timestamp: time.Unix(1641340800, 0), Entry: "export TOKEN=hunter2"})
+	rowid := rowids[0]
+
+	require.NoError(t, history.SetEntry(db, rowid, "export TOKEN=[REDACTED]"))
+
+	var entry string
+	require.NoError(t, db.QueryRow("SELECT entry FROM history WHERE rowid = ?", rowid).Scan(&entry))
+	require.Equal(t, "export TOKEN=[REDACTED]", entry)
+
+	require.ErrorIs(t, history.SetEntry(db, rowid+1, "ls"), history.ErrNoSuchRow)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+func runImport(args []string) error {
+	format := ""
+	skipUndated := false
+	databasePath := defaultDatabasePath()
+	template := history.Row{}
+
+	// failing to determine this isn't fatal - it'll just be NULL
+	template.Hostname, _ = os.Hostname()
+
+	flags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser import [flags] FILE...")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&format, "format", format, "The format of the history files ("+strings.Join(histfile.Formats, ", ")+"); guessed from each file's name if omitted")
+	flags.StringVar(&template.Hostname, "hostname", template.Hostname, "The host to record the imported commands as having run on")
+	flags.StringVar(&template.SessionID, "session-id", "", "The session to record the imported commands as having run in (defaults to one per file)")
+	flags.BoolVar(&skipUndated, "skip-undated", skipUndated, "Skip commands without a timestamp rather than dating them by the file's modification time")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to import into (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("expected at least one history file")
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, filename := range flags.Args() {
+		fileFormat := format
+		if fileFormat == "" {
+			fileFormat = histfile.GuessFormat(filename)
+			if fileFormat == "" {
+				return fmt.Errorf("unable to guess the format of %s; please specify --format", filename)
+			}
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+
+		entries, err := histfile.Parse(fileFormat, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		s, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+
+		fileTemplate := template
+		if fileTemplate.SessionID == "" {
+			fileTemplate.SessionID = fmt.Sprintf("import:%s:%s", fileFormat, filepath.Base(filename))
+		}
+
+		result, err := histfile.Import(db, entries, fileTemplate, s.ModTime(), skipUndated)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		fmt.Printf("%s: %d commands read, %d imported, %d duplicates skipped", filename, len(entries), result.Inserted, result.Duplicates)
+		if result.Undated > 0 {
+			if skipUndated {
+				fmt.Printf(", %d without timestamps skipped", result.Undated)
+			} else {
+				fmt.Printf(", %d without timestamps", result.Undated)
+			}
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
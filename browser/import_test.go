@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+func parseFixture(t *testing.T, format, name string) []histfile.Entry {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", "import", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	entries, err := histfile.Parse(format, f)
+	require.NoError(t, err)
+
+	return entries
+}
+
+func TestImportParseZsh(t *testing.T) {
+	entries := parseFixture(t, "zsh", "zsh_history")
+
+	require.Equal(t, []histfile.Entry{
+		{Timestamp: time.Unix(1641340800, 0), Duration: ptr(int64(5)), Command: "make test"},
+		{Timestamp: time.Unix(1641340860, 0), Duration: ptr(int64(0)), Command: "for f in *; do\n  echo $f\ndone"},
+		// zsh metafies the second byte of the em dash
+		{Timestamp: time.Unix(1641340900, 0), Duration: ptr(int64(2)), Command: "echo a—b"},
+		{Timestamp: time.Unix(1641340900, 0), Duration: ptr(int64(2)), Command: "echo a—b"},
+		// written without EXTENDED_HISTORY
+		{Command: "ls -l"},
+		{Timestamp: time.Unix(1641341000, 0), Duration: ptr(int64(0)), Command: "git status"},
+	}, entries)
+}
+
+func TestImportParseBash(t *testing.T) {
+	entries := parseFixture(t, "bash", "bash_history")
+
+	require.Equal(t, []histfile.Entry{
+		{Timestamp: time.Unix(1641340800, 0), Command: "make test"},
+		{Timestamp: time.Unix(1641340860, 0), Command: "for f in *; do\n  echo $f\ndone"},
+		{Timestamp: time.Unix(1641340900, 0), Command: "git status"},
+	}, entries)
+
+	entries = parseFixture(t, "bash", "bash_history_untimestamped")
+
+	require.Equal(t, []histfile.Entry{
+		{Command: "ls -l"},
+		{Command: "cd /tmp"},
+		{Command: "pwd"},
+	}, entries)
+}
+
+func TestImportParseFish(t *testing.T) {
+	entries := parseFixture(t, "fish", "fish_history")
+
+	require.Equal(t, []histfile.Entry{
+		{Timestamp: time.Unix(1641340800, 0), Command: "make test"},
+		{Timestamp: time.Unix(1641340860, 0), Command: "for f in *\n  echo $f\nend"},
+		{Timestamp: time.Unix(1641340900, 0), Command: "cp a.txt b.txt"},
+		{Timestamp: time.Unix(1641341000, 0), Command: `echo C:\temp`},
+	}, entries)
+}
+
+func TestImportDeduplicates(t *testing.T) {
+	// already recorded by the shell hooks
+	db, _ := testDB(t, history.Row{Hostname: "host1", SessionID: "737207", Timestamp: time.Unix(1641340800, 0), Entry: "make test"})
+
+	entries := parseFixture(t, "zsh", "zsh_history")
+	template := history.Row{Hostname: "host1", SessionID: "import:zsh:.zsh_history"}
+	modified := time.Unix(1641342000, 0)
+
+	result, err := histfile.Import(db, entries, template, modified, false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Inserted: 4, Duplicates: 2, Undated: 1}, result, "the recorded command and the repeated line should be skipped")
+
+	// importing again shouldn't change anything
+	result, err = histfile.Import(db, entries, template, modified, false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Duplicates: 6, Undated: 1}, result)
+
+	// but the same commands run on another host are commands of their own
+	template.Hostname = "host2"
+	result, err = histfile.Import(db, entries, template, modified, false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Inserted: 5, Duplicates: 1, Undated: 1}, result)
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM history").Scan(&count))
+	require.Equal(t, 10, count)
+
+	// the file's position isn't passed off as the shell's history ID
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM history WHERE history_id IS NOT NULL").Scan(&count))
+	require.Equal(t, 0, count)
+}
+
+func TestImportGrownFile(t *testing.T) {
+	db, _ := testDB(t)
+
+	template := history.Row{Hostname: "host1", SessionID: "import:bash:.bash_history"}
+	modified := time.Unix(1641340800, 0)
+
+	entries, err := histfile.Parse("bash", strings.NewReader("ls -l\ncd /tmp\nls -l\n"))
+	require.NoError(t, err)
+
+	result, err := histfile.Import(db, entries, template, modified, false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Inserted: 3, Undated: 3}, result, "repeated commands are still separate commands")
+
+	// the shell appends to the file, which changes its modification time
+	entries, err = histfile.Parse("bash", strings.NewReader("ls -l\ncd /tmp\nls -l\npwd\nls -l\n"))
+	require.NoError(t, err)
+
+	result, err = histfile.Import(db, entries, template, modified.Add(time.Hour), false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Inserted: 2, Duplicates: 3, Undated: 5}, result)
+
+	result, err = histfile.Import(db, entries, template, modified.Add(2*time.Hour), false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Duplicates: 5, Undated: 5}, result)
+
+	entriesInDB := make([]string, 0)
+	require.NoError(t, history.Each(db, history.Filter{}, func(r history.Row) error {
+		entriesInDB = append(entriesInDB, r.Entry)
+		return nil
+	}))
+	require.Equal(t, []string{"ls -l", "cd /tmp", "ls -l", "pwd", "ls -l"}, entriesInDB)
+
+	result, err = histfile.Import(db, entries, template, modified, true)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Undated: 5}, result)
+
+	// bash drops the oldest lines once the file reaches $HISTFILESIZE
+	entries, err = histfile.Parse("bash", strings.NewReader("ls -l\npwd\nls -l\nmake\n"))
+	require.NoError(t, err)
+
+	result, err = histfile.Import(db, entries, template, modified.Add(3*time.Hour), false)
+	require.NoError(t, err)
+	require.Equal(t, histfile.ImportResult{Inserted: 1, Duplicates: 3, Undated: 4}, result)
+
+	entriesInDB = entriesInDB[:0]
+	require.NoError(t, history.Each(db, history.Filter{}, func(r history.Row) error {
+		entriesInDB = append(entriesInDB, r.Entry)
+		return nil
+	}))
+	require.Equal(t, []string{"ls -l", "cd /tmp", "ls -l", "pwd", "ls -l", "make"}, entriesInDB)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+func runIndex(args []string) error {
+	databasePath := defaultDatabasePath()
+	rebuild := false
+	drop := false
+
+	flags := pflag.NewFlagSet("index", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser index [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Creates or brings up to date a full-text index of commands and directories,")
+		fmt.Fprintln(os.Stderr, "which the go backend uses to search.  Once it exists, the browser adds new")
+		fmt.Fprintln(os.Stderr, "commands to it whenever it starts.  This needs SQLite with FTS5.")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to index (defaults to $HISTDB_PATH)")
+	flags.BoolVar(&rebuild, "rebuild", rebuild, "Index all of history again from scratch")
+	flags.BoolVar(&drop, "drop", drop, "Remove the index")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	if rebuild && drop {
+		return errors.New("--rebuild and --drop can't be used together")
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if drop {
+		if err := fts.Drop(db); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "dropped the full-text index")
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fts.Create(tx); err != nil {
+		return err
+	}
+
+	// an index created by another build of SQLite may not be usable by this one
+	if exists, err := fts.Exists(tx); err != nil {
+		return err
+	} else if !exists {
+		return errors.New("this SQLite can't use the full-text index (is it built with FTS5?)")
+	}
+
+	var added, pruned int
+	if rebuild {
+		added, err = fts.Rebuild(tx)
+	} else {
+		// pick up on rows deleted behind our back, too
+		pruned, err = fts.Prune(tx)
+		if err == nil {
+			added, err = fts.Refresh(tx)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "indexed %d commands, removed %d\n", added, pruned)
+
+	return nil
+}
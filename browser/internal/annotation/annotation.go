@@ -0,0 +1,201 @@
+// Package annotation stores notes and tags for history rows in side tables,
+// keyed by the rows' rowids, so that the history table itself stays the way
+// the shell integration expects it.
+package annotation
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+const Schema = `
+CREATE TABLE IF NOT EXISTS histdb_notes (
+  history_rowid INTEGER PRIMARY KEY,
+  note TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS histdb_tags (
+  history_rowid INTEGER NOT NULL,
+  tag TEXT NOT NULL COLLATE NOCASE,
+  PRIMARY KEY (history_rowid, tag)
+);
+CREATE INDEX IF NOT EXISTS histdb_tags_tag ON histdb_tags (tag);
+`
+
+// EnsureSchema creates the annotation tables if they don't exist yet.  They're
+// only created once something's annotated, so that just browsing doesn't
+// change the database.
+func EnsureSchema(db *sql.DB) error {
+	_, err := db.Exec(Schema)
+	return err
+}
+
+// Exists reports whether db has the annotation tables
+func Exists(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}) (bool, error) {
+	return history.TableExists(db, "histdb_tags")
+}
+
+type Annotation struct {
+	Note string
+	Tags []string
+}
+
+// Parse parses an annotation as the user types it: words starting with # are
+// tags, and everything else is the note
+func Parse(s string) Annotation {
+	var a Annotation
+	words := make([]string, 0)
+
+	for _, word := range strings.Fields(s) {
+		if tag, isTag := strings.CutPrefix(word, "#"); isTag && tag != "" {
+			if !slices.Contains(a.Tags, tag) {
+				a.Tags = append(a.Tags, tag)
+			}
+		} else {
+			words = append(words, word)
+		}
+	}
+
+	a.Note = strings.Join(words, " ")
+
+	return a
+}
+
+// String formats the annotation the way Parse expects it
+func (a Annotation) String() string {
+	parts := make([]string, 0, len(a.Tags)+1)
+	if a.Note != "" {
+		parts = append(parts, a.Note)
+	}
+	for _, tag := range a.Tags {
+		parts = append(parts, "#"+tag)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (a Annotation) IsZero() bool {
+	return a.Note == "" && len(a.Tags) == 0
+}
+
+// Get fetches the annotation for rowid, which is zero if there isn't one
+func Get(db *sql.DB, rowid int64) (Annotation, error) {
+	var a Annotation
+
+	if exists, err := Exists(db); err != nil || !exists {
+		return a, err
+	}
+
+	err := db.QueryRow("SELECT note FROM histdb_notes WHERE history_rowid = ?", rowid).Scan(&a.Note)
+	if err != nil && err != sql.ErrNoRows {
+		return a, err
+	}
+
+	rows, err := db.Query("SELECT tag FROM histdb_tags WHERE history_rowid = ? ORDER BY tag", rowid)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return a, err
+		}
+		a.Tags = append(a.Tags, tag)
+	}
+
+	return a, rows.Err()
+}
+
+// Set replaces the annotation for rowid; setting a zero annotation removes it
+func Set(db *sql.DB, rowid int64, a Annotation) error {
+	if !a.IsZero() {
+		if err := EnsureSchema(db); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := Forget(tx, []int64{rowid}); err != nil {
+		return err
+	}
+
+	if a.Note != "" {
+		if _, err := tx.Exec("INSERT INTO histdb_notes (history_rowid, note) VALUES (?, ?)", rowid, a.Note); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range a.Tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO histdb_tags (history_rowid, tag) VALUES (?, ?)", rowid, tag); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Notes returns every note, keyed by the rowid of the row it's for
+func Notes(db *sql.DB) (map[int64]string, error) {
+	notes := make(map[int64]string)
+
+	if exists, err := Exists(db); err != nil || !exists {
+		return notes, err
+	}
+
+	rows, err := db.Query("SELECT history_rowid, note FROM histdb_notes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rowid int64
+		var note string
+		if err := rows.Scan(&rowid, &note); err != nil {
+			return nil, err
+		}
+		notes[rowid] = note
+	}
+
+	return notes, rows.Err()
+}
+
+// SetNote rewrites the note for rowid, leaving its tags alone
+func SetNote(db execer, rowid int64, note string) error {
+	_, err := db.Exec("UPDATE histdb_notes SET note = ? WHERE history_rowid = ?", note, rowid)
+	return err
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Forget removes the annotations for rowids, like when they're deleted
+func Forget(db execer, rowids []int64) error {
+	if exists, err := Exists(db); err != nil || !exists {
+		return err
+	}
+
+	for _, rowid := range rowids {
+		if _, err := db.Exec("DELETE FROM histdb_notes WHERE history_rowid = ?", rowid); err != nil {
+			return err
+		}
+
+		if _, err := db.Exec("DELETE FROM histdb_tags WHERE history_rowid = ?", rowid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+// Package bundle encrypts exported and synced history.
+//
+// A bundle is a header followed by the plaintext split into chunks, each
+// sealed with AES-256-GCM (the STREAM construction from "Online
+// Authenticated-Encryption and its Nonce-Reuse Misuse-Resistance"): every
+// chunk's nonce is its index plus a flag marking the final chunk, so chunks
+// can't be reordered, dropped, or truncated without detection.  The header is
+// authenticated as additional data on every chunk.
+//
+// The header is laid out as:
+//
+//	magic      [8]byte  "HISTDB\x00\x01"
+//	version    uint8    currently 1
+//	kdf        uint8    kdfPassphrase or kdfKeyFile
+//	iterations uint32   PBKDF2 iterations (zero for key files)
+//	salt       [16]byte
+//	commitment [32]byte HMAC-SHA256 of the preceding bytes
+//
+// The commitment lets readers tell a wrong passphrase or key apart from a
+// bundle whose chunks have been tampered with.  The iterations and salt go into
+// deriving the keys the commitment is checked with, though, so tampering with
+// them (or the commitment itself) looks the same as a wrong key, and is
+// reported as ErrWrongKey.
+package bundle
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const magic = "HISTDB\x00\x01"
+
+const (
+	version = 1
+
+	kdfPassphrase = 1
+	kdfKeyFile    = 2
+
+	saltSize       = 16
+	commitmentSize = sha256.Size
+	headerSize     = len(magic) + 1 + 1 + 4 + saltSize + commitmentSize
+
+	chunkSize = 64 * 1024
+
+	// OWASP's recommendation for PBKDF2-HMAC-SHA256 as of 2023
+	passphraseIterations = 600_000
+)
+
+var (
+	ErrNotEncrypted       = errors.New("not an encrypted bundle")
+	ErrUnsupportedVersion = errors.New("unsupported bundle version")
+	ErrWrongKey           = errors.New("wrong passphrase or key for bundle")
+	ErrTampered           = errors.New("bundle has been tampered with or corrupted")
+)
+
+// Key is either a passphrase or the contents of a key file
+type Key struct {
+	passphrase []byte
+	keyFile    []byte
+}
+
+func PassphraseKey(passphrase string) *Key {
+	return &Key{passphrase: []byte(passphrase)}
+}
+
+const keyFilePrefix = "histdb-key-v1:"
+
+// GenerateKeyFile generates the contents of a new key file
+func GenerateKeyFile() string {
+	return keyFilePrefix + base64.StdEncoding.EncodeToString(randomBytes(32)) + "\n"
+}
+
+// ParseKeyFile parses the contents of a key file written by GenerateKeyFile
+func ParseKeyFile(contents []byte) (*Key, error) {
+	encoded, found := strings.CutPrefix(strings.TrimSpace(string(contents)), keyFilePrefix)
+	if !found {
+		return nil, errors.New("not a histdb key file")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("invalid key file: wrong key length")
+	}
+
+	return &Key{keyFile: key}, nil
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	// crypto/rand.Read never fails on the platforms we support
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// deriveKeys derives the encryption and commitment keys for a bundle
+func (k *Key) deriveKeys(kdf byte, iterations uint32, salt []byte) ([]byte, []byte, error) {
+	var secret []byte
+	var err error
+
+	switch kdf {
+	case kdfPassphrase:
+		if k.passphrase == nil {
+			return nil, nil, fmt.Errorf("%w: bundle was encrypted with a passphrase", ErrWrongKey)
+		}
+		secret, err = pbkdf2.Key(sha256.New, string(k.passphrase), salt, int(iterations), 32)
+	case kdfKeyFile:
+		if k.keyFile == nil {
+			return nil, nil, fmt.Errorf("%w: bundle was encrypted with a key file", ErrWrongKey)
+		}
+		secret, err = hkdf.Extract(sha256.New, k.keyFile, salt)
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown key derivation %d", ErrUnsupportedVersion, kdf)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encryptionKey, err := hkdf.Expand(sha256.New, secret, "histdb bundle encryption", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commitmentKey, err := hkdf.Expand(sha256.New, secret, "histdb bundle commitment", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryptionKey, commitmentKey, nil
+}
+
+func commitment(commitmentKey, header []byte) []byte {
+	mac := hmac.New(sha256.New, commitmentKey)
+	mac.Write(header)
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce builds the nonce for the nth chunk
+func nonce(n uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint64
+	closed bool
+}
+
+// NewWriter returns a writer that encrypts everything written to it into w;
+// it must be closed to write the final chunk
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	kdf := byte(kdfKeyFile)
+	iterations := uint32(0)
+	if key.passphrase != nil {
+		kdf = kdfPassphrase
+		iterations = passphraseIterations
+	}
+
+	salt := randomBytes(saltSize)
+
+	encryptionKey, commitmentKey, err := key.deriveKeys(kdf, iterations, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version, kdf)
+	header = binary.BigEndian.AppendUint32(header, iterations)
+	header = append(header, salt...)
+	header = append(header, commitment(commitmentKey, header)...)
+
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (bw *writer) sealChunk(final bool) error {
+	sealed := bw.aead.Seal(nil, nonce(bw.n, final), bw.buf, bw.header)
+	bw.n++
+	bw.buf = bw.buf[:0]
+
+	_, err := bw.w.Write(sealed)
+	return err
+}
+
+func (bw *writer) Write(p []byte) (int, error) {
+	if bw.closed {
+		return 0, errors.New("write to closed bundle")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// only seal a full chunk once we know there's more coming, so that the
+		// final chunk is never empty unless the whole bundle is
+		if len(bw.buf) == chunkSize {
+			if err := bw.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := min(len(p), chunkSize-len(bw.buf))
+		bw.buf = append(bw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (bw *writer) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+
+	return bw.sealChunk(true)
+}
+
+type reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	plain   []byte
+	n       uint64
+	done    bool
+	pending []byte
+}
+
+// IsEncrypted reports whether r starts with a bundle header, without
+// consuming anything from it
+func IsEncrypted(r *bufio.Reader) bool {
+	start, _ := r.Peek(len(magic))
+	return string(start) == magic
+}
+
+// NewReader returns a reader that decrypts the bundle in r, returning
+// ErrTampered from Read if its chunks have been modified.  A modified header
+// is reported by NewReader itself, as ErrWrongKey unless it's obviously bad.
+func NewReader(r io.Reader, key *Key) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if n, err := io.ReadFull(r, header); err != nil {
+		if n >= len(magic) && string(header[:len(magic)]) == magic {
+			return nil, ErrTampered
+		}
+		return nil, ErrNotEncrypted
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrNotEncrypted
+	}
+
+	rest := header[len(magic):]
+	if rest[0] != version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, rest[0])
+	}
+	kdf := rest[1]
+	iterations := binary.BigEndian.Uint32(rest[2:6])
+	salt := rest[6 : 6+saltSize]
+
+	// guard against a tampered header making us spin on PBKDF2 forever
+	if iterations > 100*passphraseIterations {
+		return nil, ErrTampered
+	}
+
+	encryptionKey, commitmentKey, err := key.deriveKeys(kdf, iterations, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(commitment(commitmentKey, header[:headerSize-commitmentSize]), header[headerSize-commitmentSize:]) {
+		return nil, ErrWrongKey
+	}
+
+	aead, err := newAEAD(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reader{
+		r:      bufio.NewReaderSize(r, chunkSize+aead.Overhead()+1),
+		aead:   aead,
+		header: header,
+	}, nil
+}
+
+func (br *reader) Read(p []byte) (int, error) {
+	for len(br.pending) == 0 {
+		if br.done {
+			return 0, io.EOF
+		}
+
+		if err := br.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, br.pending)
+	br.pending = br.pending[n:]
+	return n, nil
+}
+
+func (br *reader) openChunk() error {
+	sealed := make([]byte, chunkSize+br.aead.Overhead())
+	n, err := io.ReadFull(br.r, sealed)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			// we should have seen the final chunk before running out
+			return ErrTampered
+		}
+		return err
+	}
+	sealed = sealed[:n]
+
+	// a full-size chunk followed by more data isn't the final one
+	_, peekErr := br.r.Peek(1)
+	final := peekErr == io.EOF
+
+	plain, err := br.aead.Open(br.plain[:0], nonce(br.n, final), sealed, br.header)
+	if err != nil {
+		return ErrTampered
+	}
+	br.n++
+	br.plain = plain
+	br.pending = plain
+	br.done = final
+
+	return nil
+}
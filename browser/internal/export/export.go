@@ -0,0 +1,192 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+var Formats = []string{"jsonl", "csv", "zsh"}
+
+// Writer writes history rows in some format; Close must be called to flush
+// any buffered output
+type Writer interface {
+	Write(r history.Row) error
+	Close() error
+}
+
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "jsonl":
+		return &jsonlWriter{w: bufio.NewWriter(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case "zsh":
+		return &zshWriter{w: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q (expected one of %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Record is the JSON representation of a history row, which is also what
+// merge and sync exchange
+type Record struct {
+	Hostname   string `json:"hostname,omitempty"`
+	SessionID  string `json:"session_id,omitempty"`
+	Timestamp  int64  `json:"timestamp"`
+	HistoryID  *int64 `json:"history_id"`
+	Cwd        string `json:"cwd,omitempty"`
+	Entry      string `json:"entry"`
+	Duration   *int64 `json:"duration"`
+	ExitStatus *int64 `json:"exit_status"`
+}
+
+func NewRecord(r history.Row) Record {
+	return Record{
+		Hostname:   r.Hostname,
+		SessionID:  r.SessionID,
+		Timestamp:  r.Timestamp.Unix(),
+		HistoryID:  r.HistoryID,
+		Cwd:        r.Cwd,
+		Entry:      r.Entry,
+		Duration:   r.Duration,
+		ExitStatus: r.ExitStatus,
+	}
+}
+
+func (rec Record) Row() history.Row {
+	return history.Row{
+		Hostname:   rec.Hostname,
+		SessionID:  rec.SessionID,
+		Timestamp:  time.Unix(rec.Timestamp, 0),
+		HistoryID:  rec.HistoryID,
+		Cwd:        rec.Cwd,
+		Entry:      rec.Entry,
+		Duration:   rec.Duration,
+		ExitStatus: rec.ExitStatus,
+	}
+}
+
+// ReadJSONL calls fn with each row in JSON Lines written by the jsonl format,
+// stopping at the first error
+func ReadJSONL(r io.Reader, fn func(history.Row) error) error {
+	d := json.NewDecoder(r)
+
+	for {
+		var rec Record
+
+		err := d.Decode(&rec)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(rec.Row()); err != nil {
+			return err
+		}
+	}
+}
+
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonlWriter) Write(r history.Row) error {
+	line, err := json.Marshal(NewRecord(r))
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.w.Write(line); err != nil {
+		return err
+	}
+
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func formatNullable(n *int64) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+func (c *csvWriter) Write(r history.Row) error {
+	if !c.wroteHeader {
+		if err := c.w.Write(history.Columns); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+
+	return c.w.Write([]string{
+		r.Hostname,
+		r.SessionID,
+		strconv.FormatInt(r.Timestamp.Unix(), 10),
+		formatNullable(r.HistoryID),
+		r.Cwd,
+		r.Entry,
+		formatNullable(r.Duration),
+		formatNullable(r.ExitStatus),
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type zshWriter struct {
+	w *bufio.Writer
+}
+
+// metafy is the inverse of the unmetafying histfile.ParseZsh does; zsh
+// escapes NUL along with Meta (0x83) through Marker (0xa2), which it uses
+// internally as tokens
+func metafy(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == 0 || (c >= 0x83 && c <= 0xa2) {
+			b.WriteByte(0x83)
+			b.WriteByte(c ^ 0x20)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (z *zshWriter) Write(r history.Row) error {
+	duration := int64(0)
+	if r.Duration != nil {
+		duration = *r.Duration
+	}
+
+	entry := strings.ReplaceAll(metafy(r.Entry), "\n", "\\\n")
+
+	_, err := fmt.Fprintf(z.w, ": %d:%d;%s\n", r.Timestamp.Unix(), duration, entry)
+	return err
+}
+
+func (z *zshWriter) Close() error {
+	return z.w.Flush()
+}
@@ -0,0 +1,213 @@
+// Package fts maintains a full-text index over history entries and working
+// directories, so that matching words in them doesn't need to scan the whole
+// history table.
+//
+// The index is an FTS5 table using the trigram tokenizer, which makes MATCH
+// a case-insensitive substring search - the same thing the h virtual table's
+// LIKE '%word%' conditions do, short of % and _ being wildcards.  Its rowids
+// are those of the history table.
+//
+// The history table has no INTEGER PRIMARY KEY, so its rowids aren't stable:
+// SQLite reuses the rowid of the newest row once it's deleted, and VACUUM
+// renumbers rows.  The index can therefore end up holding stale text for a
+// rowid, so it's only ever used to narrow down candidates, which are checked
+// against history itself, and Refresh re-indexes any rows that no longer
+// match history.
+package fts
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const Table = "histdb_fts"
+
+const schema = `CREATE VIRTUAL TABLE IF NOT EXISTS histdb_fts USING fts5(entry, cwd, tokenize = 'trigram')`
+
+// MinTermLength is the shortest term the index can find, since it's made of
+// trigrams
+const MinTermLength = 3
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Exists reports whether db has been indexed.  An index this SQLite can't
+// use, since it doesn't have FTS5, counts as not existing.
+func Exists(db execer) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", Table).Scan(&n)
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM histdb_fts WHERE 0").Scan(&n); err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// IndexedThrough returns the highest rowid in the index
+func IndexedThrough(db execer) (int64, error) {
+	var rowid int64
+	err := db.QueryRow("SELECT COALESCE(MAX(rowid), 0) FROM histdb_fts").Scan(&rowid)
+	return rowid, err
+}
+
+// Create creates the index, which needs SQLite to have been built with FTS5
+// (3.34.0 or newer, for the trigram tokenizer); it starts out empty
+func Create(db execer) error {
+	if _, err := db.Exec(schema); err != nil {
+		return fmt.Errorf("unable to create the full-text index (is SQLite built with FTS5?): %w", err)
+	}
+	enableSecureDelete(db)
+	return nil
+}
+
+// enableSecureDelete has FTS5 remove deleted rows' terms from the index right
+// away, rather than leaving them there until its segments are next merged.
+// SQLite before 3.44.0 doesn't have the option, and there's nothing to do
+// about that but leave the terms, so failing isn't an error.
+func enableSecureDelete(db execer) {
+	db.Exec("INSERT INTO histdb_fts (histdb_fts, rank) VALUES ('secure-delete', 1)")
+}
+
+// outdated selects the rowids of the indexed rows that no longer match
+// history, having been deleted, or their rowid reused or renumbered
+const outdated = `SELECT f.rowid FROM histdb_fts AS f LEFT JOIN history AS h ON h.rowid = f.rowid
+	WHERE h.rowid IS NULL OR h.entry IS NOT f.entry OR h.cwd IS NOT f.cwd`
+
+// Stale reports whether any row in the index no longer matches history.
+// That means comparing every row, rather than just the newest, since rowids
+// can be reused or renumbered anywhere - but it's still quick next to the
+// searches the index saves.
+func Stale(db execer) (bool, error) {
+	stale := false
+	err := db.QueryRow("SELECT EXISTS (" + outdated + ")").Scan(&stale)
+	return stale, err
+}
+
+// Refresh re-indexes the rows that no longer match history (see Stale) and
+// indexes the rows added since the index was last refreshed, returning how
+// many rows it (re-)indexed
+func Refresh(db execer) (int, error) {
+	res, err := db.Exec("DELETE FROM histdb_fts WHERE rowid IN (" + outdated + ")")
+	if err != nil {
+		return 0, err
+	}
+
+	forgotten, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	newRows := "rowid > (SELECT COALESCE(MAX(rowid), 0) FROM histdb_fts)"
+	if forgotten > 0 {
+		// the rows forgotten may be anywhere in history, not just at the end
+		newRows = "rowid NOT IN (SELECT rowid FROM histdb_fts)"
+	}
+
+	res, err = db.Exec("INSERT INTO histdb_fts (rowid, entry, cwd) SELECT rowid, entry, cwd FROM history WHERE " + newRows)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Prune removes rows that are no longer in history from the index, returning
+// how many there were
+func Prune(db execer) (int, error) {
+	res, err := db.Exec("DELETE FROM histdb_fts WHERE rowid NOT IN (SELECT rowid FROM history)")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Rebuild empties the index and indexes all of history again, returning how
+// many rows it indexed
+func Rebuild(db execer) (int, error) {
+	if _, err := db.Exec("DELETE FROM histdb_fts"); err != nil {
+		return 0, err
+	}
+	return Refresh(db)
+}
+
+// Drop removes the index
+func Drop(db execer) error {
+	_, err := db.Exec("DROP TABLE IF EXISTS histdb_fts")
+	return err
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func rowidParams(rowids []int64) []any {
+	params := make([]any, len(rowids))
+	for i, rowid := range rowids {
+		params[i] = rowid
+	}
+	return params
+}
+
+// Forget removes the given rows from the index, if there is one
+func Forget(db execer, rowids []int64) error {
+	if len(rowids) == 0 {
+		return nil
+	}
+
+	if exists, err := Exists(db); err != nil || !exists {
+		return err
+	}
+
+	// indexes created before Create enabled it don't have it yet
+	enableSecureDelete(db)
+
+	_, err := db.Exec("DELETE FROM histdb_fts WHERE rowid IN ("+placeholders(len(rowids))+")", rowidParams(rowids)...)
+	return err
+}
+
+// Reindex updates the index (if there is one) for the given rows, which have
+// changed
+func Reindex(db execer, rowids []int64) error {
+	if err := Forget(db, rowids); err != nil || len(rowids) == 0 {
+		return err
+	}
+
+	if exists, err := Exists(db); err != nil || !exists {
+		return err
+	}
+
+	_, err := db.Exec("INSERT INTO histdb_fts (rowid, entry, cwd) SELECT rowid, entry, cwd FROM history WHERE rowid IN ("+placeholders(len(rowids))+")", rowidParams(rowids)...)
+	return err
+}
+
+// Indexable reports whether the index can find term
+func Indexable(term string) bool {
+	return utf8.RuneCountInString(term) >= MinTermLength
+}
+
+// Query builds an FTS5 query matching rows where every one of terms appears
+// somewhere in one of columns (entry, cwd, or both)
+func Query(columns []string, terms []string) string {
+	filter := columns[0] + " : "
+	if len(columns) > 1 {
+		filter = "{" + strings.Join(columns, " ") + "} : "
+	}
+
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		phrases[i] = filter + `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+
+	return strings.Join(phrases, " AND ")
+}
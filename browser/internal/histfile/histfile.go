@@ -0,0 +1,373 @@
+package histfile
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+// Entry is a command read from a shell's history file; shells don't record
+// nearly as much as histdb does, so Timestamp may be zero and Duration nil
+type Entry struct {
+	Timestamp time.Time
+	Duration  *int64 // in seconds
+	Command   string
+}
+
+var Formats = []string{"zsh", "bash", "fish"}
+
+// GuessFormat guesses the format of the history file at path from its name,
+// returning an empty string if there's no telling
+func GuessFormat(path string) string {
+	base := filepath.Base(path)
+
+	switch {
+	case strings.Contains(base, "fish"):
+		return "fish"
+	case strings.Contains(base, "zsh") || base == ".zhistory":
+		return "zsh"
+	case strings.Contains(base, "bash"):
+		return "bash"
+	}
+
+	return ""
+}
+
+// Rows converts entries into history rows based on template, in the order
+// they appear in.  Entries without a timestamp are left with a zero one.
+func Rows(entries []Entry, template history.Row) []history.Row {
+	rows := make([]history.Row, 0, len(entries))
+
+	for _, e := range entries {
+		r := template
+		r.Entry = e.Command
+		r.Duration = e.Duration
+		r.Timestamp = e.Timestamp
+
+		rows = append(rows, r)
+	}
+
+	return rows
+}
+
+// DateUndated gives the rows without a timestamp ones spaced a second apart
+// leading up to before, so that they keep their order, returning how many
+// there were
+func DateUndated(rows []history.Row, before time.Time) int {
+	undated := 0
+	for _, r := range rows {
+		if r.Timestamp.IsZero() {
+			undated++
+		}
+	}
+
+	undatedIndex := 0
+	for i := range rows {
+		if rows[i].Timestamp.IsZero() {
+			rows[i].Timestamp = before.Add(-time.Duration(undated-undatedIndex) * time.Second)
+			undatedIndex++
+		}
+	}
+
+	return undated
+}
+
+// commands run on the same host at the same time are the same command,
+// whichever session recorded them
+func datedKey(r history.Row) string {
+	return fmt.Sprintf("%s\x00%d\x00%s", r.Hostname, r.Timestamp.Unix(), r.Entry)
+}
+
+type ImportResult struct {
+	Inserted   int
+	Duplicates int
+	// commands without timestamps, which are included in Inserted and
+	// Duplicates unless they were skipped
+	Undated int
+}
+
+// Import inserts the commands in entries that db doesn't already have, with
+// template filling in what history files don't record.  Commands without a
+// timestamp are dated leading up to undatedBefore (see DateUndated), or
+// skipped if skipUndated is set.  Since that date moves as the file grows,
+// they're told apart from the ones imported before by where they stop
+// repeating the last commands imported into template's host and session (see
+// history.InsertAfterOverlap), which copes with shells dropping the file's
+// oldest lines so long as each file is imported into a session of its own.
+func Import(db *sql.DB, entries []Entry, template history.Row, undatedBefore time.Time, skipUndated bool) (ImportResult, error) {
+	result := ImportResult{}
+
+	dated := make([]history.Row, 0, len(entries))
+	undated := make([]history.Row, 0)
+	for _, r := range Rows(entries, template) {
+		if r.Timestamp.IsZero() {
+			undated = append(undated, r)
+		} else {
+			dated = append(dated, r)
+		}
+	}
+	result.Undated = len(undated)
+
+	inserted, err := history.InsertMissing(db, dated, datedKey)
+	if err != nil {
+		return result, err
+	}
+	result.Inserted += inserted
+	result.Duplicates += len(dated) - inserted
+
+	if skipUndated {
+		return result, nil
+	}
+
+	DateUndated(undated, undatedBefore)
+
+	inserted, err = history.InsertAfterOverlap(db, undated)
+	if err != nil {
+		return result, err
+	}
+	result.Inserted += inserted
+	result.Duplicates += len(undated) - inserted
+
+	return result, nil
+}
+
+// Parse parses history in the named format
+func Parse(format string, r io.Reader) ([]Entry, error) {
+	switch format {
+	case "zsh":
+		return ParseZsh(r)
+	case "bash":
+		return ParseBash(r)
+	case "fish":
+		return ParseFish(r)
+	default:
+		return nil, fmt.Errorf("unknown history format %q (expected one of %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	// long one-liners are common enough in shell history that the default 64k
+	// limit isn't a safe bet
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return s
+}
+
+// zsh "metafies" bytes that are special to it in history files by writing
+// zshMeta followed by the byte XOR 0x20
+const zshMeta = 0x83
+
+func unmetafy(line []byte) []byte {
+	if bytes.IndexByte(line, zshMeta) == -1 {
+		return line
+	}
+
+	out := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] == zshMeta && i+1 < len(line) {
+			i++
+			out = append(out, line[i]^0x20)
+		} else {
+			out = append(out, line[i])
+		}
+	}
+	return out
+}
+
+// ParseZsh parses zsh history, with or without EXTENDED_HISTORY's
+// ": <timestamp>:<duration>;" prefix; lines ending in a backslash continue
+// the command onto the next line
+func ParseZsh(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	s := newScanner(r)
+
+	var current *Entry
+	var command strings.Builder
+
+	for s.Scan() {
+		line := string(unmetafy(s.Bytes()))
+
+		if current == nil {
+			current = &Entry{}
+			command.Reset()
+
+			if ts, duration, rest, ok := parseZshExtendedPrefix(line); ok {
+				current.Timestamp = time.Unix(ts, 0)
+				current.Duration = &duration
+				line = rest
+			}
+		}
+
+		if strings.HasSuffix(line, `\`) {
+			command.WriteString(strings.TrimSuffix(line, `\`))
+			command.WriteByte('\n')
+			continue
+		}
+
+		command.WriteString(line)
+		current.Command = command.String()
+		if current.Command != "" {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	// a trailing continuation with nothing after it
+	if current != nil && command.Len() > 0 {
+		current.Command = strings.TrimSuffix(command.String(), "\n")
+		entries = append(entries, *current)
+	}
+
+	return entries, nil
+}
+
+func parseZshExtendedPrefix(line string) (int64, int64, string, bool) {
+	// : 1641340800:5;make test
+	if !strings.HasPrefix(line, ": ") {
+		return 0, 0, "", false
+	}
+
+	prefix, rest, found := strings.Cut(line[2:], ";")
+	if !found {
+		return 0, 0, "", false
+	}
+
+	tsString, durationString, found := strings.Cut(prefix, ":")
+	if !found {
+		return 0, 0, "", false
+	}
+
+	ts, err := strconv.ParseInt(tsString, 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+
+	duration, err := strconv.ParseInt(durationString, 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+
+	return ts, duration, rest, true
+}
+
+// ParseBash parses bash history; if HISTTIMEFORMAT was set when it was
+// written, each command is preceded by a "#<timestamp>" line and may span
+// several lines, otherwise every line is its own command
+func ParseBash(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	s := newScanner(r)
+
+	var lines []string
+	var timestamp time.Time
+	timestamped := false
+
+	flush := func() {
+		if len(lines) > 0 {
+			entries = append(entries, Entry{Timestamp: timestamp, Command: strings.Join(lines, "\n")})
+		}
+		lines = nil
+	}
+
+	for s.Scan() {
+		line := s.Text()
+
+		if ts, ok := parseBashTimestamp(line); ok {
+			flush()
+			timestamp = time.Unix(ts, 0)
+			timestamped = true
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		lines = append(lines, line)
+		if !timestamped {
+			flush()
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	flush()
+
+	return entries, nil
+}
+
+func parseBashTimestamp(line string) (int64, bool) {
+	if len(line) < 2 || line[0] != '#' {
+		return 0, false
+	}
+
+	ts, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return ts, true
+}
+
+var fishUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+
+// ParseFish parses fish's YAML-like history format, in which each command is
+// a "- cmd: ..." line followed by indented "when:" and "paths:" fields.  fish
+// doesn't actually write valid YAML (commands aren't quoted), so this doesn't
+// use a YAML parser
+func ParseFish(r io.Reader) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	s := newScanner(r)
+
+	var current *Entry
+
+	flush := func() {
+		if current != nil && current.Command != "" {
+			entries = append(entries, *current)
+		}
+		current = nil
+	}
+
+	for s.Scan() {
+		line := s.Text()
+
+		if cmd, found := strings.CutPrefix(line, "- cmd: "); found {
+			flush()
+			current = &Entry{Command: fishUnescaper.Replace(cmd)}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if when, found := strings.CutPrefix(line, "  when: "); found {
+			ts, err := strconv.ParseInt(strings.TrimSpace(when), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q for %q", when, current.Command)
+			}
+			current.Timestamp = time.Unix(ts, 0)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	flush()
+
+	return entries, nil
+}
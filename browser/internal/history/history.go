@@ -0,0 +1,500 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Schema creates the history table that the h virtual table reads from
+const Schema = `CREATE TABLE IF NOT EXISTS history (
+  hostname TEXT,
+  session_id TEXT,
+  timestamp INTEGER,
+  history_id INTEGER,
+  cwd TEXT,
+  entry TEXT,
+  duration,
+  exit_status
+)`
+
+// BusyTimeout is how long to wait on another connection's lock before giving
+// up - recording a command should never fail just because the browser happens
+// to be reading at the same time
+const BusyTimeout = 5 * time.Second
+
+// DSN builds a go-sqlite3 data source name for path with the given extra
+// connection parameters, always including a busy timeout
+func DSN(path string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("_busy_timeout", fmt.Sprint(BusyTimeout.Milliseconds()))
+
+	return path + "?" + params.Encode()
+}
+
+// Open opens the database at path for writing, creating the history table if
+// needed; the database is switched to WAL mode so that readers and writers
+// don't block each other
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", DSN(path, url.Values{"_journal_mode": {"WAL"}}))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(Schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Row is a row of the history table; the pointer fields are nil when the
+// corresponding column is NULL
+type Row struct {
+	Rowid      int64
+	Hostname   string
+	SessionID  string
+	Timestamp  time.Time
+	HistoryID  *int64
+	Cwd        string
+	Entry      string
+	Duration   *int64 // in seconds
+	ExitStatus *int64
+}
+
+// Columns are the history table's columns, in the order Row's fields are
+// scanned and inserted
+var Columns = []string{
+	"hostname",
+	"session_id",
+	"timestamp",
+	"history_id",
+	"cwd",
+	"entry",
+	"duration",
+	"exit_status",
+}
+
+// the subset of *sql.DB and *sql.Tx that we need, so callers can batch
+// inserts into a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// TableExists reports whether db has a table (or view) called name
+func TableExists(db interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, name string) (bool, error) {
+	exists := false
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type IN ('table', 'view') AND name = ?)", name).Scan(&exists)
+	return exists, err
+}
+
+// Insert inserts r (ignoring its Rowid), returning the new row's rowid
+func Insert(db execer, r Row) (int64, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(Columns)), ", ")
+
+	res, err := db.Exec(fmt.Sprintf("INSERT INTO history (%s) VALUES (%s)", strings.Join(Columns, ", "), placeholders),
+		nullableString(r.Hostname),
+		nullableString(r.SessionID),
+		r.Timestamp.Unix(),
+		r.HistoryID,
+		nullableString(r.Cwd),
+		r.Entry,
+		r.Duration,
+		r.ExitStatus,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+func nullableString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+var ErrNoSuchRow = errors.New("no such history row")
+
+// Finish records the exit status of the command recorded in the given row
+// along with how long it took, based on when it was recorded as starting
+func Finish(db execer, rowid int64, finishedAt time.Time, exitStatus int) error {
+	res, err := db.Exec("UPDATE history SET duration = MAX(? - timestamp, 0), exit_status = ? WHERE rowid = ?", finishedAt.Unix(), exitStatus, rowid)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoSuchRow, rowid)
+	}
+
+	return nil
+}
+
+// SetEntry rewrites the command recorded in a history row
+func SetEntry(db execer, rowid int64, entry string) error {
+	res, err := db.Exec("UPDATE history SET entry = ? WHERE rowid = ?", entry, rowid)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%w: %d", ErrNoSuchRow, rowid)
+	}
+
+	return nil
+}
+
+// Delete removes the given history rows, returning how many of them existed
+func Delete(db execer, rowids []int64) (int, error) {
+	deleted := 0
+
+	for _, rowid := range rowids {
+		res, err := db.Exec("DELETE FROM history WHERE rowid = ?", rowid)
+		if err != nil {
+			return deleted, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+
+	return deleted, nil
+}
+
+// WithSecureDelete runs fn in a transaction with secure_delete on, so that
+// what it deletes or overwrites is zeroed out rather than left behind in free
+// pages, and checkpoints the WAL once it commits, so that none of it lingers
+// there either.  It's for getting rid of commands that shouldn't have been
+// recorded, like ones with passwords in them.
+func WithSecureDelete(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+
+	// secure_delete is a setting of the connection rather than the
+	// transaction, so it needs a connection to itself
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA secure_delete = ON"); err != nil {
+		return err
+	}
+	// the connection goes back into db's pool afterwards
+	defer conn.ExecContext(ctx, "PRAGMA secure_delete = OFF")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// the WAL still has the pages from before the transaction, until it's
+	// checkpointed into the database and truncated
+	_, err = conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+// Scan scans the history columns (in Columns order, preceded by rowid) from
+// rows into a Row
+func Scan(rows interface{ Scan(...any) error }) (Row, error) {
+	var r Row
+	var hostname, sessionID, cwd, entry sql.NullString
+	var timestamp sql.NullInt64
+
+	err := rows.Scan(&r.Rowid, &hostname, &sessionID, &timestamp, &r.HistoryID, &cwd, &entry, &r.Duration, &r.ExitStatus)
+	if err != nil {
+		return Row{}, err
+	}
+
+	r.Hostname = hostname.String
+	r.SessionID = sessionID.String
+	r.Timestamp = time.Unix(timestamp.Int64, 0)
+	r.Cwd = cwd.String
+	r.Entry = entry.String
+
+	return r, nil
+}
+
+// SelectColumns is the column list to select for Scan
+var SelectColumns = "rowid, " + strings.Join(Columns, ", ")
+
+// InsertMissing inserts those rows whose key isn't shared by an existing row
+// (or an earlier row in rows) in a single transaction, returning how many it
+// inserted.  Only existing rows within the time span of rows are considered,
+// so key should include the timestamp.
+func InsertMissing(db *sql.DB, rows []Row, key func(Row) string) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	minTimestamp, maxTimestamp := rows[0].Timestamp.Unix(), rows[0].Timestamp.Unix()
+	for _, r := range rows {
+		minTimestamp = min(minTimestamp, r.Timestamp.Unix())
+		maxTimestamp = max(maxTimestamp, r.Timestamp.Unix())
+	}
+
+	return insertMissing(db, rows, key, "timestamp BETWEEN ? AND ?", minTimestamp, maxTimestamp)
+}
+
+// InsertAfterOverlap appends rows, which all share a host and session, to
+// that session's existing rows, skipping the longest run of them that starts
+// rows and ends the session's existing rows, returning how many it inserted.
+// This suits rows read from files that are only ever appended to and have
+// their oldest lines dropped, like shell history files, which have no other
+// way of telling the rows imported from them before apart from new ones.
+func InsertAfterOverlap(db *sql.DB, rows []Row) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// only the session's last len(rows) rows can overlap with them
+	existing, err := tx.Query("SELECT entry FROM history WHERE hostname IS ? AND session_id IS ? ORDER BY rowid DESC LIMIT ?",
+		nullableString(rows[0].Hostname), nullableString(rows[0].SessionID), len(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	tail := make([]string, 0, len(rows))
+	for existing.Next() {
+		var entry sql.NullString
+		if err := existing.Scan(&entry); err != nil {
+			existing.Close()
+			return 0, err
+		}
+		tail = append(tail, entry.String)
+	}
+
+	if err := existing.Err(); err != nil {
+		return 0, err
+	}
+	existing.Close()
+
+	slices.Reverse(tail)
+
+	inserted := 0
+
+	for _, r := range rows[overlap(tail, rows):] {
+		if _, err := Insert(tx, r); err != nil {
+			return 0, err
+		}
+		inserted++
+	}
+
+	return inserted, tx.Commit()
+}
+
+// overlap is the length of the longest run of rows' entries that both starts
+// rows and ends entries, found with Knuth-Morris-Pratt so that it takes
+// linear time
+func overlap(entries []string, rows []Row) int {
+	// fallback[i] is the length of the longest proper prefix of rows[:i+1]
+	// that's also a suffix of it
+	fallback := make([]int, len(rows))
+	for i, k := 1, 0; i < len(rows); i++ {
+		for k > 0 && rows[i].Entry != rows[k].Entry {
+			k = fallback[k-1]
+		}
+		if rows[i].Entry == rows[k].Entry {
+			k++
+		}
+		fallback[i] = k
+	}
+
+	matched := 0
+	for _, entry := range entries {
+		for matched > 0 && (matched == len(rows) || entry != rows[matched].Entry) {
+			matched = fallback[matched-1]
+		}
+		if entry == rows[matched].Entry {
+			matched++
+		}
+	}
+
+	return matched
+}
+
+// insertMissing inserts those rows whose key isn't shared by an earlier row
+// or an existing one matching where
+func insertMissing(db *sql.DB, rows []Row, key func(Row) string, where string, params ...any) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool)
+
+	existing, err := tx.Query("SELECT "+SelectColumns+" FROM history WHERE "+where, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	for existing.Next() {
+		r, err := Scan(existing)
+		if err != nil {
+			existing.Close()
+			return 0, err
+		}
+		seen[key(r)] = true
+	}
+
+	if err := existing.Err(); err != nil {
+		return 0, err
+	}
+	existing.Close()
+
+	inserted := 0
+
+	for _, r := range rows {
+		k := key(r)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		if _, err := Insert(tx, r); err != nil {
+			return 0, err
+		}
+		inserted++
+	}
+
+	return inserted, tx.Commit()
+}
+
+// OpenReadOnly opens the database at path without allowing any changes to it
+func OpenReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return sql.Open("sqlite3", DSN(path, url.Values{"_query_only": {"1"}}))
+}
+
+// Filter selects rows of the history table directly, without going through
+// the h virtual table
+type Filter struct {
+	// if set, only rows with timestamps in [Since, Until) are included
+	Since time.Time
+	Until time.Time
+
+	Hostname  string
+	SessionID string
+	// only include rows whose working directory is CwdPrefix or beneath it
+	CwdPrefix string
+
+	// only include rows added after this one
+	AfterRowid int64
+}
+
+// Where builds the WHERE clause (sans WHERE) selecting the rows f matches,
+// along with its parameters; like h, rows without a proper timestamp are
+// never included
+func (f Filter) Where() (string, []any) {
+	predicates := []string{
+		"timestamp IS NOT NULL",
+		"TYPEOF(timestamp) = 'integer'",
+	}
+	params := make([]any, 0)
+
+	if !f.Since.IsZero() {
+		predicates = append(predicates, "timestamp >= ?")
+		params = append(params, f.Since.Unix())
+	}
+
+	if !f.Until.IsZero() {
+		predicates = append(predicates, "timestamp < ?")
+		params = append(params, f.Until.Unix())
+	}
+
+	if f.Hostname != "" {
+		predicates = append(predicates, "hostname = ?")
+		params = append(params, f.Hostname)
+	}
+
+	if f.SessionID != "" {
+		predicates = append(predicates, "CAST(session_id AS TEXT) = ?")
+		params = append(params, f.SessionID)
+	}
+
+	if f.AfterRowid != 0 {
+		predicates = append(predicates, "rowid > ?")
+		params = append(params, f.AfterRowid)
+	}
+
+	if f.CwdPrefix != "" {
+		prefix := strings.TrimSuffix(f.CwdPrefix, "/")
+		predicates = append(predicates, "(cwd = ? OR SUBSTR(cwd, 1, ?) = ?)")
+		params = append(params, prefix, len(prefix)+1, prefix+"/")
+	}
+
+	return strings.Join(predicates, " AND "), params
+}
+
+// Each calls fn with each row matched by f in chronological order, stopping
+// at the first error; rows are streamed rather than loaded all at once
+func Each(db *sql.DB, f Filter, fn func(Row) error) error {
+	whereClause, params := f.Where()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM history WHERE %s ORDER BY timestamp, rowid", SelectColumns, whereClause), params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := Scan(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
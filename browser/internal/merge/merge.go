@@ -0,0 +1,382 @@
+package merge
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+	"hoelz.ro/histdb-browser/internal/export"
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+// Key identifies a command across databases: a given shell session on a given
+// host only runs one command with a particular history number at a particular
+// time.  Rows without a history number (say, from an import) fall back to
+// their entry instead.
+func Key(r history.Row) string {
+	if r.HistoryID != nil {
+		return fmt.Sprintf("%s\x00%s\x00%d\x00%d", r.Hostname, r.SessionID, *r.HistoryID, r.Timestamp.Unix())
+	}
+	return fmt.Sprintf("%s\x00%s\x00\x00%d\x00%s", r.Hostname, r.SessionID, r.Timestamp.Unix(), r.Entry)
+}
+
+// rows are merged in batches so that neither database ever needs to be held
+// in memory all at once
+const batchSize = 10000
+
+type Result struct {
+	Read     int
+	Inserted int
+}
+
+func (r *Result) add(other Result) {
+	r.Read += other.Read
+	r.Inserted += other.Inserted
+}
+
+// batcher collects rows and inserts any missing ones into db once it has
+// enough of them
+type batcher struct {
+	db     *sql.DB
+	batch  []history.Row
+	result Result
+}
+
+func (b *batcher) add(r history.Row) error {
+	b.batch = append(b.batch, r)
+	if len(b.batch) >= batchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *batcher) flush() error {
+	inserted, err := history.InsertMissing(b.db, b.batch, Key)
+	if err != nil {
+		return err
+	}
+
+	b.result.Read += len(b.batch)
+	b.result.Inserted += inserted
+	b.batch = b.batch[:0]
+
+	return nil
+}
+
+// Databases inserts every row from src that dst doesn't already have; since
+// history is append-only, merging in either direction any number of times
+// converges on the union of the two
+func Databases(dst, src *sql.DB) (Result, error) {
+	b := &batcher{db: dst}
+
+	if err := history.Each(src, history.Filter{}, b.add); err != nil {
+		return Result{}, err
+	}
+
+	if err := b.flush(); err != nil {
+		return Result{}, err
+	}
+
+	return b.result, nil
+}
+
+const syncSchema = `
+CREATE TABLE IF NOT EXISTS histdb_sync_state (
+  directory TEXT PRIMARY KEY,
+  last_exported_rowid INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS histdb_sync_applied (
+  directory TEXT NOT NULL,
+  changeset TEXT NOT NULL,
+  applied_at INTEGER NOT NULL,
+  PRIMARY KEY (directory, changeset)
+);
+`
+
+const (
+	changesetSuffix          = ".jsonl"
+	encryptedChangesetSuffix = ".jsonl.enc"
+)
+
+var ErrEncryptedChangeset = errors.New("changeset is encrypted, but no key was given")
+
+type SyncOptions struct {
+	// the shared directory
+	Dir string
+	// the host whose commands to write to Dir
+	Hostname string
+	Now      time.Time
+	// encrypts changesets written to Dir, and decrypts encrypted ones read
+	// from it, if set
+	Key *bundle.Key
+}
+
+type SyncResult struct {
+	// the changeset written for this host's new commands, if there were any
+	Written  string
+	Exported int
+
+	Applied []string
+	Result
+}
+
+// Sync exchanges history with other hosts through dir, which is expected to
+// be shared between them by some other means (Syncthing, Dropbox, NFS, ...).
+//
+// Each host only ever writes new files to its own subdirectory of dir, each
+// containing the commands run on that host since its last sync, and applies
+// the files in other hosts' subdirectories that it hasn't seen yet.  Since
+// applying a changeset is idempotent and order-independent, it doesn't matter
+// when or how often hosts sync, or if a file gets applied twice.
+//
+// If opts.Key is set, the changesets this host writes are encrypted; hosts
+// can switch to encryption at any time, since plaintext changesets are still
+// applied.
+func Sync(db *sql.DB, opts SyncOptions) (SyncResult, error) {
+	result := SyncResult{}
+
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return result, err
+	}
+
+	if _, err := db.Exec(syncSchema); err != nil {
+		return result, err
+	}
+
+	result.Written, result.Exported, err = writeChangeset(db, dir, opts)
+	if err != nil {
+		return result, err
+	}
+
+	changesets, err := pendingChangesets(db, dir, opts.Hostname)
+	if err != nil {
+		return result, err
+	}
+
+	for _, changeset := range changesets {
+		r, err := applyChangeset(db, dir, changeset, opts)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", changeset, err)
+		}
+
+		result.Applied = append(result.Applied, changeset)
+		result.add(r)
+	}
+
+	return result, nil
+}
+
+// unfinishedCutoff is how long a command can go without an exit status before
+// it's assumed that it never finished (the shell was killed, say) and it's
+// exported the way it is
+const unfinishedCutoff = 24 * time.Hour
+
+func writeChangeset(db *sql.DB, dir string, opts SyncOptions) (string, int, error) {
+	hostname := opts.Hostname
+	lastExportedRowid := int64(0)
+
+	err := db.QueryRow("SELECT last_exported_rowid FROM histdb_sync_state WHERE directory = ?", dir).Scan(&lastExportedRowid)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", 0, err
+	}
+
+	hostDir := filepath.Join(dir, hostname)
+	if err := os.MkdirAll(hostDir, 0o700); err != nil {
+		return "", 0, err
+	}
+
+	// write to a temporary file first so that other hosts never see a
+	// partially-written changeset
+	tmp, err := os.CreateTemp(hostDir, ".changeset-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	var out io.Writer = tmp
+	var encrypted io.WriteCloser
+	suffix := changesetSuffix
+
+	if opts.Key != nil {
+		encrypted, err = bundle.NewWriter(tmp, opts.Key)
+		if err != nil {
+			return "", 0, err
+		}
+		out = encrypted
+		suffix = encryptedChangesetSuffix
+	}
+
+	w, err := export.NewWriter("jsonl", out)
+	if err != nil {
+		return "", 0, err
+	}
+
+	exported := 0
+	maxRowid := lastExportedRowid
+
+	// commands that are still running don't have their duration or exit
+	// status yet, and rows are never exported twice, so they're left for a
+	// later sync - the cursor stays below the oldest of them, which means the
+	// commands after it that have finished get exported again then, but
+	// applying a row twice is harmless
+	unfinishedSince := opts.Now.Add(-unfinishedCutoff)
+	oldestUnfinished := int64(0)
+	isUnfinished := func(r history.Row) bool {
+		return r.ExitStatus == nil && r.Timestamp.After(unfinishedSince)
+	}
+
+	// only export our own commands - anything from other hosts came from their
+	// changesets, so they already have them
+	err = history.Each(db, history.Filter{Hostname: hostname, AfterRowid: lastExportedRowid}, func(r history.Row) error {
+		if isUnfinished(r) {
+			// rows come in chronological order, rather than by rowid
+			if oldestUnfinished == 0 || r.Rowid < oldestUnfinished {
+				oldestUnfinished = r.Rowid
+			}
+			return nil
+		}
+
+		exported++
+		maxRowid = max(maxRowid, r.Rowid)
+		return w.Write(r)
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	if exported == 0 {
+		return "", 0, nil
+	}
+
+	if err := w.Close(); err != nil {
+		return "", 0, err
+	}
+
+	if encrypted != nil {
+		if err := encrypted.Close(); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	name := filepath.Join(hostname, fmt.Sprintf("%d%s", opts.Now.UnixNano(), suffix))
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return "", 0, err
+	}
+
+	if oldestUnfinished != 0 {
+		maxRowid = min(maxRowid, oldestUnfinished-1)
+	}
+
+	_, err = db.Exec("INSERT INTO histdb_sync_state (directory, last_exported_rowid) VALUES (?, ?) ON CONFLICT (directory) DO UPDATE SET last_exported_rowid = excluded.last_exported_rowid", dir, maxRowid)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return name, exported, nil
+}
+
+// pendingChangesets finds the changesets from other hosts that haven't been
+// applied yet, as paths relative to dir
+func pendingChangesets(db *sql.DB, dir, hostname string) ([]string, error) {
+	applied := make(map[string]bool)
+
+	rows, err := db.Query("SELECT changeset FROM histdb_sync_applied WHERE directory = ?", dir)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var changeset string
+		if err := rows.Scan(&changeset); err != nil {
+			return nil, err
+		}
+		applied[changeset] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*"+changesetSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedMatches, err := filepath.Glob(filepath.Join(dir, "*", "*"+encryptedChangesetSuffix))
+	if err != nil {
+		return nil, err
+	}
+	matches = append(matches, encryptedMatches...)
+
+	pending := make([]string, 0)
+	for _, match := range matches {
+		changeset, err := filepath.Rel(dir, match)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.Dir(changeset) == hostname || applied[changeset] || strings.HasPrefix(filepath.Base(changeset), ".") {
+			continue
+		}
+
+		pending = append(pending, changeset)
+	}
+
+	sort.Strings(pending)
+
+	return pending, nil
+}
+
+func applyChangeset(db *sql.DB, dir, changeset string, opts SyncOptions) (Result, error) {
+	f, err := os.Open(filepath.Join(dir, changeset))
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+
+	if strings.HasSuffix(changeset, encryptedChangesetSuffix) {
+		if opts.Key == nil {
+			return Result{}, ErrEncryptedChangeset
+		}
+
+		r, err = bundle.NewReader(f, opts.Key)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	b := &batcher{db: db}
+
+	// the bundle reader only hands over chunks that have been authenticated,
+	// so a tampered changeset fails here without being marked as applied
+	if err := export.ReadJSONL(r, b.add); err != nil {
+		return Result{}, err
+	}
+
+	if err := b.flush(); err != nil {
+		return Result{}, err
+	}
+
+	_, err = db.Exec("INSERT OR IGNORE INTO histdb_sync_applied (directory, changeset, applied_at) VALUES (?, ?, ?)", dir, changeset, opts.Now.Unix())
+	if err != nil {
+		return Result{}, err
+	}
+
+	return b.result, nil
+}
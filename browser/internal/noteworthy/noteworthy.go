@@ -0,0 +1,233 @@
+// Package noteworthy records snapshots of browser sessions that the user
+// flagged as noteworthy (usually because the results were confusing), so that
+// they can be reviewed - and turned into bug reports - later.
+package noteworthy
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+const Schema = `
+CREATE TABLE IF NOT EXISTS histdb_noteworthy (
+  id INTEGER PRIMARY KEY,
+  marked_at INTEGER NOT NULL,
+  browser_started_at INTEGER NOT NULL,
+  query TEXT NOT NULL,
+  state TEXT NOT NULL,
+  rows TEXT NOT NULL,
+  selection TEXT
+);
+`
+
+var ErrNoSuchMarker = errors.New("no such noteworthy marker")
+
+// EnsureSchema creates the markers table if it doesn't exist yet.  It's only
+// created once a session is marked, so that just browsing doesn't change the
+// database.
+func EnsureSchema(db *sql.DB) error {
+	_, err := db.Exec(Schema)
+	return err
+}
+
+// Exists reports whether db has the markers table
+func Exists(db *sql.DB) (bool, error) {
+	return history.TableExists(db, "histdb_noteworthy")
+}
+
+// State is the browser state that affects which rows are shown
+type State struct {
+	Columns            []string `json:"columns"`
+	ShowFailedCommands bool     `json:"show_failed_commands"`
+	ShowGlobalCommands bool     `json:"show_global_commands"`
+	HorizonTimestamp   int64    `json:"horizon_timestamp"`
+	SessionID          string   `json:"session_id"`
+	ShowPins           bool     `json:"show_pins"`
+}
+
+// Row is a row that was visible when the marker was recorded; entries are
+// stored as they were displayed, so they're redacted
+type Row struct {
+	Rowid       string `json:"rowid"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	Entry       string `json:"entry"`
+	Highlighted bool   `json:"highlighted,omitempty"`
+}
+
+type Marker struct {
+	ID               int64
+	MarkedAt         time.Time
+	BrowserStartedAt time.Time
+	Query            string
+	State            State
+	Rows             []Row
+	// the row that was eventually selected, if any - only known once the
+	// browser exits
+	Selection *Row
+}
+
+// Record saves m, filling in its ID
+func Record(db *sql.DB, m *Marker) error {
+	if err := EnsureSchema(db); err != nil {
+		return err
+	}
+
+	state, err := json.Marshal(m.State)
+	if err != nil {
+		return err
+	}
+
+	rows, err := json.Marshal(m.Rows)
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec("INSERT INTO histdb_noteworthy (marked_at, browser_started_at, query, state, rows) VALUES (?, ?, ?, ?, ?)",
+		m.MarkedAt.Unix(), m.BrowserStartedAt.Unix(), m.Query, string(state), string(rows))
+	if err != nil {
+		return err
+	}
+
+	m.ID, err = res.LastInsertId()
+	return err
+}
+
+// SetSelection records the row the user selected for the markers ids
+func SetSelection(db *sql.DB, ids []int64, selection Row) error {
+	encoded, err := json.Marshal(selection)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := db.Exec("UPDATE histdb_noteworthy SET selection = ? WHERE id = ?", string(encoded), id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const selectColumns = "id, marked_at, browser_started_at, query, state, rows, selection"
+
+func scan(rows interface{ Scan(...any) error }) (Marker, error) {
+	var m Marker
+	var markedAt, startedAt int64
+	var state, visibleRows string
+	var selection sql.NullString
+
+	if err := rows.Scan(&m.ID, &markedAt, &startedAt, &m.Query, &state, &visibleRows, &selection); err != nil {
+		return m, err
+	}
+
+	m.MarkedAt = time.Unix(markedAt, 0)
+	m.BrowserStartedAt = time.Unix(startedAt, 0)
+
+	if err := json.Unmarshal([]byte(state), &m.State); err != nil {
+		return m, fmt.Errorf("marker %d: %w", m.ID, err)
+	}
+
+	if err := json.Unmarshal([]byte(visibleRows), &m.Rows); err != nil {
+		return m, fmt.Errorf("marker %d: %w", m.ID, err)
+	}
+
+	if selection.Valid {
+		m.Selection = &Row{}
+		if err := json.Unmarshal([]byte(selection.String), m.Selection); err != nil {
+			return m, fmt.Errorf("marker %d: %w", m.ID, err)
+		}
+	}
+
+	return m, nil
+}
+
+// List returns every marker, newest first
+func List(db *sql.DB) ([]Marker, error) {
+	if exists, err := Exists(db); err != nil || !exists {
+		return []Marker{}, err
+	}
+
+	rows, err := db.Query("SELECT " + selectColumns + " FROM histdb_noteworthy ORDER BY marked_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := make([]Marker, 0)
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		markers = append(markers, m)
+	}
+
+	return markers, rows.Err()
+}
+
+func Get(db *sql.DB, id int64) (Marker, error) {
+	if exists, err := Exists(db); err != nil {
+		return Marker{}, err
+	} else if !exists {
+		return Marker{}, fmt.Errorf("%w: %d", ErrNoSuchMarker, id)
+	}
+
+	m, err := scan(db.QueryRow("SELECT "+selectColumns+" FROM histdb_noteworthy WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return m, fmt.Errorf("%w: %d", ErrNoSuchMarker, id)
+	}
+	return m, err
+}
+
+// WriteReport writes m out in a form suitable for pasting into a bug report
+func WriteReport(w io.Writer, m Marker) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Noteworthy marker %d\n\n", m.ID)
+	fmt.Fprintf(&b, "Marked at:       %s\n", m.MarkedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Browser started: %s\n", m.BrowserStartedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Query:           %q\n", m.Query)
+	fmt.Fprintf(&b, "Columns:         %s\n", strings.Join(m.State.Columns, ", "))
+	fmt.Fprintf(&b, "Failed commands: %s\n", shownOrHidden(m.State.ShowFailedCommands))
+	fmt.Fprintf(&b, "Global commands: %s\n", shownOrHidden(m.State.ShowGlobalCommands))
+	if m.State.HorizonTimestamp != 0 {
+		fmt.Fprintf(&b, "Horizon:         %s\n", time.Unix(m.State.HorizonTimestamp, 0).Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "Session ID:      %s\n", m.State.SessionID)
+	if m.State.ShowPins {
+		fmt.Fprintf(&b, "View:            pinned commands\n")
+	}
+
+	fmt.Fprintf(&b, "\nVisible rows (%d):\n", len(m.Rows))
+	for _, row := range m.Rows {
+		marker := " "
+		if row.Highlighted {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %6s  %-19s  %s\n", marker, row.Rowid, row.Timestamp, strings.ReplaceAll(row.Entry, "\n", "\\n"))
+	}
+
+	b.WriteString("\nSelection: ")
+	if m.Selection == nil {
+		b.WriteString("none\n")
+	} else {
+		fmt.Fprintf(&b, "%s  %s\n", m.Selection.Rowid, strings.ReplaceAll(m.Selection.Entry, "\n", "\\n"))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func shownOrHidden(shown bool) string {
+	if shown {
+		return "shown"
+	}
+	return "hidden"
+}
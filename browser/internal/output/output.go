@@ -0,0 +1,158 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+type Format string
+
+const (
+	FormatRaw   Format = "raw"
+	FormatNUL   Format = "nul"
+	FormatShell Format = "shell"
+	FormatJSON  Format = "json"
+	FormatTSV   Format = "tsv"
+)
+
+var formats = []Format{
+	FormatRaw,
+	FormatNUL,
+	FormatShell,
+	FormatJSON,
+	FormatTSV,
+}
+
+// Fields is the order in which a full history row is written for the
+// formats that include every field
+var Fields = []string{
+	"rowid",
+	"hostname",
+	"session_id",
+	"timestamp",
+	"cwd",
+	"duration",
+	"exit_status",
+	"entry",
+}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = string(f)
+	}
+
+	return "", fmt.Errorf("unknown output format %q (expected one of %s)", s, strings.Join(names, ", "))
+}
+
+// IncludesAllFields reports whether the format writes a whole row rather than
+// a single value (the entry or the OID)
+func (f Format) IncludesAllFields() bool {
+	return f == FormatJSON || f == FormatTSV
+}
+
+// WriteValue writes a single value (typically the selected entry) in the given
+// format; the value is written as-is for raw, followed by a NUL byte for nul,
+// and quoted so that it can be safely eval'd for shell
+func WriteValue(w io.Writer, format Format, value string) error {
+	var err error
+
+	switch format {
+	case FormatRaw:
+		_, err = fmt.Fprintln(w, value)
+	case FormatNUL:
+		_, err = fmt.Fprint(w, value, "\x00")
+	case FormatShell:
+		_, err = fmt.Fprintln(w, ShellQuote(value))
+	default:
+		err = fmt.Errorf("output format %q doesn't support single values", format)
+	}
+
+	return err
+}
+
+// WriteRow writes the named fields of row on a single line
+func WriteRow(w io.Writer, format Format, fields []string, row map[string]string) error {
+	var err error
+
+	switch format {
+	case FormatJSON:
+		var b strings.Builder
+
+		b.WriteByte('{')
+		for i, field := range fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			// marshaling strings can't fail
+			key, _ := json.Marshal(field)
+			value, _ := json.Marshal(row[field])
+			b.Write(key)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+
+		_, err = fmt.Fprintln(w, b.String())
+	case FormatTSV:
+		values := make([]string, len(fields))
+		for i, field := range fields {
+			values[i] = tsvEscape(row[field])
+		}
+
+		_, err = fmt.Fprintln(w, strings.Join(values, "\t"))
+	default:
+		for _, field := range fields {
+			if field == "entry" {
+				return WriteValue(w, format, row[field])
+			}
+		}
+		err = fmt.Errorf("output format %q requires an entry field", format)
+	}
+
+	return err
+}
+
+// WriteTable writes rows as a human-readable table, with a header naming fields
+func WriteTable(w io.Writer, fields []string, rows []map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, strings.Join(fields, "\t"))
+
+	values := make([]string, len(fields))
+	for _, row := range rows {
+		for i, field := range fields {
+			values[i] = tsvEscape(row[field])
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+var tsvReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"\t", `\t`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// tsvEscape escapes the characters that would otherwise break up a TSV record,
+// following the same conventions as PostgreSQL's and MySQL's text formats
+func tsvEscape(s string) string {
+	return tsvReplacer.Replace(s)
+}
+
+// ShellQuote quotes s so that a POSIX shell will treat it as a single word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,145 @@
+// Package pins keeps a list of favorite commands.  Pins are kept by entry
+// rather than by history row, since it's the command that's interesting, not
+// when it happened to run.
+package pins
+
+import (
+	"database/sql"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+const Schema = `
+CREATE TABLE IF NOT EXISTS histdb_pins (
+  entry TEXT PRIMARY KEY,
+  source_rowid INTEGER,
+  pinned_at INTEGER NOT NULL
+);
+`
+
+// EnsureSchema creates the pins table if it doesn't exist yet.  It's only
+// created once something's pinned, so that just browsing doesn't change the
+// database.
+func EnsureSchema(db *sql.DB) error {
+	_, err := db.Exec(Schema)
+	return err
+}
+
+// Exists reports whether db has the pins table
+func Exists(db *sql.DB) (bool, error) {
+	return history.TableExists(db, "histdb_pins")
+}
+
+// Pin pins entry, remembering the history row it was pinned from; pinning an
+// entry that's already pinned just updates those
+func Pin(db *sql.DB, entry string, sourceRowid int64, now time.Time) error {
+	if err := EnsureSchema(db); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("INSERT INTO histdb_pins (entry, source_rowid, pinned_at) VALUES (?, ?, ?) ON CONFLICT (entry) DO UPDATE SET source_rowid = excluded.source_rowid, pinned_at = excluded.pinned_at", entry, sourceRowid, now.Unix())
+	return err
+}
+
+func Unpin(db *sql.DB, entry string) error {
+	if exists, err := Exists(db); err != nil || !exists {
+		return err
+	}
+
+	_, err := db.Exec("DELETE FROM histdb_pins WHERE entry = ?", entry)
+	return err
+}
+
+func IsPinned(db *sql.DB, entry string) (bool, error) {
+	if exists, err := Exists(db); err != nil || !exists {
+		return false, err
+	}
+
+	pinned := false
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM histdb_pins WHERE entry = ?)", entry).Scan(&pinned)
+	return pinned, err
+}
+
+// Entries returns every pinned entry
+func Entries(db *sql.DB) ([]string, error) {
+	entries := make([]string, 0)
+
+	if exists, err := Exists(db); err != nil || !exists {
+		return entries, err
+	}
+
+	rows, err := db.Query("SELECT entry FROM histdb_pins ORDER BY entry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry string
+		if err := rows.Scan(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// Rename rewrites a pin's entry; if to is already pinned, the pin of from is
+// dropped in favor of it
+func Rename(db execer, from, to string) error {
+	if _, err := db.Exec("UPDATE OR IGNORE histdb_pins SET entry = ? WHERE entry = ?", to, from); err != nil {
+		return err
+	}
+
+	_, err := db.Exec("DELETE FROM histdb_pins WHERE entry = ?", from)
+	return err
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Forget deals with the pins of history rows that have just been deleted: a
+// pin whose entry history no longer has anywhere is removed along with it,
+// and the rest are moved to the newest row that has their entry
+func Forget(db execer, rowids []int64) error {
+	if exists, err := history.TableExists(db, "histdb_pins"); err != nil || !exists {
+		return err
+	}
+
+	for _, rowid := range rowids {
+		_, err := db.Exec("DELETE FROM histdb_pins WHERE source_rowid = ? AND NOT EXISTS (SELECT 1 FROM history WHERE entry = histdb_pins.entry)", rowid)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec("UPDATE histdb_pins SET source_rowid = (SELECT MAX(rowid) FROM history WHERE entry = histdb_pins.entry) WHERE source_rowid = ?", rowid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Columns are the columns of SQL's results
+var Columns = []string{"rowid", "pinned_at", "entry", "exit_status"}
+
+// SQL builds the query for the pins matching text, newest first, in the same
+// shape as the browser's search results; rowid is empty for pins whose
+// history row has since been deleted.  text matches the way it does in the
+// browser's search, with every word having to appear somewhere in the entry.
+func SQL(text string) (string, []any) {
+	// the pins table isn't the h virtual table, so there's no MATCH to be
+	// had, but the go backend's emulation of it works on any entry column
+	match, params := query.HistoryTableBackend{}.Match("entry", text)
+
+	q := `SELECT COALESCE((SELECT rowid FROM history WHERE rowid = p.source_rowid), '') AS rowid, ` +
+		`datetime(pinned_at, 'unixepoch', 'localtime') AS pinned_at, entry, '' AS exit_status ` +
+		`FROM histdb_pins AS p WHERE ` + match + ` ORDER BY pinned_at DESC, entry`
+	return q, params
+}
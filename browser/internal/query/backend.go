@@ -0,0 +1,279 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/fts"
+)
+
+// Backend provides the h table that queries are written against, along with
+// what MATCH means for its columns
+type Backend interface {
+	// From returns what to select from in place of h - it's always named h,
+	// so that columns can be qualified with it - along with its parameters
+	From() (string, []any)
+
+	// Match returns a predicate for column MATCH expr, along with its
+	// parameters
+	Match(column, expr string) (string, []any)
+}
+
+// VTableBackend reads from the h virtual table provided by the Lua vtable
+// extension, which needs to have been loaded on the connection
+type VTableBackend struct{}
+
+func (VTableBackend) From() (string, []any) {
+	return "h", nil
+}
+
+func (VTableBackend) Match(column, expr string) (string, []any) {
+	return column + " MATCH ?", []any{expr}
+}
+
+// HistoryTableBackend emulates the h virtual table with plain SQL against
+// the history table, so that it works with any SQLite
+type HistoryTableBackend struct {
+	// commands from this session are left out, like the virtual table does
+	// with $HISTDB_SESSION_ID
+	ExcludeSessionID string
+
+	// what relative timestamp MATCH expressions are relative to; defaults to
+	// time.Now
+	Now func() time.Time
+
+	// if the database has a full-text index (see the fts package), the
+	// highest rowid it covers; matches on entry and cwd go through the index
+	// for rows up to it.  Zero means there's no index.
+	FullTextIndexedThrough int64
+
+	// count the rows queries scan (see SearchCounted), which needs the
+	// connection to have had RegisterScanCounter run on it
+	CountScans bool
+}
+
+// historyTableColumns mirror the virtual table's columns
+const historyTableColumns = "rowid, " +
+	"hostname, " +
+	"CAST(session_id AS TEXT) AS session_id, " +
+	"DATETIME(timestamp, 'unixepoch', 'localtime') AS timestamp, " +
+	"timestamp AS raw_timestamp, " +
+	"CAST(history_id AS INTEGER) AS history_id, " +
+	"cwd, " +
+	"entry, " +
+	"CAST(duration AS INTEGER) AS duration, " +
+	"CAST(exit_status AS INTEGER) AS exit_status"
+
+func (b HistoryTableBackend) From() (string, []any) {
+	where := "timestamp IS NOT NULL AND TYPEOF(timestamp) = 'integer'"
+	params := make([]any, 0)
+
+	if b.CountScans {
+		where = countScanFunction + "() AND " + where
+	}
+
+	if b.ExcludeSessionID != "" {
+		where += " AND session_id <> ?"
+		params = append(params, b.ExcludeSessionID)
+	}
+
+	return fmt.Sprintf("(SELECT %s FROM history WHERE %s) AS h", historyTableColumns, where), params
+}
+
+func (b HistoryTableBackend) Match(column, expr string) (string, []any) {
+	switch column {
+	case "entry":
+		// every word has to appear somewhere in the entry
+		return b.matchWords(expr, []string{"entry"}, "entry LIKE ?", 1)
+	case "cwd":
+		if b.FullTextIndexedThrough != 0 && fts.Indexable(expr) {
+			return b.matchIndexed([]string{"cwd"}, []string{expr}, "cwd LIKE ?", []any{"%" + expr + "%"})
+		}
+		return "cwd LIKE ?", []any{"%" + expr + "%"}
+	case "timestamp":
+		now := time.Now
+		if b.Now != nil {
+			now = b.Now
+		}
+
+		start, end, err := ResolveTimestampMatch(expr, now())
+		if err != nil {
+			// the virtual table raises an error here, but there's no way to
+			// surface one from a predicate - the best we can do is match nothing
+			return "0", nil
+		}
+		return "raw_timestamp BETWEEN ? AND ?", []any{start.Unix(), end.Unix()}
+	default:
+		return column + " LIKE ?", []any{"%" + expr + "%"}
+	}
+}
+
+// matchWords is likeEach, but looking words up in the full-text index if
+// there is one.  Words too short for the index fall back to LIKE.
+func (b HistoryTableBackend) matchWords(expr string, columns []string, predicate string, arity int) (string, []any) {
+	if b.FullTextIndexedThrough == 0 {
+		return likeEach(expr, predicate, arity)
+	}
+
+	indexed := make([]string, 0)
+	unindexed := make([]string, 0)
+	for _, word := range strings.Fields(expr) {
+		if fts.Indexable(word) {
+			indexed = append(indexed, word)
+		} else {
+			unindexed = append(unindexed, word)
+		}
+	}
+
+	if len(indexed) == 0 {
+		return likeEach(expr, predicate, arity)
+	}
+
+	like, likeParams := likeEach(strings.Join(indexed, " "), predicate, arity)
+	clause, params := b.matchIndexed(columns, indexed, like, likeParams)
+
+	if len(unindexed) > 0 {
+		rest, restParams := likeEach(strings.Join(unindexed, " "), predicate, arity)
+		clause = "(" + clause + " AND " + rest + ")"
+		params = append(params, restParams...)
+	}
+
+	return clause, params
+}
+
+// matchIndexed builds a predicate for every one of terms appearing in one of
+// columns, using the full-text index to narrow down the rows to check with
+// like.  Rows added since the index was last refreshed aren't in it, so those
+// are all checked.  like is checked for indexed rows too, since the index
+// might hold stale text for a reused rowid.
+func (b HistoryTableBackend) matchIndexed(columns, terms []string, like string, likeParams []any) (string, []any) {
+	clause := "((rowid IN (SELECT rowid FROM " + fts.Table + " WHERE " + fts.Table + " MATCH ?) OR rowid > ?) AND " + like + ")"
+	params := append([]any{fts.Query(columns, terms), b.FullTextIndexedThrough}, likeParams...)
+	return clause, params
+}
+
+// likeEach builds a conjunction of predicate for each word in expr, where
+// predicate has arity placeholders for the word
+func likeEach(expr, predicate string, arity int) (string, []any) {
+	words := strings.Fields(expr)
+	if len(words) == 0 {
+		return "1", nil
+	}
+
+	predicates := make([]string, 0, len(words))
+	params := make([]any, 0, len(words)*arity)
+
+	for _, word := range words {
+		predicates = append(predicates, predicate)
+		for range arity {
+			params = append(params, "%"+word+"%")
+		}
+	}
+
+	return "(" + strings.Join(predicates, " AND ") + ")", params
+}
+
+var (
+	timestampBetweenPattern = regexp.MustCompile(`^between[ \t]+(.+?)[ \t]+and[ \t]+(.+)$`)
+	timestampUnaryPattern   = regexp.MustCompile(`^(?:(since|on)[ \t]+)?(.+)$`)
+	relativeAgoPattern      = regexp.MustCompile(`^(\d+)[ \t]+(days?|weeks?)[ \t]+ago$`)
+	relativeShortAgoPattern = regexp.MustCompile(`^(\d+)([dw])[ \t]+ago$`)
+	relativeNegativePattern = regexp.MustCompile(`^-(\d+)[ \t]+(days?|weeks?)$`)
+	absoluteDatePattern     = regexp.MustCompile(`^(?:(\d{4,})-)?(\d{2})-(\d{2})$`)
+)
+
+// timestampOperand is a date in a timestamp MATCH expression, which has a
+// resolution of either a day or a week
+type timestampOperand struct {
+	start time.Time
+	week  bool
+}
+
+func (o timestampOperand) end() time.Time {
+	if o.week {
+		return o.start.AddDate(0, 0, 7)
+	}
+	return o.start.AddDate(0, 0, 1)
+}
+
+// ResolveTimestampMatch resolves the right hand side of a timestamp MATCH -
+// "yesterday", "on 2022-03-01", "since 3 days ago", "between 03-01 and 03-10",
+// and so on - to the range of times it covers, like the virtual table does
+func ResolveTimestampMatch(expr string, now time.Time) (time.Time, time.Time, error) {
+	if m := timestampBetweenPattern.FindStringSubmatch(expr); m != nil {
+		lhs, err := parseTimestampOperand(m[1], now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		rhs, err := parseTimestampOperand(m[2], now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		return lhs.start, rhs.end(), nil
+	}
+
+	m := timestampUnaryPattern.FindStringSubmatch(expr)
+	if m == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("empty timestamp match")
+	}
+	operand, err := parseTimestampOperand(m[2], now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if m[1] == "since" {
+		return operand.start, now, nil
+	}
+	return operand.start, operand.end(), nil
+}
+
+func parseTimestampOperand(s string, now time.Time) (timestampOperand, error) {
+	// unit is any of d, day, days, w, week, or weeks
+	relative := func(magnitude string, unit string) timestampOperand {
+		n, _ := strconv.Atoi(magnitude)
+		week := strings.HasPrefix(unit, "w")
+
+		t := now.AddDate(0, 0, -n)
+		if week {
+			t = now.AddDate(0, 0, -7*n)
+		}
+
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())
+		if week {
+			// weeks start on Sunday
+			start = start.AddDate(0, 0, -int(start.Weekday()))
+		}
+		return timestampOperand{start: start, week: week}
+	}
+
+	switch {
+	case s == "today":
+		return relative("0", "day"), nil
+	case s == "yesterday":
+		return relative("1", "day"), nil
+	case s == "now" || strings.HasSuffix(s, " now"):
+		return timestampOperand{}, fmt.Errorf("times of day aren't supported in timestamp matches (%q)", s)
+	}
+
+	for _, pattern := range []*regexp.Regexp{relativeAgoPattern, relativeShortAgoPattern, relativeNegativePattern} {
+		if m := pattern.FindStringSubmatch(s); m != nil {
+			return relative(m[1], m[2]), nil
+		}
+	}
+
+	if m := absoluteDatePattern.FindStringSubmatch(s); m != nil {
+		year := now.Year()
+		if m[1] != "" {
+			year, _ = strconv.Atoi(m[1])
+		}
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+
+		return timestampOperand{start: time.Date(year, time.Month(month), day, 0, 0, 0, 0, now.Location())}, nil
+	}
+
+	return timestampOperand{}, fmt.Errorf("unable to parse timestamp match %q", s)
+}
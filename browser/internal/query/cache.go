@@ -0,0 +1,56 @@
+package query
+
+import "fmt"
+
+// Cache remembers the results of recent queries, since typing and then
+// deleting characters in the browser re-runs the same queries over and over;
+// how often that pays off is what the browser's telemetry counts as cache
+// hits.  Anything that changes the database must Clear it.
+type Cache struct {
+	capacity int
+	entries  map[string]cacheEntry
+	// keys in insertion order, for evicting the oldest
+	order []string
+}
+
+type cacheEntry struct {
+	columns []string
+	results []map[string]string
+}
+
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// Run returns the cached results for sql and args if there are any, and
+// otherwise gets them from run, reporting whether they were cached.  Callers
+// must not modify the results.
+func (c *Cache) Run(sql string, args []any, run func() ([]string, []map[string]string, error)) ([]string, []map[string]string, bool, error) {
+	key := fmt.Sprintf("%s\x00%#v", sql, args)
+
+	if entry, found := c.entries[key]; found {
+		return entry.columns, entry.results, true, nil
+	}
+
+	columns, results, err := run()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if len(c.order) >= c.capacity {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = cacheEntry{columns: columns, results: results}
+	c.order = append(c.order, key)
+
+	return columns, results, false, nil
+}
+
+func (c *Cache) Clear() {
+	clear(c.entries)
+	c.order = nil
+}
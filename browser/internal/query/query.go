@@ -0,0 +1,391 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+// Filter describes a search against the h table - both the browser
+// and the search subcommand build their queries from one of these so that
+// they always agree on what matches
+type Filter struct {
+	// the text the user typed, matched against entries - except for tag:NAME
+	// terms, which match commands tagged with NAME, and cwd:DIR and
+	// timestamp:WHEN terms (see ParseTerms)
+	Query string
+
+	// the columns to select in addition to rowid, entry, and exit_status
+	Columns []string
+
+	ShowFailedCommands bool
+	ShowGlobalCommands bool
+
+	// commands from sessions other than SessionID that ran after
+	// HorizonTimestamp are only included if ShowGlobalCommands is set
+	HorizonTimestamp time.Time
+	SessionID        string
+
+	// if set, only commands run in [Since, Until) on Hostname are included
+	Since    time.Time
+	Until    time.Time
+	Hostname string
+
+	// rows to leave out regardless, like those pending deletion
+	ExcludeRowids []int64
+
+	// if set, commands that have been pinned sort before everything else
+	FloatPins bool
+
+	// the annotation and pins tables are only created once something's
+	// annotated or pinned; without them, the tags and note columns are empty,
+	// tag:NAME terms match nothing, and FloatPins has nothing to float.  See
+	// ForDatabase.
+	NoAnnotations bool
+	NoPins        bool
+	// whether ForDatabase has already adjusted the filter, so that a caller
+	// can see the SQL a store will run without the store checking again
+	forDatabase bool
+
+	Limit int
+
+	// where h comes from; defaults to VTableBackend
+	Backend Backend
+}
+
+const DefaultLimit = 100
+
+// Columns are the columns that may be requested in Filter.Columns
+var Columns = []string{
+	"timestamp",
+	"session_id",
+	"cwd",
+	"hostname",
+	"duration",
+	"tags",
+	"note",
+}
+
+// columnExpressions are the expressions for the columns that don't come
+// straight from h
+var columnExpressions = map[string]string{
+	"tags": "COALESCE((SELECT group_concat('#' || tag, ' ') FROM (SELECT tag FROM histdb_tags WHERE history_rowid = h.rowid ORDER BY tag)), '') AS tags",
+	"note": "COALESCE((SELECT note FROM histdb_notes WHERE history_rowid = h.rowid), '') AS note",
+}
+
+// ForDatabase returns f adjusted for which of the annotation and pins tables
+// db has, unless it's been adjusted already
+func (f Filter) ForDatabase(db *sql.DB) (Filter, error) {
+	if f.forDatabase {
+		return f, nil
+	}
+
+	for table, missing := range map[string]*bool{"histdb_tags": &f.NoAnnotations, "histdb_pins": &f.NoPins} {
+		exists, err := history.TableExists(db, table)
+		if err != nil {
+			return f, err
+		}
+		*missing = *missing || !exists
+	}
+	f.forDatabase = true
+	return f, nil
+}
+
+// Terms is a query broken down into what its parts match
+type Terms struct {
+	// matched against entries
+	Text string
+	// from tag:NAME terms, without any leading #
+	Tags []string
+	// from cwd:DIR terms, matched against the directory commands ran in
+	Cwds []string
+	// from timestamp:WHEN terms, matched like the virtual table's timestamp
+	// MATCH (see ResolveTimestampMatch).  WHEN may be quoted to include
+	// spaces, as in timestamp:"since 3 days ago".
+	Timestamps []string
+}
+
+var termPattern = regexp.MustCompile(`(tag|cwd|timestamp):("[^"]*"?|\S+)|\S+`)
+
+// ParseTerms separates the tag:, cwd:, and timestamp: terms in a query from
+// the rest of it
+func ParseTerms(q string) Terms {
+	var terms Terms
+	words := make([]string, 0)
+
+	for _, m := range termPattern.FindAllStringSubmatch(q, -1) {
+		value := strings.TrimSuffix(strings.TrimPrefix(m[2], `"`), `"`)
+
+		switch m[1] {
+		case "tag":
+			terms.Tags = append(terms.Tags, strings.TrimPrefix(value, "#"))
+		case "cwd":
+			terms.Cwds = append(terms.Cwds, value)
+		case "timestamp":
+			terms.Timestamps = append(terms.Timestamps, value)
+		default:
+			words = append(words, m[0])
+		}
+	}
+
+	if len(words) == len(strings.Fields(q)) {
+		// leave the query untouched so that the user's spacing is preserved
+		terms.Text = q
+	} else {
+		terms.Text = strings.Join(words, " ")
+	}
+
+	return terms
+}
+
+// Validate checks that f only refers to columns that exist, since they're
+// interpolated into the query
+func (f Filter) Validate() error {
+	for _, column := range f.Columns {
+		if !slices.Contains(Columns, column) {
+			return fmt.Errorf("unknown column %q (expected one of %s)", column, strings.Join(Columns, ", "))
+		}
+	}
+	return nil
+}
+
+func (f Filter) backend() Backend {
+	if f.Backend == nil {
+		return VTableBackend{}
+	}
+	return f.Backend
+}
+
+// From returns what f's queries select from, along with its parameters, which
+// come before those of the WHERE clause
+func (f Filter) From() (string, []any) {
+	return f.backend().From()
+}
+
+// Where builds the WHERE clause (sans WHERE) selecting the rows f matches,
+// along with its parameters
+func (f Filter) Where() (string, []any) {
+	whereClausePredicates := []string{
+		"timestamp IS NOT NULL",
+	}
+	queryParams := make([]any, 0)
+
+	terms := ParseTerms(f.Query)
+
+	if terms.Text != "" {
+		predicate, params := f.backend().Match("entry", terms.Text)
+		whereClausePredicates = append(whereClausePredicates, predicate)
+		queryParams = append(queryParams, params...)
+	}
+
+	for _, cwd := range terms.Cwds {
+		predicate, params := f.backend().Match("cwd", cwd)
+		whereClausePredicates = append(whereClausePredicates, predicate)
+		queryParams = append(queryParams, params...)
+	}
+
+	for _, expr := range terms.Timestamps {
+		if _, _, err := ResolveTimestampMatch(expr, time.Now()); err != nil {
+			// the virtual table would raise an error, failing the whole query
+			// while the user is still typing - match nothing instead
+			whereClausePredicates = append(whereClausePredicates, "0")
+			continue
+		}
+		predicate, params := f.backend().Match("timestamp", expr)
+		whereClausePredicates = append(whereClausePredicates, predicate)
+		queryParams = append(queryParams, params...)
+	}
+
+	for _, tag := range terms.Tags {
+		if f.NoAnnotations {
+			// nothing's been tagged yet
+			whereClausePredicates = append(whereClausePredicates, "0")
+			continue
+		}
+		whereClausePredicates = append(whereClausePredicates, "rowid IN (SELECT history_rowid FROM histdb_tags WHERE tag = ?)")
+		queryParams = append(queryParams, tag)
+	}
+
+	if !f.ShowFailedCommands {
+		whereClausePredicates = append(whereClausePredicates, "exit_status IN (0, 148)")
+	}
+
+	if !f.ShowGlobalCommands && f.HorizonTimestamp.Unix() != 0 {
+		whereClausePredicates = append(whereClausePredicates, "(raw_timestamp <= ? OR session_id = ?)")
+		queryParams = append(queryParams, f.HorizonTimestamp.Unix())
+		queryParams = append(queryParams, f.SessionID)
+	}
+
+	if !f.Since.IsZero() {
+		whereClausePredicates = append(whereClausePredicates, "raw_timestamp >= ?")
+		queryParams = append(queryParams, f.Since.Unix())
+	}
+
+	if !f.Until.IsZero() {
+		whereClausePredicates = append(whereClausePredicates, "raw_timestamp < ?")
+		queryParams = append(queryParams, f.Until.Unix())
+	}
+
+	if f.Hostname != "" {
+		whereClausePredicates = append(whereClausePredicates, "hostname = ?")
+		queryParams = append(queryParams, f.Hostname)
+	}
+
+	if len(f.ExcludeRowids) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(f.ExcludeRowids)), ", ")
+		whereClausePredicates = append(whereClausePredicates, "rowid NOT IN ("+placeholders+")")
+		for _, rowid := range f.ExcludeRowids {
+			queryParams = append(queryParams, rowid)
+		}
+	}
+
+	return strings.Join(whereClausePredicates, " AND "), queryParams
+}
+
+// SQL builds the query for f along with its parameters
+func (f Filter) SQL() (string, []any) {
+	selectClauseColumns := make([]string, 0, len(f.Columns)+1)
+	for _, column := range f.Columns {
+		if expression, found := columnExpressions[column]; found {
+			if f.NoAnnotations {
+				expression = "'' AS " + column
+			}
+			column = expression
+		}
+		selectClauseColumns = append(selectClauseColumns, column)
+	}
+	selectClauseColumns = append(selectClauseColumns, "entry")
+
+	selectClause := strings.Join(selectClauseColumns, ", ")
+
+	fromClause, queryParams := f.From()
+	whereClause, whereParams := f.Where()
+	queryParams = append(queryParams, whereParams...)
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	orderBy := "timestamp DESC"
+	if f.FloatPins && !f.NoPins {
+		orderBy = "entry IN (SELECT entry FROM histdb_pins) DESC, " + orderBy
+	}
+
+	return fmt.Sprintf("SELECT rowid, %s, COALESCE(exit_status, '') AS exit_status FROM %s WHERE %s ORDER BY %s LIMIT %d", selectClause, fromClause, whereClause, orderBy, limit), queryParams
+}
+
+// ParseTime parses a time given on the command line, which may either be
+// absolute (2022-03-01, 2022-03-01 12:00:00, or RFC 3339) or relative to now
+// (36h, 7d, 2w)
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	if len(s) > 1 {
+		if unit, found := units[s[len(s)-1]]; found {
+			if n, err := strconv.Atoi(s[:len(s)-1]); err == nil {
+				return now.Add(-time.Duration(n) * unit), nil
+			}
+		}
+	}
+
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unable to parse time %q", s)
+}
+
+// Run runs sql against db, returning the names of the result columns and
+// each result row keyed by column name
+func Run(db *sql.DB, sql string, args ...any) ([]string, []map[string]string, error) {
+	return run(context.Background(), db, sql, args...)
+}
+
+// the subset of *sql.DB and *sql.Conn that run needs
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func run(ctx context.Context, db queryer, sql string, args ...any) ([]string, []map[string]string, error) {
+	slog.Debug("running SQL", "query", sql, "args", fmt.Sprintf("%#v", args))
+	startTime := time.Now()
+	rows, err := db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]map[string]string, 0)
+
+	rowValues := make([]string, len(columns))
+	scanPointers := make([]any, len(columns))
+	for i := range rowValues {
+		scanPointers[i] = &rowValues[i]
+	}
+
+	for rows.Next() {
+		err := rows.Scan(scanPointers...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rowData := make(map[string]string, len(columns))
+
+		for i, columnName := range columns {
+			rowData[columnName] = rowValues[i]
+		}
+
+		results = append(results, rowData)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	slog.Debug("# rows", "row_count", len(results), "duration", time.Since(startTime))
+
+	return columns, results, nil
+}
+
+// Search runs the query described by f against db
+func Search(db *sql.DB, f Filter) ([]string, []map[string]string, error) {
+	columns, results, _, err := SearchCounted(db, f)
+	return columns, results, err
+}
+
+// SearchCounted is Search, also returning how many history rows the query
+// scanned, which is only known for a HistoryTableBackend with CountScans set,
+// and -1 otherwise
+func SearchCounted(db *sql.DB, f Filter) ([]string, []map[string]string, int, error) {
+	sql, args := f.SQL()
+
+	if b, isHistoryTable := f.backend().(HistoryTableBackend); isHistoryTable && b.CountScans {
+		return runCounted(db, sql, args...)
+	}
+
+	columns, results, err := Run(db, sql, args...)
+	return columns, results, -1, err
+}
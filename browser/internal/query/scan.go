@@ -0,0 +1,68 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite counts the rows a statement scans, but the driver doesn't expose
+// those counters, so HistoryTableBackend counts them itself by calling
+// countScanFunction from the WHERE clause of its subquery of history, which
+// SQLite evaluates for every row it looks at
+const (
+	countScanFunction = "histdb_count_scan"
+	// returns the count so far, resetting it
+	takeScanCountFunction = "histdb_take_scan_count"
+)
+
+// RegisterScanCounter adds the functions that count the rows queries scan to
+// conn, for use as a driver's ConnectHook; a connection only runs one
+// statement at a time, so each gets a count of its own
+func RegisterScanCounter(conn *sqlite3.SQLiteConn) error {
+	scanned := 0
+
+	err := conn.RegisterFunc(countScanFunction, func() bool {
+		scanned++
+		return true
+	}, false)
+	if err != nil {
+		return err
+	}
+
+	return conn.RegisterFunc(takeScanCountFunction, func() int {
+		n := scanned
+		scanned = 0
+		return n
+	}, false)
+}
+
+// runCounted runs sql on a connection of its own, so as to find out how many
+// rows it scanned
+func runCounted(db *sql.DB, sql string, args ...any) ([]string, []map[string]string, int, error) {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer conn.Close()
+
+	// the connection's count includes whatever it ran before
+	var scanned int
+	if err := conn.QueryRowContext(ctx, "SELECT "+takeScanCountFunction+"()").Scan(&scanned); err != nil {
+		return nil, nil, 0, err
+	}
+
+	columns, results, err := run(ctx, conn, sql, args...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if err := conn.QueryRowContext(ctx, "SELECT "+takeScanCountFunction+"()").Scan(&scanned); err != nil {
+		return nil, nil, 0, err
+	}
+
+	return columns, results, scanned, nil
+}
@@ -0,0 +1,199 @@
+// Package redact hides secrets that end up in history, like tokens passed on
+// the command line or exported into the environment.
+package redact
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const Placeholder = "[REDACTED]"
+
+// Rule redacts whatever Pattern matches - or, if Pattern has a group named
+// "secret", just that part of it, so that the context stays readable
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// Builtins are the rules every Redactor starts with, covering common token
+// formats and the usual ways secrets get onto a command line
+var Builtins = []Rule{
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"gitlab-token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"stripe-key", regexp.MustCompile(`\b[rs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{"openai-key", regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{32,}\b`)},
+	{"jwt", regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	// export AWS_SECRET_ACCESS_KEY=..., GITHUB_TOKEN=... gh ..., and the like
+	{"secret-variable", regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|PASSPHRASE|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*=(?P<secret>'[^']*'|"[^"]*"|[^\s;&|]+)`)},
+	{"authorization-header", regexp.MustCompile(`(?i)\b(?:authorization|proxy-authorization):\s*(?:(?:bearer|basic|token|digest)\s+)?(?P<secret>[^\s'"]+)`)},
+	{"password-flag", regexp.MustCompile(`(?i)--(?:password|passwd|passphrase|token|api-key|secret)(?:=|\s+)(?P<secret>'[^']*'|"[^"]*"|[^\s;&|]+)`)},
+	{"url-credentials", regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`)},
+}
+
+type Redactor struct {
+	rules []Rule
+}
+
+func New(rules []Rule) *Redactor {
+	return &Redactor{rules: rules}
+}
+
+// Redact replaces every secret in s with Placeholder
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	for _, rule := range r.rules {
+		s = redactRule(rule, s)
+	}
+	return s
+}
+
+// Matches reports whether s contains anything that would be redacted
+func (r *Redactor) Matches(s string) bool {
+	return r.Redact(s) != s
+}
+
+func redactRule(rule Rule, s string) string {
+	secret := rule.Pattern.SubexpIndex("secret")
+
+	matches := rule.Pattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if secret >= 0 {
+			start, end = match[2*secret], match[2*secret+1]
+			if start < 0 {
+				continue
+			}
+		}
+
+		b.WriteString(s[last:start])
+		b.WriteString(Placeholder)
+		last = end
+	}
+
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// DefaultRulesPath is where Load looks for extra rules unless told otherwise:
+// $HISTDB_REDACT_RULES, or histdb/redact-rules in the user's configuration
+// directory
+func DefaultRulesPath() string {
+	if path := os.Getenv("HISTDB_REDACT_RULES"); path != "" {
+		return path
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "histdb", "redact-rules")
+}
+
+// ParseRules reads a rules file, in which each line holds a rule's name and
+// its pattern separated by whitespace, or an exclamation mark and the name of
+// a builtin rule to disable.  Blank lines and lines starting with # are
+// ignored.
+func ParseRules(r io.Reader) (rules []Rule, disabled []string, err error) {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if name, found := strings.CutPrefix(line, "!"); found {
+			disabled = append(disabled, strings.TrimSpace(name))
+			continue
+		}
+
+		i := strings.IndexFunc(line, unicode.IsSpace)
+		if i < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected a rule name and pattern", lineNumber)
+		}
+		name, pattern := line[:i], strings.TrimSpace(line[i:])
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		rules = append(rules, Rule{Name: name, Pattern: re})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return rules, disabled, nil
+}
+
+// Load builds a Redactor from the builtin rules and those in the rules file at
+// path, which doesn't need to exist
+func Load(path string) (*Redactor, error) {
+	rules := append([]Rule{}, Builtins...)
+
+	if path == "" {
+		return New(rules), nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return New(rules), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	extra, disabled, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, name := range disabled {
+		i := indexOfRule(rules, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%s: no builtin rule named %q", path, name)
+		}
+		rules = append(rules[:i], rules[i+1:]...)
+	}
+
+	return New(append(rules, extra...)), nil
+}
+
+func indexOfRule(rules []Rule, name string) int {
+	for i, rule := range rules {
+		if rule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
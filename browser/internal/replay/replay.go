@@ -0,0 +1,287 @@
+// Package replay reads the browser's JSON logs back, so that a logged
+// session can be re-driven through the model and compared against what
+// happened originally.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type EventKind int
+
+const (
+	KeyPress EventKind = iota
+	Resize
+	// the undo window for a deletion passed, committing it
+	UndoWindowPassed
+	Mouse
+)
+
+// Event is something that drove the browser, in the order it happened
+type Event struct {
+	Kind EventKind
+	// when it happened, which is zero if the log doesn't say
+	Time time.Time
+
+	Key           tea.KeyMsg
+	Mouse         tea.MouseMsg
+	Width, Height int
+	DeletionID    int
+}
+
+// Start is how the browser was started
+type Start struct {
+	Query            string
+	QueryCursor      int
+	SessionID        string
+	HorizonTimestamp int64
+	FloatPins        bool
+	Backend          string // empty for logs that predate the choice
+	NoRedact         bool
+	RedactRules      string // empty for logs that predate logging it
+}
+
+// Query is a query as the browser logs it
+type Query struct {
+	SQL  string
+	Args string
+}
+
+func (q Query) String() string {
+	return q.SQL + " " + q.Args
+}
+
+type Log struct {
+	PID string
+
+	// nil if the log doesn't say how the browser was started
+	Start *Start
+
+	Events  []Event
+	Queries []Query
+
+	// the rowid of the selected row, which is empty if nothing was selected -
+	// only meaningful if SelectionLogged is set
+	Selection       string
+	SelectionLogged bool
+}
+
+var keysByName map[string]tea.KeyType
+
+// KeyMsg turns a key as logged (from tea.KeyMsg.String) back into the
+// message it came from
+func KeyMsg(s string) tea.KeyMsg {
+	if keysByName == nil {
+		keysByName = make(map[string]tea.KeyType)
+		// the named keys are the control characters and a range of negative
+		// values for everything else
+		for keyType := tea.KeyType(-128); keyType < 128; keyType++ {
+			if keyType == tea.KeyRunes {
+				continue
+			}
+			if name := (tea.Key{Type: keyType}).String(); name != "" {
+				if _, seen := keysByName[name]; !seen {
+					keysByName[name] = keyType
+				}
+			}
+		}
+	}
+
+	if keyType, found := keysByName[s]; found {
+		return tea.KeyMsg{Type: keyType}
+	}
+
+	alt := false
+	if rest, found := strings.CutPrefix(s, "alt+"); found && rest != "" {
+		if keyType, found := keysByName[rest]; found {
+			return tea.KeyMsg{Type: keyType, Alt: true}
+		}
+		alt = true
+		s = rest
+	}
+
+	// pastes are logged in brackets
+	if len(s) > 2 && strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s[1 : len(s)-1]), Alt: alt, Paste: true}
+	}
+
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s), Alt: alt}
+}
+
+// Parse reads the log for the browser session with the given PID from a JSON
+// log, or the first session in the log if pid is empty
+func Parse(r io.Reader, pid string) (*Log, error) {
+	l := &Log{PID: pid}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.UseNumber()
+
+		record := make(map[string]any)
+		if err := decoder.Decode(&record); err != nil {
+			// bubbletea and friends log plain text to the same file
+			continue
+		}
+
+		if record["browser_pid"] == nil {
+			continue
+		}
+
+		recordPID := fmt.Sprint(record["browser_pid"])
+		if l.PID == "" {
+			l.PID = recordPID
+		}
+		if recordPID != l.PID {
+			continue
+		}
+
+		str := func(key string) string {
+			s, _ := record[key].(string)
+			return s
+		}
+		num := func(key string) int64 {
+			n, _ := record[key].(json.Number)
+			i, _ := n.Int64()
+			return i
+		}
+		flag := func(key string) bool {
+			b, _ := record[key].(bool)
+			return b
+		}
+		at, _ := time.Parse(time.RFC3339Nano, str("time"))
+
+		switch record["msg"] {
+		case "starting browser":
+			l.Start = &Start{
+				Query:            str("query"),
+				QueryCursor:      int(num("query_cursor")),
+				SessionID:        str("session_id"),
+				HorizonTimestamp: num("horizon_timestamp"),
+				FloatPins:        flag("float_pins"),
+				Backend:          str("backend"),
+				// logs that don't say were written by browsers that always
+				// redacted
+				NoRedact:    record["redact"] == false,
+				RedactRules: str("redact_rules"),
+			}
+		case "window resized":
+			l.Events = append(l.Events, Event{Kind: Resize, Time: at, Width: int(num("width")), Height: int(num("height"))})
+		case "got keypress":
+			l.Events = append(l.Events, Event{Kind: KeyPress, Time: at, Key: KeyMsg(str("key"))})
+		case "got mouse event":
+			msg := tea.MouseMsg{
+				X:      int(num("x")),
+				Y:      int(num("y")),
+				Button: tea.MouseButton(num("button")),
+				Action: tea.MouseAction(num("action")),
+				Alt:    flag("alt"),
+				Ctrl:   flag("ctrl"),
+				Shift:  flag("shift"),
+			}
+			l.Events = append(l.Events, Event{Kind: Mouse, Time: at, Mouse: msg})
+		case "undo window passed":
+			l.Events = append(l.Events, Event{Kind: UndoWindowPassed, Time: at, DeletionID: int(num("deletion_id"))})
+		case "running SQL":
+			l.Queries = append(l.Queries, Query{SQL: str("query"), Args: str("args")})
+		case "selected row":
+			l.Selection = str("rowid")
+			l.SelectionLogged = true
+		case "no row selected":
+			l.Selection = ""
+			l.SelectionLogged = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if l.PID == "" {
+		return nil, errors.New("no browser sessions found in log (was it written with --log-format json?)")
+	}
+
+	if len(l.Events) == 0 {
+		return nil, fmt.Errorf("no keypresses logged for browser %s (was it written with --log-level debug?)", l.PID)
+	}
+
+	return l, nil
+}
+
+// Recorder is a log handler that keeps the queries logged through it
+type Recorder struct {
+	Queries []Query
+}
+
+func (h *Recorder) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *Recorder) Handle(_ context.Context, r slog.Record) error {
+	if r.Message != "running SQL" {
+		return nil
+	}
+
+	q := Query{}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "query":
+			q.SQL = a.Value.String()
+		case "args":
+			q.Args = a.Value.String()
+		}
+		return true
+	})
+	h.Queries = append(h.Queries, q)
+
+	return nil
+}
+
+func (h *Recorder) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+func (h *Recorder) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Divergence is a query that differs between the log and the replay; either
+// side is nil if it ran fewer queries
+type Divergence struct {
+	Index    int
+	Logged   *Query
+	Replayed *Query
+}
+
+// Diff compares the queries from the log with those from the replay
+func Diff(logged, replayed []Query) []Divergence {
+	divergences := make([]Divergence, 0)
+
+	for i := 0; i < max(len(logged), len(replayed)); i++ {
+		d := Divergence{Index: i}
+		if i < len(logged) {
+			d.Logged = &logged[i]
+		}
+		if i < len(replayed) {
+			d.Replayed = &replayed[i]
+		}
+
+		if d.Logged == nil || d.Replayed == nil || *d.Logged != *d.Replayed {
+			divergences = append(divergences, d)
+		}
+	}
+
+	return divergences
+}
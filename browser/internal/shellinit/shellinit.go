@@ -0,0 +1,54 @@
+package shellinit
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"hoelz.ro/histdb-browser/internal/output"
+)
+
+//go:embed scripts/*.tmpl
+var scriptFS embed.FS
+
+var scripts = template.Must(template.New("").Funcs(template.FuncMap{
+	"quote": output.ShellQuote,
+}).ParseFS(scriptFS, "scripts/*.tmpl"))
+
+var Shells = []string{"zsh", "bash", "fish"}
+
+// how the widget combines the selection with the command line buffer
+const (
+	InsertReplace = "replace"
+	InsertAppend  = "append"
+)
+
+type Options struct {
+	// the path to the histdb-browser binary the widget should run
+	Executable string
+	// whether the widget should ask for the selected row's OID rather than its entry
+	PrintOID bool
+	// InsertReplace or InsertAppend, which searches for the word before the
+	// cursor and replaces just that; defaults to InsertReplace
+	InsertMode string
+}
+
+// Write writes a widget for shell that binds ctrl+r to the browser
+func Write(w io.Writer, shell string, opts Options) error {
+	tmpl := scripts.Lookup(shell + ".tmpl")
+	if tmpl == nil {
+		return fmt.Errorf("unsupported shell %q (expected one of %s)", shell, strings.Join(Shells, ", "))
+	}
+
+	switch opts.InsertMode {
+	case "":
+		opts.InsertMode = InsertReplace
+	case InsertReplace, InsertAppend:
+	default:
+		return fmt.Errorf("unsupported insert mode %q (expected %s or %s)", opts.InsertMode, InsertReplace, InsertAppend)
+	}
+
+	return tmpl.Execute(w, opts)
+}
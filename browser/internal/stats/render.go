@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// eighths of a full block, for drawing bars with sub-character precision
+var barBlocks = []rune(" ▏▎▍▌▋▊▉█")
+
+// Sparkline renders values as a row of block characters whose heights are
+// proportional to each value
+func Sparkline(values []int) string {
+	maxValue := 0
+	for _, v := range values {
+		maxValue = max(maxValue, v)
+	}
+
+	var b strings.Builder
+	for _, v := range values {
+		if v == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		// anything non-zero gets at least the shortest block, so that it's
+		// distinguishable from nothing at all
+		b.WriteRune(sparkBlocks[(v*(len(sparkBlocks)-1))/maxValue])
+	}
+	return b.String()
+}
+
+// Bar renders value as a horizontal bar scaled so that maxValue fills width
+// cells
+func Bar(value, maxValue, width int) string {
+	if maxValue <= 0 || width <= 0 {
+		return ""
+	}
+
+	eighths := value * width * 8 / maxValue
+	bar := strings.Repeat(string(barBlocks[8]), eighths/8)
+	if eighths%8 != 0 {
+		bar += string(barBlocks[eighths%8])
+	}
+	return bar
+}
+
+func truncate(s string, width int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+func renderCounts(b *strings.Builder, title string, counts []Count, width int) {
+	fmt.Fprintf(b, "%s\n", title)
+
+	if len(counts) == 0 {
+		b.WriteString("  (none)\n")
+		return
+	}
+
+	nameWidth := 0
+	for _, c := range counts {
+		nameWidth = max(nameWidth, utf8.RuneCountInString(c.Name))
+	}
+	nameWidth = min(nameWidth, width/3)
+
+	barWidth := max(width-nameWidth-12, 1)
+	for _, c := range counts {
+		name := truncate(c.Name, nameWidth)
+		fmt.Fprintf(b, "  %-*s %6d %s\n", nameWidth, name, c.Count, Bar(c.Count, counts[0].Count, barWidth))
+	}
+}
+
+// maxDaysShown keeps the per-day histogram to a single line on most terminals
+const maxDaysShown = 60
+
+// MinWidth is the narrowest width that statistics can be laid out in;
+// anything narrower is laid out at this width anyway
+const MinWidth = 40
+
+// Render lays s out as a text dashboard fitting in width columns
+func (s *Stats) Render(width int) string {
+	// the browser doesn't know how wide the terminal is until it's told
+	width = max(width, MinWidth)
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%d commands\n\n", s.Total)
+
+	renderCounts(&b, "Top commands", s.TopCommands, width)
+	b.WriteString("\n")
+	renderCounts(&b, "Top directories", s.TopDirectories, width)
+	b.WriteString("\n")
+
+	b.WriteString("Commands per hour\n")
+	fmt.Fprintf(&b, "  %s\n", Sparkline(s.PerHour[:]))
+	b.WriteString("  0     6     12    18   23\n\n")
+
+	days := s.PerDay
+	if daysShown := min(maxDaysShown, width-4); len(days) > daysShown {
+		days = days[len(days)-daysShown:]
+	}
+	dayCounts := make([]int, len(days))
+	for i, d := range days {
+		dayCounts[i] = d.Count
+	}
+	b.WriteString("Commands per day\n")
+	if len(days) > 0 {
+		fmt.Fprintf(&b, "  %s\n", Sparkline(dayCounts))
+		fmt.Fprintf(&b, "  %s – %s\n\n", days[0].Name, days[len(days)-1].Name)
+	} else {
+		b.WriteString("  (none)\n\n")
+	}
+
+	b.WriteString("Failure rates\n")
+	if len(s.FailureRates) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	for _, f := range s.FailureRates {
+		fmt.Fprintf(&b, "  %-20s %5.1f%% (%d/%d)\n", truncate(f.Command, 20), f.Rate()*100, f.Failures, f.Runs)
+	}
+	b.WriteString("\n")
+
+	b.WriteString("Longest-running commands\n")
+	if len(s.LongestRunning) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	for _, r := range s.LongestRunning {
+		fmt.Fprintf(&b, "  %10s  %s  %s\n", r.Duration.Round(time.Second), r.Timestamp.Format(time.DateOnly), truncate(r.Entry, max(width-28, 10)))
+	}
+
+	return b.String()
+}
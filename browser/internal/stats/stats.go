@@ -0,0 +1,261 @@
+package stats
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+// Row is the subset of a history row that statistics are computed from
+type Row struct {
+	Entry     string
+	Cwd       string
+	Timestamp time.Time
+	// Duration is negative if unknown
+	Duration time.Duration
+	// ExitStatus is nil if unknown
+	ExitStatus *int
+}
+
+type Count struct {
+	Name  string
+	Count int
+}
+
+type FailureRate struct {
+	Command  string
+	Runs     int
+	Failures int
+}
+
+func (f FailureRate) Rate() float64 {
+	return float64(f.Failures) / float64(f.Runs)
+}
+
+type Stats struct {
+	Total          int
+	TopCommands    []Count
+	TopDirectories []Count
+	// PerDay is ordered chronologically, with days without any commands included
+	PerDay         []Count
+	PerHour        [24]int
+	FailureRates   []FailureRate
+	LongestRunning []Row
+}
+
+// minimumRunsForFailureRate keeps commands that have only run a handful of
+// times from dominating the failure rates
+const minimumRunsForFailureRate = 5
+
+// 148 (SIGTSTP) is a command being suspended rather than failing, which is
+// also what the browser goes by when hiding failed commands
+func isFailure(exitStatus int) bool {
+	return exitStatus != 0 && exitStatus != 148
+}
+
+// CommandName extracts the program from entry, skipping over any leading
+// variable assignments and wrappers like sudo
+func CommandName(entry string) string {
+	for _, word := range strings.Fields(entry) {
+		if strings.Contains(word, "=") && !strings.HasPrefix(word, "=") {
+			continue
+		}
+
+		switch word {
+		case "sudo", "doas", "nohup", "time", "exec", "command", "builtin", "noglob":
+			continue
+		}
+
+		return word
+	}
+
+	return ""
+}
+
+// Accumulator computes Stats from rows fed to it one at a time, so that a
+// whole history never needs to be in memory
+type Accumulator struct {
+	stats       Stats
+	commands    map[string]int
+	directories map[string]int
+	days        map[string]int
+	failures    map[string]*FailureRate
+	first, last time.Time
+}
+
+func NewAccumulator() *Accumulator {
+	return &Accumulator{
+		commands:    make(map[string]int),
+		directories: make(map[string]int),
+		days:        make(map[string]int),
+		failures:    make(map[string]*FailureRate),
+	}
+}
+
+func (a *Accumulator) Add(r Row) {
+	a.stats.Total++
+
+	command := CommandName(r.Entry)
+	if command != "" {
+		a.commands[command]++
+	}
+
+	if r.Cwd != "" {
+		a.directories[r.Cwd]++
+	}
+
+	if !r.Timestamp.IsZero() {
+		a.days[r.Timestamp.Format(time.DateOnly)]++
+		a.stats.PerHour[r.Timestamp.Hour()]++
+
+		if a.first.IsZero() || r.Timestamp.Before(a.first) {
+			a.first = r.Timestamp
+		}
+		if r.Timestamp.After(a.last) {
+			a.last = r.Timestamp
+		}
+	}
+
+	if command != "" && r.ExitStatus != nil {
+		f := a.failures[command]
+		if f == nil {
+			f = &FailureRate{Command: command}
+			a.failures[command] = f
+		}
+		f.Runs++
+		if isFailure(*r.ExitStatus) {
+			f.Failures++
+		}
+	}
+
+	if r.Duration >= 0 {
+		a.stats.LongestRunning = append(a.stats.LongestRunning, r)
+		sort.SliceStable(a.stats.LongestRunning, func(i, j int) bool {
+			return a.stats.LongestRunning[i].Duration > a.stats.LongestRunning[j].Duration
+		})
+		// this is only called with one new row at a time, so we never need to
+		// trim more than one
+		if len(a.stats.LongestRunning) > maxListLength {
+			a.stats.LongestRunning = a.stats.LongestRunning[:maxListLength]
+		}
+	}
+}
+
+const maxListLength = 10
+
+func topCounts(counts map[string]int) []Count {
+	result := make([]Count, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, Count{Name: name, Count: count})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	if len(result) > maxListLength {
+		result = result[:maxListLength]
+	}
+
+	return result
+}
+
+// Stats finishes up the statistics for the rows added so far
+func (a *Accumulator) Stats() *Stats {
+	s := a.stats
+
+	s.TopCommands = topCounts(a.commands)
+	s.TopDirectories = topCounts(a.directories)
+
+	if !a.first.IsZero() {
+		firstDay := time.Date(a.first.Year(), a.first.Month(), a.first.Day(), 0, 0, 0, 0, a.first.Location())
+		for day := firstDay; !day.After(a.last); day = day.AddDate(0, 0, 1) {
+			name := day.Format(time.DateOnly)
+			s.PerDay = append(s.PerDay, Count{Name: name, Count: a.days[name]})
+		}
+	}
+
+	for _, f := range a.failures {
+		if f.Runs >= minimumRunsForFailureRate && f.Failures > 0 {
+			s.FailureRates = append(s.FailureRates, *f)
+		}
+	}
+
+	sort.Slice(s.FailureRates, func(i, j int) bool {
+		if s.FailureRates[i].Rate() != s.FailureRates[j].Rate() {
+			return s.FailureRates[i].Rate() > s.FailureRates[j].Rate()
+		}
+		return s.FailureRates[i].Command < s.FailureRates[j].Command
+	})
+
+	if len(s.FailureRates) > maxListLength {
+		s.FailureRates = s.FailureRates[:maxListLength]
+	}
+
+	return &s
+}
+
+// Collect computes statistics over the rows matched by f (ignoring its columns
+// and limit) in a single pass over the h table
+func Collect(db *sql.DB, f query.Filter) (*Stats, error) {
+	fromClause, params := f.From()
+	whereClause, whereParams := f.Where()
+	params = append(params, whereParams...)
+	statement := fmt.Sprintf("SELECT entry, cwd, raw_timestamp, duration, exit_status FROM %s WHERE %s", fromClause, whereClause)
+
+	slog.Debug("running SQL", "query", statement, "args", fmt.Sprintf("%#v", params))
+	startTime := time.Now()
+
+	rows, err := db.Query(statement, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	a := NewAccumulator()
+
+	for rows.Next() {
+		var entry, cwd sql.NullString
+		var timestamp, duration, exitStatus sql.NullInt64
+
+		if err := rows.Scan(&entry, &cwd, &timestamp, &duration, &exitStatus); err != nil {
+			return nil, err
+		}
+
+		r := Row{
+			Entry:     entry.String,
+			Cwd:       cwd.String,
+			Timestamp: time.Unix(timestamp.Int64, 0),
+			Duration:  -1,
+		}
+
+		if duration.Valid {
+			r.Duration = time.Duration(duration.Int64) * time.Second
+		}
+
+		if exitStatus.Valid {
+			status := int(exitStatus.Int64)
+			r.ExitStatus = &status
+		}
+
+		a.Add(r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s := a.Stats()
+
+	slog.Debug("computed statistics", "row_count", s.Total, "duration", time.Since(startTime))
+
+	return s, nil
+}
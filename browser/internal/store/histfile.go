@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fmt"
+	"os"
+
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/stats"
+)
+
+// HistoryFile is a read-only HistoryStore over the commands in a shell's own
+// history file, for machines without a histdb database
+type HistoryFile struct {
+	memory *Memory
+}
+
+// NewHistoryFile creates a store over rows read from a history file
+func NewHistoryFile(rows []history.Row) *HistoryFile {
+	return &HistoryFile{memory: NewMemory(rows...)}
+}
+
+// LoadHistoryFile reads the history file at path in the given format (see
+// histfile.Formats), guessing the format from the file's name if it's empty
+func LoadHistoryFile(path, format string) (*HistoryFile, error) {
+	if format == "" {
+		format = histfile.GuessFormat(path)
+		if format == "" {
+			return nil, fmt.Errorf("unable to guess the format of %s; please specify it", path)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := histfile.Parse(format, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// failing to determine this isn't fatal - it'll just be empty
+	hostname, _ := os.Hostname()
+
+	// commands without timestamps are left without them rather than being
+	// given made-up ones; they still keep their order, by rowid
+	rows := histfile.Rows(entries, history.Row{Hostname: hostname, SessionID: "file:" + path})
+
+	return NewHistoryFile(rows), nil
+}
+
+// filter drops the parts of f that don't apply to history files, which don't
+// record exit statuses or sessions: no command is known to have failed, and
+// there are no other sessions' commands to hide
+func (h *HistoryFile) filter(f query.Filter) query.Filter {
+	f.ShowFailedCommands = true
+	f.ShowGlobalCommands = true
+	return f
+}
+
+func (h *HistoryFile) Search(f query.Filter) ([]string, []map[string]string, error) {
+	return h.memory.Search(h.filter(f))
+}
+
+func (h *HistoryFile) RowsScanned() int {
+	return h.memory.RowsScanned()
+}
+
+func (h *HistoryFile) Get(rowid int64, fields []string) (map[string]string, bool) {
+	return h.memory.Get(rowid, fields)
+}
+
+func (h *HistoryFile) Session(sessionID string) ([]history.Row, error) {
+	return h.memory.Session(sessionID)
+}
+
+func (h *HistoryFile) Stats(f query.Filter) (*stats.Stats, error) {
+	return h.memory.Stats(h.filter(f))
+}
+
+func (h *HistoryFile) Delete(rowids []int64) (int, error) {
+	return 0, ErrReadOnly
+}
+
+func (h *HistoryFile) Insert(r history.Row) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+func (h *HistoryFile) ReadOnly() bool {
+	return true
+}
@@ -0,0 +1,267 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/stats"
+)
+
+// Memory is a HistoryStore that keeps its rows in memory.  Queries match the
+// way they do against the h virtual table, but there are no tags, notes, or
+// pins, so tag:NAME terms never match and those columns are always empty.
+type Memory struct {
+	mu        sync.Mutex
+	rows      []history.Row
+	lastRowid int64
+}
+
+// NewMemory creates a store holding rows; any without a rowid are assigned
+// one
+func NewMemory(rows ...history.Row) *Memory {
+	m := &Memory{}
+	for _, r := range rows {
+		m.Insert(r)
+	}
+	return m
+}
+
+// matches reports whether f selects r
+func matches(f query.Filter, r history.Row) bool {
+	terms := query.ParseTerms(f.Query)
+	if len(terms.Tags) > 0 {
+		return false
+	}
+
+	// like the virtual table, every word has to appear somewhere in the entry
+	entry := strings.ToLower(r.Entry)
+	for _, word := range strings.Fields(terms.Text) {
+		if !strings.Contains(entry, strings.ToLower(word)) {
+			return false
+		}
+	}
+
+	for _, cwd := range terms.Cwds {
+		if !strings.Contains(strings.ToLower(r.Cwd), strings.ToLower(cwd)) {
+			return false
+		}
+	}
+
+	for _, expr := range terms.Timestamps {
+		start, end, err := query.ResolveTimestampMatch(expr, time.Now())
+		if err != nil || r.Timestamp.IsZero() || r.Timestamp.Unix() < start.Unix() || r.Timestamp.Unix() > end.Unix() {
+			return false
+		}
+	}
+
+	if !f.ShowFailedCommands && (r.ExitStatus == nil || (*r.ExitStatus != 0 && *r.ExitStatus != 148)) {
+		return false
+	}
+
+	if !f.ShowGlobalCommands && f.HorizonTimestamp.Unix() != 0 {
+		if r.Timestamp.Unix() > f.HorizonTimestamp.Unix() && r.SessionID != f.SessionID {
+			return false
+		}
+	}
+
+	if !f.Since.IsZero() && r.Timestamp.Unix() < f.Since.Unix() {
+		return false
+	}
+
+	if !f.Until.IsZero() && r.Timestamp.Unix() >= f.Until.Unix() {
+		return false
+	}
+
+	if f.Hostname != "" && r.Hostname != f.Hostname {
+		return false
+	}
+
+	return !slices.Contains(f.ExcludeRowids, r.Rowid)
+}
+
+func formatNullable(n *int64) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.FormatInt(*n, 10)
+}
+
+// column renders r's value for the given column the way SQLite would
+func column(r history.Row, name string) string {
+	switch name {
+	case "rowid":
+		return strconv.FormatInt(r.Rowid, 10)
+	case "timestamp":
+		if r.Timestamp.IsZero() {
+			// commands from history files may not have one
+			return ""
+		}
+		return r.Timestamp.Local().Format(time.DateTime)
+	case "session_id":
+		return r.SessionID
+	case "cwd":
+		return r.Cwd
+	case "hostname":
+		return r.Hostname
+	case "duration":
+		return formatNullable(r.Duration)
+	case "entry":
+		return r.Entry
+	case "exit_status":
+		return formatNullable(r.ExitStatus)
+	default:
+		// tags and notes
+		return ""
+	}
+}
+
+// RowsScanned is always all of them, since there's no index to narrow them
+// down with
+func (m *Memory) RowsScanned() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rows)
+}
+
+func (m *Memory) Search(f query.Filter) ([]string, []map[string]string, error) {
+	if err := f.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := make([]history.Row, 0)
+	for _, r := range m.rows {
+		if matches(f, r) {
+			matched = append(matched, r)
+		}
+	}
+
+	slices.SortStableFunc(matched, func(a, b history.Row) int {
+		return cmp.Or(b.Timestamp.Compare(a.Timestamp), cmp.Compare(b.Rowid, a.Rowid))
+	})
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = query.DefaultLimit
+	}
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	columns := append(append([]string{"rowid"}, f.Columns...), "entry", "exit_status")
+
+	results := make([]map[string]string, 0, len(matched))
+	for _, r := range matched {
+		result := make(map[string]string, len(columns))
+		for _, name := range columns {
+			result[name] = column(r, name)
+		}
+		results = append(results, result)
+	}
+
+	return columns, results, nil
+}
+
+// Get returns the given fields of the row with the given rowid, rendered the
+// same way Search renders them
+func (m *Memory) Get(rowid int64, fields []string) (map[string]string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.rows {
+		if r.Rowid == rowid {
+			result := make(map[string]string, len(fields))
+			for _, name := range fields {
+				result[name] = column(r, name)
+			}
+			return result, true
+		}
+	}
+
+	return nil, false
+}
+
+func (m *Memory) Session(sessionID string) ([]history.Row, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows := make([]history.Row, 0)
+	for _, r := range m.rows {
+		if r.SessionID == sessionID {
+			rows = append(rows, r)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b history.Row) int {
+		return cmp.Or(a.Timestamp.Compare(b.Timestamp), cmp.Compare(a.Rowid, b.Rowid))
+	})
+
+	return rows, nil
+}
+
+func (m *Memory) Stats(f query.Filter) (*stats.Stats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := stats.NewAccumulator()
+
+	for _, r := range m.rows {
+		if !matches(f, r) {
+			continue
+		}
+
+		sr := stats.Row{
+			Entry:     r.Entry,
+			Cwd:       r.Cwd,
+			Timestamp: r.Timestamp,
+			Duration:  -1,
+		}
+
+		if r.Duration != nil {
+			sr.Duration = time.Duration(*r.Duration) * time.Second
+		}
+
+		if r.ExitStatus != nil {
+			status := int(*r.ExitStatus)
+			sr.ExitStatus = &status
+		}
+
+		a.Add(sr)
+	}
+
+	return a.Stats(), nil
+}
+
+func (m *Memory) Delete(rowids []int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := len(m.rows)
+	m.rows = slices.DeleteFunc(m.rows, func(r history.Row) bool {
+		return slices.Contains(rowids, r.Rowid)
+	})
+
+	return before - len(m.rows), nil
+}
+
+func (m *Memory) Insert(r history.Row) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.Rowid == 0 {
+		r.Rowid = m.lastRowid + 1
+	}
+	m.lastRowid = max(m.lastRowid, r.Rowid)
+
+	m.rows = append(m.rows, r)
+
+	return r.Rowid, nil
+}
@@ -0,0 +1,158 @@
+// Package store abstracts where the browser's history comes from, so that the
+// TUI doesn't need to care whether it's a histdb database or something else.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"sync/atomic"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/stats"
+)
+
+// HistoryStore is a source of history that can be searched and changed
+type HistoryStore interface {
+	// Search returns the names of the result columns and the rows f matches,
+	// keyed by column name, the same way query.Search does
+	Search(f query.Filter) ([]string, []map[string]string, error)
+
+	// Session returns the commands run in the given session, oldest first
+	Session(sessionID string) ([]history.Row, error)
+
+	// Stats computes statistics over the rows f matches, ignoring its columns
+	// and limit
+	Stats(f query.Filter) (*stats.Stats, error)
+
+	// Delete removes the given rows, along with anything attached to them,
+	// returning how many of them existed
+	Delete(rowids []int64) (int, error)
+
+	// Insert adds r, returning its rowid
+	Insert(r history.Row) (int64, error)
+}
+
+// ErrReadOnly is returned when trying to change a store that can't be changed
+var ErrReadOnly = errors.New("this history source is read-only")
+
+// IsReadOnly reports whether s refuses changes, which stores can indicate
+// with a ReadOnly method
+func IsReadOnly(s HistoryStore) bool {
+	r, ok := s.(interface{ ReadOnly() bool })
+	return ok && r.ReadOnly()
+}
+
+// RowsScanned reports how many history rows s looked at for its last search,
+// which stores can indicate with a RowsScanned method; -1 means it isn't
+// known
+func RowsScanned(s HistoryStore) int {
+	if r, ok := s.(interface{ RowsScanned() int }); ok {
+		return r.RowsScanned()
+	}
+	return -1
+}
+
+// SQLite is a HistoryStore backed by a histdb database
+type SQLite struct {
+	db      *sql.DB
+	backend query.Backend
+
+	rowsScanned atomic.Int64
+}
+
+// NewSQLite creates a store that searches db through backend (which
+// db needs to have been opened for)
+func NewSQLite(db *sql.DB, backend query.Backend) *SQLite {
+	s := &SQLite{db: db, backend: backend}
+	s.rowsScanned.Store(-1)
+	return s
+}
+
+func (s *SQLite) filter(f query.Filter) (query.Filter, error) {
+	if f.Backend == nil {
+		f.Backend = s.backend
+	}
+	return f.ForDatabase(s.db)
+}
+
+func (s *SQLite) Search(f query.Filter) ([]string, []map[string]string, error) {
+	s.rowsScanned.Store(-1)
+
+	f, err := s.filter(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns, results, scanned, err := query.SearchCounted(s.db, f)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.rowsScanned.Store(int64(scanned))
+
+	return columns, results, nil
+}
+
+// RowsScanned is only known for backends that count them (see
+// query.SearchCounted)
+func (s *SQLite) RowsScanned() int {
+	return int(s.rowsScanned.Load())
+}
+
+func (s *SQLite) Session(sessionID string) ([]history.Row, error) {
+	rows := make([]history.Row, 0)
+
+	err := history.Each(s.db, history.Filter{SessionID: sessionID}, func(r history.Row) error {
+		rows = append(rows, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (s *SQLite) Stats(f query.Filter) (*stats.Stats, error) {
+	f, err := s.filter(f)
+	if err != nil {
+		return nil, err
+	}
+	return stats.Collect(s.db, f)
+}
+
+// Delete deletes securely (see history.WithSecureDelete), since entries are
+// mostly deleted for having something in them that shouldn't be kept
+func (s *SQLite) Delete(rowids []int64) (int, error) {
+	deleted := 0
+
+	err := history.WithSecureDelete(s.db, func(tx *sql.Tx) error {
+		var err error
+		deleted, err = history.Delete(tx, rowids)
+		if err != nil {
+			return err
+		}
+
+		if err := annotation.Forget(tx, rowids); err != nil {
+			return err
+		}
+
+		if err := pins.Forget(tx, rowids); err != nil {
+			return err
+		}
+
+		return fts.Forget(tx, rowids)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func (s *SQLite) Insert(r history.Row) (int64, error) {
+	return history.Insert(s.db, r)
+}
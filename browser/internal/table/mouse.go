@@ -0,0 +1,212 @@
+package table
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/evertras/bubble-table/table"
+)
+
+// SelectedMsg is sent when a row is double-clicked
+type SelectedMsg struct {
+	Row Row
+}
+
+// doubleClickInterval is how soon a second click on a row needs to follow the
+// first to count as a double click
+const doubleClickInterval = 500 * time.Millisecond
+
+// wheelLines is how far the mouse wheel scrolls
+const wheelLines = 3
+
+// updateMouse handles mouse events, whose positions need to be relative to
+// the table's top left corner
+func (t *Table) updateMouse(msg tea.MouseMsg) (*Table, tea.Cmd) {
+	switch {
+	case msg.Button == tea.MouseButtonWheelDown && msg.Action == tea.MouseActionPress:
+		nt := *t
+		nt.scrollLines(wheelLines)
+		return &nt, nil
+
+	case msg.Button == tea.MouseButtonWheelUp && msg.Action == tea.MouseActionPress:
+		nt := *t
+		nt.scrollLines(-wheelLines)
+		return &nt, nil
+
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress:
+		headerHeight := strings.Count(t.headerView(), "\n") + 1
+		if msg.Y < headerHeight {
+			if key := t.columnAt(msg.X); key != "" {
+				return t.cycleSort(key), nil
+			}
+			return t, nil
+		}
+
+		now := time.Now
+		if t.clock != nil {
+			now = t.clock
+		}
+		return t.click(msg.Y-headerHeight, now())
+	}
+
+	return t, nil
+}
+
+// columnDivider is drawn around each column when rendering the header to
+// find out where the columns are
+const columnDivider = "│"
+
+// columnAt returns the key of the column at x, or the empty string if it's
+// between columns
+func (t *Table) columnAt(x int) string {
+	// let the inner table lay the header out, with dividers to show where
+	// each column starts and ends
+	header := t.inner.WithRows([]table.Row{}).Border(table.Border{
+		Left:          columnDivider,
+		Right:         columnDivider,
+		InnerDivider:  columnDivider,
+		RightJunction: " ",
+		BottomRight:   " ",
+	}).View()
+
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.Contains(line, columnDivider) {
+			continue
+		}
+
+		cells := strings.Split(line, columnDivider)
+		start := lipgloss.Width(cells[0]) + 1
+		for i, cell := range cells[1:min(len(cells), len(t.columns)+1)] {
+			width := lipgloss.Width(cell)
+			if x >= start && x < start+width {
+				return t.columns[i].Key()
+			}
+			start += width + 1
+		}
+		break
+	}
+
+	return ""
+}
+
+// rowAt returns the index of the row shown on the given line of the
+// viewport, or -1 if there isn't one
+func (t *Table) rowAt(line int) int {
+	if line < 0 || line >= t.v.Height {
+		return -1
+	}
+
+	rows := t.inner.GetVisibleRows()
+	offset := line + t.topSkip
+	for i := t.top; i < len(rows); i++ {
+		height := t.rowHeight(i)
+		if offset < height {
+			return i
+		}
+		offset -= height
+	}
+
+	return -1
+}
+
+// click highlights the row on the given line of the viewport, selecting it
+// if it was clicked twice in quick succession
+func (t *Table) click(line int, now time.Time) (*Table, tea.Cmd) {
+	index := t.rowAt(line)
+	if index < 0 {
+		return t, nil
+	}
+
+	nt := *t
+	nt.inner = t.inner.WithHighlightedRow(index)
+	nt.scrollToHighlight()
+
+	if index == t.lastClickRow && now.Sub(t.lastClickAt) < doubleClickInterval {
+		// a third click starts over
+		nt.lastClickAt = time.Time{}
+
+		row := nt.inner.HighlightedRow()
+		return &nt, func() tea.Msg {
+			return SelectedMsg{Row: row}
+		}
+	}
+
+	nt.lastClickRow = index
+	nt.lastClickAt = now
+
+	return &nt, nil
+}
+
+// scrollLines scrolls the viewport by amount lines (up if it's negative),
+// moving the highlight along if it would otherwise go out of view
+func (t *Table) scrollLines(amount int) {
+	rows := t.inner.GetVisibleRows()
+	if len(rows) == 0 {
+		return
+	}
+
+	for ; amount > 0 && !t.atBottom(); amount-- {
+		t.topSkip++
+		if t.topSkip >= t.rowHeight(t.top) {
+			t.top++
+			t.topSkip = 0
+		}
+	}
+
+	for ; amount < 0 && (t.top > 0 || t.topSkip > 0); amount++ {
+		if t.topSkip > 0 {
+			t.topSkip--
+		} else {
+			t.top--
+			t.topSkip = t.rowHeight(t.top) - 1
+		}
+	}
+
+	// the first and last rows shown in full
+	first, last := -1, -1
+	lines := -t.topSkip
+	for i := t.top; i < len(rows); i++ {
+		rowStart := lines
+		lines += t.rowHeight(i)
+		if lines > t.v.Height {
+			break
+		}
+		if rowStart >= 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+
+	// with no row shown in full, there's one too tall to fit - go with
+	// whichever row is in the middle of the viewport
+	if first < 0 {
+		first = t.rowAt(t.v.Height / 2)
+		last = first
+	}
+
+	highlightIndex := t.inner.GetHighlightedRowIndex()
+	if highlightIndex < first {
+		t.inner = t.inner.WithHighlightedRow(first)
+	} else if highlightIndex > last {
+		t.inner = t.inner.WithHighlightedRow(last)
+	}
+}
+
+// atBottom reports whether the last row ends within the viewport
+func (t *Table) atBottom() bool {
+	rows := t.inner.GetVisibleRows()
+
+	lines := -t.topSkip
+	for i := t.top; i < len(rows); i++ {
+		lines += t.rowHeight(i)
+		if lines > t.v.Height {
+			return false
+		}
+	}
+
+	return true
+}
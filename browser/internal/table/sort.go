@@ -0,0 +1,117 @@
+package table
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/evertras/bubble-table/table"
+)
+
+// Rows are sorted here rather than by the inner table, since it has no way of
+// going back to the order the rows came in.  Only the rows the table has are
+// sorted, which for the browser is however many its query is limited to, so
+// the header says as much.
+
+// compareValues compares cell values as numbers if they both are, and as
+// strings otherwise, like the inner table does
+func compareValues(a, b any) int {
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+
+	an, aErr := strconv.ParseFloat(as, 64)
+	bn, bErr := strconv.ParseFloat(bs, 64)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(an, bn)
+	}
+
+	return cmp.Compare(as, bs)
+}
+
+// sortedRows returns the table's rows in the order they're to be shown
+func (t *Table) sortedRows() []table.Row {
+	if t.sortKey == "" {
+		return t.rows
+	}
+
+	rows := slices.Clone(t.rows)
+	slices.SortStableFunc(rows, func(a, b table.Row) int {
+		c := compareValues(a.Data[t.sortKey], b.Data[t.sortKey])
+		if t.sortDescending {
+			return -c
+		}
+		return c
+	})
+
+	return rows
+}
+
+// decoratedColumns returns the table's columns, with the one being sorted by
+// marked as such, along with how many rows the sort covers
+func (t *Table) decoratedColumns() []table.Column {
+	if t.sortKey == "" {
+		return t.columns
+	}
+
+	columns := make([]table.Column, len(t.columns))
+	for i, c := range t.columns {
+		if c.Key() != t.sortKey {
+			columns[i] = c
+			continue
+		}
+
+		arrow := "▲"
+		if t.sortDescending {
+			arrow = "▼"
+		}
+		title := fmt.Sprintf("%s %s of %d", c.Title(), arrow, len(t.rows))
+
+		if c.IsFlex() {
+			columns[i] = table.NewFlexColumn(c.Key(), title, c.FlexFactor())
+		} else {
+			columns[i] = table.NewColumn(c.Key(), title, c.Width())
+		}
+		columns[i] = columns[i].
+			WithStyle(c.Style()).
+			WithFiltered(c.Filterable()).
+			WithFormatString(c.FmtString())
+	}
+
+	return columns
+}
+
+// applySort hands the rows and columns over to the inner table, sorted and
+// decorated
+func (t *Table) applySort() {
+	t.inner = t.inner.WithColumns(t.decoratedColumns()).WithRows(t.sortedRows())
+	// the inner table copies its rows whenever it's asked for them, unless it
+	// has them cached - which copies of it share once it does
+	t.inner.GetVisibleRows()
+}
+
+// SortBy sorts the rows by the column with the given key, or leaves them in
+// the order they were given in if key is empty.  The first row is
+// highlighted afterwards.
+func (t *Table) SortBy(key string, descending bool) *Table {
+	nt := *t
+	nt.sortKey = key
+	nt.sortDescending = descending
+	nt.applySort()
+	nt.inner = nt.inner.WithHighlightedRow(0)
+	nt.top = 0
+	nt.topSkip = 0
+	return &nt
+}
+
+// cycleSort moves the sort for the column with the given key on from
+// unsorted, to ascending, to descending, and back to unsorted
+func (t *Table) cycleSort(key string) *Table {
+	switch {
+	case t.sortKey != key:
+		return t.SortBy(key, false)
+	case !t.sortDescending:
+		return t.SortBy(key, true)
+	default:
+		return t.SortBy("", false)
+	}
+}
@@ -1,8 +1,11 @@
 package table
 
 import (
+	"fmt"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
@@ -18,9 +21,47 @@ type (
 	RowData           = table.RowData
 )
 
+// Table shows rows in a scrolling viewport.  Only the rows that fall within
+// the viewport are rendered, so that large result sets with multi-line rows
+// stay fast.
 type Table struct {
-	inner table.Model
-	v     viewport.Model
+	inner   table.Model
+	columns []table.Column
+	rows    []table.Row
+	v       viewport.Model
+	height  int
+
+	sortKey        string
+	sortDescending bool
+
+	// the first row in the viewport, and how many of its lines are scrolled
+	// past
+	top     int
+	topSkip int
+
+	heights *rowHeights
+
+	// the last time a row was clicked, for spotting double clicks
+	lastClickRow int
+	lastClickAt  time.Time
+
+	// what clicks are timed by; nil for time.Now
+	clock func() time.Time
+}
+
+// rowHeights caches how many lines each row takes up, along with the cell
+// values the height was measured for, since the browser changes rows' data
+// in place
+type rowHeights struct {
+	keys    map[int]string
+	heights map[int]int
+}
+
+func newRowHeights() *rowHeights {
+	return &rowHeights{
+		keys:    make(map[int]string),
+		heights: make(map[int]int),
+	}
 }
 
 func (t *Table) Init() tea.Cmd {
@@ -32,118 +73,298 @@ func (t *Table) Init() tea.Cmd {
 }
 
 func (t *Table) Update(msg tea.Msg) (*Table, tea.Cmd) {
+	if mouseMsg, isMouseMsg := msg.(tea.MouseMsg); isMouseMsg {
+		return t.updateMouse(mouseMsg)
+	}
+
 	if _, isKeyMsg := msg.(tea.KeyMsg); !isKeyMsg {
 		// XXX do we update table first or viewport first?  Does it matter? Is there anyway of really knowing?
-		inner, tableCmd := t.inner.Update(msg)
-		v, viewportCmd := t.v.Update(msg)
+		var tableCmd, viewportCmd tea.Cmd
+
+		nt := *t
+		nt.inner, tableCmd = t.inner.Update(msg)
+		nt.v, viewportCmd = t.v.Update(msg)
 
-		return &Table{
-			inner: inner,
-			v:     v,
-		}, tea.Batch(tableCmd, viewportCmd)
+		return &nt, tea.Batch(tableCmd, viewportCmd)
 	}
 
 	// XXX pass messages down?
 	return t, nil
 }
 
+var leadingBlankLine = regexp.MustCompile(`^[ \t]*\n*`)
+
 func trimTableView(tableView string) string {
-	re := regexp.MustCompile(`^[ \t]*\n*`)
-	return re.ReplaceAllLiteralString(tableView, "")
+	return leadingBlankLine.ReplaceAllLiteralString(tableView, "")
+}
+
+// renderRows renders rows without the header, one string per line.  The
+// highlighted row is only shown as highlighted if it's among them.
+func (t *Table) renderRows(start, end int) []string {
+	inner := t.inner.WithHeaderVisibility(false).WithRows(t.inner.GetVisibleRows()[start:end])
+
+	highlightIndex := t.inner.GetHighlightedRowIndex()
+	if highlightIndex >= start && highlightIndex < end {
+		inner = inner.WithHighlightedRow(highlightIndex - start)
+	} else {
+		inner = inner.Focused(false)
+	}
+
+	lines := strings.Split(trimTableView(inner.View()), "\n")
+	// the last line is the (invisible) bottom border
+	return lines[:len(lines)-1]
+}
+
+// rowKey identifies what's shown in a row, for caching its height
+func (t *Table) rowKey(row table.Row) string {
+	var key strings.Builder
+	for _, column := range t.columns {
+		fmt.Fprint(&key, row.Data[column.Key()])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// rowHeight returns how many lines the row at index takes up
+func (t *Table) rowHeight(index int) int {
+	key := t.rowKey(t.inner.GetVisibleRows()[index])
+	if cachedKey, found := t.heights.keys[index]; found && cachedKey == key {
+		return t.heights.heights[index]
+	}
+
+	height := len(t.renderRows(index, index+1))
+	t.heights.keys[index] = key
+	t.heights.heights[index] = height
+
+	return height
+}
+
+func (t *Table) headerView() string {
+	return t.inner.WithRows([]table.Row{}).View()
+}
+
+var moreLinesStyle = lipgloss.NewStyle().Faint(true)
+
+// minHeightForMoreLines is how tall the viewport needs to be to give up lines
+// of a highlighted row too tall to fit to say how much of it is hidden
+const minHeightForMoreLines = 3
+
+func moreLines(n int, direction string) string {
+	noun := "lines"
+	if n == 1 {
+		noun = "line"
+	}
+	return moreLinesStyle.Render(fmt.Sprintf("       %s %d more %s", direction, n, noun))
 }
 
 func (t *Table) View() string {
-	headerView := t.inner.WithRows([]table.Row{}).View()
-	t.v.SetContent(trimTableView(t.inner.WithHeaderVisibility(false).View()))
-	return headerView + "\n" + t.v.View()
+	// the rows' data may have changed since the highlight last moved
+	t.scrollToHighlight()
+
+	// render just enough rows to fill the viewport
+	rows := t.inner.GetVisibleRows()
+	end := t.top
+	for lines := -t.topSkip; end < len(rows) && lines < t.v.Height; end++ {
+		lines += t.rowHeight(end)
+	}
+
+	content := ""
+	if end > t.top {
+		lines := t.renderRows(t.top, end)[t.topSkip:]
+
+		// say how much of a highlighted row too tall to fit is out of view
+		if highlightHeight := t.rowHeight(t.top); t.top == t.inner.GetHighlightedRowIndex() && highlightHeight > t.v.Height && t.v.Height >= minHeightForMoreLines {
+			lines = lines[:t.v.Height]
+			if t.topSkip > 0 {
+				lines[0] = moreLines(t.topSkip+1, "↑")
+			}
+			if below := highlightHeight - t.topSkip - t.v.Height; below > 0 {
+				lines[len(lines)-1] = moreLines(below+1, "↓")
+			}
+		}
+
+		content = strings.Join(lines, "\n")
+	}
+
+	t.v.SetContent(content)
+	return t.headerView() + "\n" + t.v.View()
 }
 
 func (t *Table) HeaderStyle(style lipgloss.Style) *Table {
-	return &Table{
-		inner: t.inner.HeaderStyle(style),
-		v:     t.v,
-	}
+	nt := *t
+	nt.inner = t.inner.HeaderStyle(style)
+	return &nt
 }
 
 // XXX once I get things compiling, fix the API
 func (t *Table) WithBaseStyle(style lipgloss.Style) *Table {
-	return &Table{
-		inner: t.inner.WithBaseStyle(style),
-		v:     t.v,
-	}
+	nt := *t
+	nt.inner = t.inner.WithBaseStyle(style)
+	return &nt
 }
 
 func (t *Table) WithRowStyleFunc(f func(in table.RowStyleFuncInput) lipgloss.Style) *Table {
-	return &Table{
-		inner: t.inner.WithRowStyleFunc(f),
-		v:     t.v,
-	}
+	nt := *t
+	nt.inner = t.inner.WithRowStyleFunc(f)
+	return &nt
+}
+
+// WithClock sets what clicks are timed by when spotting double clicks, so
+// that replayed clicks are as far apart as they originally were
+func (t *Table) WithClock(now func() time.Time) *Table {
+	nt := *t
+	nt.clock = now
+	return &nt
 }
 
 func (t *Table) WithColumns(columns []table.Column) *Table {
-	return &Table{
-		inner: t.inner.WithColumns(columns),
-		v:     t.v,
+	nt := *t
+	nt.columns = columns
+	// stop sorting by a column that's gone away
+	if !slices.ContainsFunc(columns, func(c table.Column) bool { return c.Key() == t.sortKey }) {
+		nt.sortKey = ""
+		nt.sortDescending = false
 	}
+	nt.applySort()
+	nt.heights = newRowHeights()
+	// the header may be a different height
+	nt.resize(t.v.Width, t.height)
+	return &nt
 }
 
 func (t *Table) WithRows(rows []table.Row) *Table {
-	return &Table{
-		inner: t.inner.WithRows(rows),
-		v:     t.v,
-	}
+	nt := *t
+	nt.rows = rows
+	nt.applySort()
+	nt.heights = newRowHeights()
+	nt.scrollToHighlight()
+	return &nt
 }
 
 func (t *Table) WithTargetWidth(width int) *Table {
-	return &Table{
-		inner: t.inner.WithTargetWidth(width),
-		v:     viewport.New(width, t.v.Height),
-	}
+	nt := *t
+	nt.inner = t.inner.WithTargetWidth(width)
+	// rows wrap differently at a different width
+	nt.heights = newRowHeights()
+	nt.resize(width, t.height)
+	return &nt
 }
 
+// WithTargetHeight sets how many lines the table takes up, header included
 func (t *Table) WithTargetHeight(height int) *Table {
-	return &Table{
-		inner: t.inner,
-		v:     viewport.New(t.v.Width, height-4), // XXX - 2 for the header and padding - can I avoid hard-coding this?
-	}
+	nt := *t
+	nt.resize(t.v.Width, height)
+	return &nt
+}
+
+// resize fits the viewport under the header (and a line of padding) in the
+// given space.  The header is always shown in full, so if there's not enough
+// room for it, the table ends up taller than height.
+func (t *Table) resize(width, height int) {
+	headerHeight := strings.Count(t.headerView(), "\n") + 1
+
+	t.height = height
+	t.v = viewport.New(width, max(height-headerHeight-1, 1))
+	t.scrollToHighlight()
 }
 
-func findHighlightedLines(t table.Model) (int, int) {
-	rows := t.GetVisibleRows()
-	if len(rows) == 0 {
-		return 0, 0
+// scrollToHighlight scrolls as little as possible to bring the highlighted
+// row into view - all of it, unless it's taller than the viewport, in which
+// case its start
+func (t *Table) scrollToHighlight() {
+	if len(t.inner.GetVisibleRows()) == 0 {
+		t.top = 0
+		t.topSkip = 0
+		return
+	}
+
+	highlightIndex := t.inner.GetHighlightedRowIndex()
+
+	// a row too tall to fit starts at the top of the viewport, but can be
+	// scrolled through with ScrollHighlightedRow
+	if highlightHeight := t.rowHeight(highlightIndex); highlightHeight > t.v.Height {
+		if t.top != highlightIndex {
+			t.top = highlightIndex
+			t.topSkip = 0
+		}
+		t.topSkip = min(t.topSkip, highlightHeight-t.v.Height)
+		return
 	}
 
-	highlightIndex := t.GetHighlightedRowIndex()
-	rowsBeforeHighlight := rows[:highlightIndex]
-	rowsWithHighlight := rows[:highlightIndex+1]
+	if highlightIndex < t.top || (highlightIndex == t.top && t.topSkip > 0) {
+		t.top = highlightIndex
+		t.topSkip = 0
+	} else {
+		// how far down the viewport the highlighted row ends
+		highlightEnd := -t.topSkip
+		for i := t.top; i <= highlightIndex; i++ {
+			highlightEnd += t.rowHeight(i)
+		}
 
-	beforeHighlightLines := strings.Split(trimTableView(t.WithHeaderVisibility(false).WithRows(rowsBeforeHighlight).View()), "\n")
-	withHighlightLines := strings.Split(trimTableView(t.WithHeaderVisibility(false).WithRows(rowsWithHighlight).View()), "\n")
+		if highlightEnd > t.v.Height {
+			t.alignBottom(highlightIndex)
+		}
+	}
 
-	return len(beforeHighlightLines) - 1, len(withHighlightLines) - 1
+	t.fillViewport()
 }
 
-func (t *Table) MoveHighlight(amount int) *Table {
-	inner := t.inner.WithHighlightedRow(t.inner.GetHighlightedRowIndex() + amount)
-	highlightedStart, highlightedEnd := findHighlightedLines(inner)
+// alignBottom scrolls so that the row at index ends at the bottom of the
+// viewport, working back from it to find the new top
+func (t *Table) alignBottom(index int) {
+	remaining := t.v.Height - t.rowHeight(index)
+	t.top = index
+	t.topSkip = 0
 
-	if highlightedStart != 0 || highlightedEnd != 0 {
-		if highlightedEnd > t.v.YOffset+t.v.Height {
-			t.v.ScrollDown(highlightedEnd - (t.v.YOffset + t.v.Height))
-		}
+	for remaining > 0 && t.top > 0 {
+		previousHeight := t.rowHeight(t.top - 1)
+		t.top--
 
-		if highlightedStart < t.v.YOffset {
-			t.v.ScrollUp(t.v.YOffset - highlightedStart)
+		if previousHeight > remaining {
+			t.topSkip = previousHeight - remaining
+			break
 		}
+		remaining -= previousHeight
+	}
+}
+
+// fillViewport scrolls up if the rows run out before the bottom of the
+// viewport while there are more above it, as happens when rows go away
+func (t *Table) fillViewport() {
+	rows := t.inner.GetVisibleRows()
+
+	filled := -t.topSkip
+	for i := t.top; i < len(rows) && filled < t.v.Height; i++ {
+		filled += t.rowHeight(i)
 	}
 
-	return &Table{
-		inner: inner,
-		v:     t.v,
+	if filled < t.v.Height && (t.top > 0 || t.topSkip > 0) {
+		t.alignBottom(len(rows) - 1)
 	}
 }
 
+func (t *Table) MoveHighlight(amount int) *Table {
+	nt := *t
+	nt.inner = t.inner.WithHighlightedRow(t.inner.GetHighlightedRowIndex() + amount)
+	nt.scrollToHighlight()
+	return &nt
+}
+
+// ScrollHighlightedRow scrolls through the highlighted row by amount lines,
+// if it's too tall to fit in the viewport
+func (t *Table) ScrollHighlightedRow(amount int) *Table {
+	nt := *t
+	nt.scrollToHighlight()
+
+	if rows := nt.inner.GetVisibleRows(); len(rows) > 0 && nt.top == nt.inner.GetHighlightedRowIndex() {
+		nt.topSkip = max(nt.topSkip+amount, 0)
+		// scrollToHighlight stops it going past the end of the row
+		nt.scrollToHighlight()
+	}
+
+	return &nt
+}
+
 func (t *Table) HighlightedRow() Row {
 	return t.inner.HighlightedRow()
 }
@@ -170,10 +391,14 @@ func New(columns []table.Column) *Table {
 		WithMultiline(true).
 		WithFooterVisibility(false) // don't show the paging widget
 
-	return &Table{
-		inner: t,
-		v:     viewport.New(80, 25),
+	tbl := &Table{
+		inner:   t,
+		columns: columns,
+		heights: newRowHeights(),
 	}
+	tbl.resize(80, 29)
+
+	return tbl
 }
 
 func NewColumn(key, title string, width int) Column {
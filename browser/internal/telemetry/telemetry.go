@@ -0,0 +1,180 @@
+// Package telemetry keeps timing and size metrics for the queries the browser
+// runs, in memory, for the debug overlay and the summary logged on exit.
+package telemetry
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// queries slower than this are logged as they happen
+const SlowQueryThreshold = 250 * time.Millisecond
+
+// only this many samples are kept for computing percentiles
+const maxSamples = 1000
+
+// how many of the slowest queries the summary lists
+const slowestCount = 5
+
+// Sample describes a single query
+type Sample struct {
+	SQL  string
+	Args string
+
+	At       time.Time
+	Duration time.Duration
+
+	// RowsScanned counts the history rows looked at to produce the results:
+	// none for a cache hit, and -1 if the store doesn't know (see
+	// store.RowsScanned)
+	RowsScanned int
+	// Results counts the rows the query produced, cached or not
+	Results int
+
+	CacheHit bool
+}
+
+type Collector struct {
+	mu sync.Mutex
+
+	samples []Sample
+	next    int
+
+	queries     int
+	cacheHits   int
+	rowsScanned int
+	results     int
+	slowest     []Sample
+	last        *Sample
+}
+
+func New() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Record(s Sample) {
+	if c == nil {
+		return
+	}
+
+	if s.Duration >= SlowQueryThreshold && !s.CacheHit {
+		slog.Warn("slow query", "query", s.SQL, "args", s.Args, "duration", s.Duration, "rows_scanned", s.RowsScanned)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queries++
+	c.rowsScanned += max(s.RowsScanned, 0)
+	c.results += s.Results
+	c.last = &s
+
+	if s.CacheHit {
+		c.cacheHits++
+		// cache hits would drag the percentiles down without saying anything
+		// about how long queries take
+		return
+	}
+
+	if len(c.samples) < maxSamples {
+		c.samples = append(c.samples, s)
+	} else {
+		c.samples[c.next] = s
+		c.next = (c.next + 1) % maxSamples
+	}
+
+	c.slowest = append(c.slowest, s)
+	slices.SortStableFunc(c.slowest, func(a, b Sample) int {
+		return int(b.Duration - a.Duration)
+	})
+	if len(c.slowest) > slowestCount {
+		c.slowest = c.slowest[:slowestCount]
+	}
+}
+
+// Last returns the most recently recorded sample
+func (c *Collector) Last() (Sample, bool) {
+	if c == nil {
+		return Sample{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last == nil {
+		return Sample{}, false
+	}
+	return *c.last, true
+}
+
+type Summary struct {
+	Queries   int
+	CacheHits int
+
+	// over the queries that actually ran, rather than hitting the cache
+	P50, P95, Max time.Duration
+
+	// over the queries whose store knows
+	RowsScanned int
+	Results     int
+
+	Slowest []Sample
+}
+
+func (c *Collector) Summary() Summary {
+	if c == nil {
+		return Summary{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	durations := make([]time.Duration, len(c.samples))
+	for i, s := range c.samples {
+		durations[i] = s.Duration
+	}
+	slices.Sort(durations)
+
+	s := Summary{
+		Queries:     c.queries,
+		CacheHits:   c.cacheHits,
+		P50:         percentile(durations, 50),
+		P95:         percentile(durations, 95),
+		RowsScanned: c.rowsScanned,
+		Results:     c.results,
+		Slowest:     slices.Clone(c.slowest),
+	}
+	if len(durations) > 0 {
+		s.Max = durations[len(durations)-1]
+	}
+
+	return s
+}
+
+// percentile uses the nearest-rank method on already-sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Log writes s to the log, including the slowest queries
+func (s Summary) Log() {
+	slog.Info("query telemetry",
+		"queries", s.Queries,
+		"cache_hits", s.CacheHits,
+		"p50", s.P50,
+		"p95", s.P95,
+		"max", s.Max,
+		"rows_scanned", s.RowsScanned,
+		"results", s.Results)
+
+	for i, sample := range s.Slowest {
+		slog.Info("slowest query", "rank", i+1, "query", sample.SQL, "args", sample.Args, "duration", sample.Duration, "rows_scanned", sample.RowsScanned)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+)
+
+// keyFlags are the flags shared by the subcommands that read or write
+// encrypted bundles
+type keyFlags struct {
+	encrypt        bool
+	passphraseFile string
+	keyFile        string
+}
+
+func (kf *keyFlags) register(flags *pflag.FlagSet, withEncrypt bool) {
+	if withEncrypt {
+		flags.BoolVar(&kf.encrypt, "encrypt", false, "Encrypt the output (implied by --key-file and --passphrase-file; uses $HISTDB_PASSPHRASE otherwise)")
+	}
+	flags.StringVar(&kf.passphraseFile, "passphrase-file", "", "A file containing the passphrase to encrypt or decrypt with")
+	flags.StringVar(&kf.keyFile, "key-file", "", "A key file generated by keygen to encrypt or decrypt with")
+}
+
+// key returns the key selected by the flags, or nil if encryption wasn't
+// asked for
+func (kf *keyFlags) key() (*bundle.Key, error) {
+	if kf.keyFile != "" && kf.passphraseFile != "" {
+		return nil, errors.New("--key-file and --passphrase-file are mutually exclusive")
+	}
+
+	if kf.keyFile != "" {
+		contents, err := os.ReadFile(kf.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return bundle.ParseKeyFile(contents)
+	}
+
+	if kf.passphraseFile != "" {
+		contents, err := os.ReadFile(kf.passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return passphraseKey(strings.TrimRight(string(contents), "\r\n"))
+	}
+
+	if kf.encrypt {
+		return passphraseKey(os.Getenv("HISTDB_PASSPHRASE"))
+	}
+
+	return nil, nil
+}
+
+// requiredKey is like key, but for subcommands that can't do anything without
+// one
+func (kf *keyFlags) requiredKey() (*bundle.Key, error) {
+	kf.encrypt = true
+	return kf.key()
+}
+
+func passphraseKey(passphrase string) (*bundle.Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("no passphrase given (use --passphrase-file, --key-file, or $HISTDB_PASSPHRASE)")
+	}
+	return bundle.PassphraseKey(passphrase), nil
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -19,30 +18,35 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/mattn/go-sqlite3"
 	"github.com/spf13/pflag"
 
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/noteworthy"
+	"hoelz.ro/histdb-browser/internal/output"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/redact"
+	"hoelz.ro/histdb-browser/internal/stats"
+	"hoelz.ro/histdb-browser/internal/store"
 	"hoelz.ro/histdb-browser/internal/table"
-
-	_ "embed"
+	"hoelz.ro/histdb-browser/internal/telemetry"
 )
 
-//go:embed histdb.lua
-var histDBSource string
-
-//go:embed lua-vtable.so
-var vtableExtension []byte
-
 var (
 	defaultStyle       = lipgloss.NewStyle().AlignHorizontal(lipgloss.Left)
 	headerStyle        = lipgloss.NewStyle().Bold(true)
 	highlightStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff87d7")).Bold(true)
 	failedCommandStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#ff0000")).Bold(true)
 	flashMessageStyle  = lipgloss.NewStyle().Bold(true)
+	markedStyle        = lipgloss.NewStyle().Underline(true)
+	debugStyle         = lipgloss.NewStyle().Faint(true)
 )
 
 const entryLengthLimit = 200
 
+// how many queries' results the browser keeps around
+const queryCacheSize = 64
+
 var displayHelpKey = key.NewBinding(
 	key.WithKeys("f1"),
 	key.WithHelp("f1", "Display help"),
@@ -73,11 +77,56 @@ var toggleLocalCommandsKey = key.NewBinding(
 	key.WithHelp("f6", "Toggle local/global commands"),
 )
 
+var showStatsKey = key.NewBinding(
+	key.WithKeys("f7"),
+	key.WithHelp("f7", "Show statistics for the current results"),
+)
+
+var markRowKey = key.NewBinding(
+	key.WithKeys("f8"),
+	key.WithHelp("f8", "Mark/unmark the highlighted entry"),
+)
+
+var deleteKey = key.NewBinding(
+	key.WithKeys("f9"),
+	key.WithHelp("f9", "Delete the marked entries (or the highlighted one)"),
+)
+
+var undoDeleteKey = key.NewBinding(
+	key.WithKeys("ctrl+z"),
+	key.WithHelp("ctrl+z", "Undo the last deletion"),
+)
+
+var annotateKey = key.NewBinding(
+	key.WithKeys("f10"),
+	key.WithHelp("f10", "Annotate the highlighted entry with a note and #tags"),
+)
+
+var pinKey = key.NewBinding(
+	key.WithKeys("f11"),
+	key.WithHelp("f11", "Pin/unpin the highlighted command"),
+)
+
+var showPinsKey = key.NewBinding(
+	key.WithKeys("ctrl+o"),
+	key.WithHelp("ctrl+o", "Switch between search results and pinned commands"),
+)
+
+var toggleDebugKey = key.NewBinding(
+	key.WithKeys("ctrl+g"),
+	key.WithHelp("ctrl+g", "Toggle the query debug overlay"),
+)
+
 var markSessionKey = key.NewBinding(
 	key.WithKeys("f12"),
 	key.WithHelp("f12", "Mark this browser session as noteworthy"),
 )
 
+var scrollEntryKey = key.NewBinding(
+	key.WithKeys("shift+down", "shift+up"),
+	key.WithHelp("shift+↓/↑", "Scroll through a highlighted entry too tall to show in full"),
+)
+
 type keyMap struct{}
 
 func (m keyMap) ShortHelp() []key.Binding {
@@ -88,7 +137,16 @@ func (m keyMap) ShortHelp() []key.Binding {
 		toggleSessionIDKey,
 		toggleFailedCommandsKey,
 		toggleLocalCommandsKey,
+		showStatsKey,
+		markRowKey,
+		deleteKey,
+		undoDeleteKey,
+		annotateKey,
+		pinKey,
+		showPinsKey,
+		toggleDebugKey,
 		markSessionKey,
+		scrollEntryKey,
 	}
 }
 
@@ -100,10 +158,23 @@ var columnWidths = map[string]int{
 	"timestamp":  20, // based on YYYY-MM-DD HH:MM:SS, with a little padding
 	"session_id": 36, // UUID length
 	"cwd":        70, // based on my history
+	"tags":       20,
+	"pinned_at":  20,
+	"note":       30,
+}
+
+// optionalColumns are only displayed if some row has a value for them
+var optionalColumns = map[string]bool{
+	"tags": true,
+	"note": true,
 }
 
 type model struct {
-	db       *sql.DB
+	store store.HistoryStore
+	// the browser's own tables (annotations, pins, and noteworthy markers) live
+	// here; it's nil if the store isn't backed by a histdb database
+	db *sql.DB
+
 	input    textinput.Model
 	table    *table.Table
 	showHelp bool
@@ -121,8 +192,66 @@ type model struct {
 
 	flashMessage string
 
+	width int
+
+	showStats bool
+	stats     *stats.Stats
+	statsErr  error
+
 	horizonTimestamp time.Time
 	sessionID        string
+	backend          query.Backend
+
+	redactor *redact.Redactor
+
+	// rowids of the marked rows
+	marked map[int64]bool
+
+	// the rows the user is being asked whether to delete
+	confirmingDeletion []int64
+	pendingDeletion    *pendingDeletion
+	nextDeletionID     int
+
+	annotating      bool
+	annotationInput textinput.Model
+	annotatingRowid int64
+
+	showPins  bool
+	floatPins bool
+
+	startedAt     time.Time
+	noteworthyIDs []int64
+
+	queryCache *query.Cache
+	telemetry  *telemetry.Collector
+	showDebug  bool
+
+	synchronousStats bool
+}
+
+type statsMsg struct {
+	stats *stats.Stats
+	err   error
+}
+
+// collectStats computes statistics in the background, since it needs to
+// look at every matching row
+func (m *model) collectStats() tea.Cmd {
+	historyStore := m.store
+	f := m.queryFilter()
+
+	if m.synchronousStats {
+		m.stats, m.statsErr = historyStore.Stats(f)
+		if m.statsErr != nil {
+			slog.Error("unable to compute statistics", "error", m.statsErr)
+		}
+		return nil
+	}
+
+	return func() tea.Msg {
+		s, err := historyStore.Stats(f)
+		return statsMsg{stats: s, err: err}
+	}
 }
 
 func (m *model) Init() tea.Cmd {
@@ -139,28 +268,53 @@ func stringTruncate(s string, limit int) string {
 	return s
 }
 
-func (m *model) getRowsFromQuery(sql string, args ...any) ([]table.Column, []table.Row, error) {
-	slog.Debug("running SQL", "query", sql, "args", fmt.Sprintf("%#v", args))
+// getRows gets rows for the table from run, which is described by sql and args
+// for the sake of caching and telemetry, and also returns how many rows it
+// scanned (-1 if that isn't known)
+func (m *model) getRows(sql string, args []any, run func() ([]string, []map[string]string, int, error)) ([]table.Column, []table.Row, error) {
 	startTime := time.Now()
-	rows, err := m.db.Query(sql, args...)
-	if err != nil {
-		return nil, nil, err
-	}
 
-	tableRows := make([]table.Row, 0)
-
-	columns, err := rows.Columns()
+	// a cache hit doesn't scan anything
+	rowsScanned := 0
+	columns, results, cacheHit, err := m.queryCache.Run(sql, args, func() ([]string, []map[string]string, error) {
+		columns, results, scanned, err := run()
+		rowsScanned = scanned
+		return columns, results, err
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
+	m.telemetry.Record(telemetry.Sample{
+		SQL:         sql,
+		Args:        fmt.Sprintf("%#v", args),
+		At:          startTime,
+		Duration:    time.Since(startTime),
+		RowsScanned: rowsScanned,
+		Results:     len(results),
+		CacheHit:    cacheHit,
+	})
+
 	tableColumns := make([]table.Column, 0, len(columns))
 
+	hasValues := make(map[string]bool)
+	for _, result := range results {
+		for columnName := range optionalColumns {
+			if result[columnName] != "" {
+				hasValues[columnName] = true
+			}
+		}
+	}
+
 	for _, columnName := range columns {
 		if columnName == "rowid" || columnName == "exit_status" {
 			continue
 		}
 
+		if optionalColumns[columnName] && !hasValues[columnName] {
+			continue
+		}
+
 		columnWidth := columnWidths[columnName]
 		if columnWidth == 0 {
 			columnWidth = 20
@@ -177,38 +331,87 @@ func (m *model) getRowsFromQuery(sql string, args ...any) ([]table.Column, []tab
 		tableColumns = append(tableColumns, tableColumn)
 	}
 
-	rowValues := make([]string, len(columns))
-	scanPointers := make([]any, len(columns))
-	for i := range rowValues {
-		scanPointers[i] = &rowValues[i]
-	}
+	tableRows := make([]table.Row, 0, len(results))
 
-	for rows.Next() {
-		err := rows.Scan(scanPointers...)
-		if err != nil {
-			return nil, nil, err
-		}
+	for _, result := range results {
+		rowData := make(map[string]any, len(result)+1)
 
-		rowData := make(map[string]any)
-
-		for i, columnName := range columns {
-			rowData[columnName] = rowValues[i]
+		for columnName, value := range result {
+			rowData[columnName] = value
 		}
 
-		rowData["raw_entry"] = rowData["entry"]
-		if entry, isString := rowData["entry"].(string); isString {
-			rowData["entry"] = stringTruncate(entry, entryLengthLimit)
-		}
+		// raw_entry is what gets output on selection, while redacted_entry is
+		// what gets displayed
+		rowData["raw_entry"] = result["entry"]
+		rowData["redacted_entry"] = m.redactor.Redact(result["entry"])
+		rowData["entry"] = stringTruncate(rowData["redacted_entry"].(string), entryLengthLimit)
 		tableRows = append(tableRows, table.NewRow(rowData))
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, nil, err
+	return tableColumns, tableRows, nil
+}
+
+// queryFilter describes the search the model's current state calls for
+func (m *model) queryFilter() query.Filter {
+	columns := make([]string, 0)
+
+	if m.showTimestamp {
+		columns = append(columns, "timestamp")
+	}
+	if m.showSessionID {
+		columns = append(columns, "session_id")
+	}
+	if m.showWorkingDirectory {
+		columns = append(columns, "cwd")
+	}
+	columns = append(columns, "tags", "note")
+
+	var excludeRowids []int64
+	if m.pendingDeletion != nil {
+		excludeRowids = m.pendingDeletion.rowids
+	}
+
+	return query.Filter{
+		Query:              m.input.Value(),
+		Columns:            columns,
+		ShowFailedCommands: m.showFailedCommands,
+		ShowGlobalCommands: m.showGlobalCommands,
+		HorizonTimestamp:   m.horizonTimestamp,
+		SessionID:          m.sessionID,
+		ExcludeRowids:      excludeRowids,
+		FloatPins:          m.floatPins,
+		Backend:            m.backend,
+	}
+}
+
+// getFullRow fetches every field of the history row identified by rowid, which
+// the browser's own query only selects a subset of
+func getFullRow(db *sql.DB, backend query.Backend, rowid any) (map[string]string, error) {
+	selectClauseColumns := make([]string, len(output.Fields))
+	for i, field := range output.Fields {
+		selectClauseColumns[i] = fmt.Sprintf("COALESCE(%s, '') AS %s", field, field)
+	}
+
+	rowValues := make([]string, len(output.Fields))
+	scanPointers := make([]any, len(output.Fields))
+	for i := range rowValues {
+		scanPointers[i] = &rowValues[i]
 	}
 
-	slog.Debug("# rows", "row_count", len(tableRows), "duration", time.Since(startTime))
+	fromClause, params := backend.From()
+	params = append(params, rowid)
 
-	return tableColumns, tableRows, err
+	err := db.QueryRow(fmt.Sprintf("SELECT %s FROM %s WHERE rowid = ?", strings.Join(selectClauseColumns, ", "), fromClause), params...).Scan(scanPointers...)
+	if err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]string, len(output.Fields))
+	for i, field := range output.Fields {
+		row[field] = rowValues[i]
+	}
+
+	return row, nil
 }
 
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -233,12 +436,85 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
+		slog.Debug("window resized", "width", msg.Width, "height", msg.Height)
 		newModel.table = newModel.table.WithTargetWidth(msg.Width).WithTargetHeight(min(msg.Height-2, 20))
 		newModel.help.Width = msg.Width
+		newModel.width = msg.Width
 		columnsChanged = true
+	case statsMsg:
+		newModel.stats = msg.stats
+		newModel.statsErr = msg.err
+		if msg.err != nil {
+			slog.Error("unable to compute statistics", "error", msg.err)
+		}
+	case deletionTimeoutMsg:
+		slog.Debug("undo window passed", "deletion_id", msg.id)
+		if newModel.pendingDeletion != nil && newModel.pendingDeletion.id == msg.id {
+			if err := newModel.commitPendingDeletion(); err != nil {
+				slog.Error("unable to delete entries", "error", err)
+				newModel.flashMessage = fmt.Sprintf("Unable to delete entries: %v", err)
+				columnsChanged = true
+			}
+		}
+	case tea.MouseMsg:
+		// logged before anything else so that replay sees every mouse event
+		// (the button and action are logged as numbers, since their names
+		// can't always be told apart)
+		slog.Debug("got mouse event", "mouse", msg.String(), "x", msg.X, "y", msg.Y, "button", int(msg.Button), "action", int(msg.Action), "alt", msg.Alt, "ctrl", msg.Ctrl, "shift", msg.Shift)
+
+		// the table only takes mouse input while it's what's being interacted
+		// with
+		if m.showHelp || m.showStats || m.annotating || m.confirmingDeletion != nil {
+			return &newModel, nil
+		}
+
+		// the table sits below the input, and wants positions relative to
+		// itself (the browser runs in the alternate screen, so its own
+		// positions are the screen's)
+		msg.Y -= lipgloss.Height(newModel.input.View())
+		newModel.table, tableCmd = newModel.table.Update(msg)
+		newModel.updateVisibleRows()
+		return &newModel, tableCmd
+	case table.SelectedMsg:
+		return &newModel, newModel.selectRow(msg.Row)
 	case tea.KeyMsg:
+		// logged before anything else so that replay sees every keypress
+		slog.Debug("got keypress", "key", msg.String())
+
+		if m.annotating {
+			cmd := newModel.updateAnnotating(msg)
+			newModel.updateVisibleRows()
+			return &newModel, cmd
+		}
+
+		if m.confirmingDeletion != nil {
+			var cmd tea.Cmd
+
+			newModel.confirmingDeletion = nil
+			switch msg.String() {
+			case "ctrl+c":
+				return &newModel, tea.Quit
+			case "y", "Y":
+				cmd = newModel.startDeletion(m.confirmingDeletion)
+				newModel.refreshRows()
+			default:
+				newModel.flashMessage = "Deletion cancelled"
+			}
+			newModel.updateVisibleRows()
+			return &newModel, cmd
+		}
+
+		if m.showStats {
+			switch {
+			case msg.String() == "ctrl+c":
+				return &newModel, tea.Quit
+			case msg.String() == "esc", key.Matches(msg, showStatsKey):
+				newModel.showStats = false
+			}
+			return &newModel, nil
+		}
+
 		if !m.showHelp {
-			slog.Debug("got keypress", "key", msg.String())
 			switch msg.String() {
 			case "ctrl+c", "esc":
 				return &newModel, tea.Quit
@@ -246,26 +522,12 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				newModel.table = newModel.table.MoveHighlight(1)
 			case "ctrl+k", "up":
 				newModel.table = newModel.table.MoveHighlight(-1)
+			case "shift+down":
+				newModel.table = newModel.table.ScrollHighlightedRow(1)
+			case "shift+up":
+				newModel.table = newModel.table.ScrollHighlightedRow(-1)
 			case "enter":
-				selectedRow := newModel.table.HighlightedRow().Data
-				if selectedRow != nil {
-					rowAttrs := make([]slog.Attr, 0, len(selectedRow))
-					for k, v := range selectedRow {
-						if k == "entry" {
-							continue
-						} else if k == "raw_entry" {
-							k = "entry"
-						}
-
-						rowAttrs = append(rowAttrs, slog.Attr{Key: k, Value: slog.AnyValue(v)})
-					}
-					slog.LogAttrs(context.TODO(), slog.LevelInfo, "selected row", rowAttrs...)
-					newModel.selection = selectedRow
-				} else {
-					slog.Info("no row selected")
-					newModel.selection = nil
-				}
-				return &newModel, tea.Quit
+				return &newModel, newModel.selectRow(newModel.table.HighlightedRow())
 			}
 
 			stateChangeMessageLevel := slog.LevelInfo
@@ -320,10 +582,47 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					}
 					columnsChanged = true
 				}
+			case key.Matches(msg, showStatsKey):
+				newModel.showStats = true
+				newModel.stats = nil
+				newModel.statsErr = nil
+				return &newModel, newModel.collectStats()
+			case m.showPins && (key.Matches(msg, markRowKey) || key.Matches(msg, deleteKey) || key.Matches(msg, annotateKey)):
+				stateChangeMessage = "Not available for pinned commands"
+			case key.Matches(msg, pinKey):
+				newModel.togglePin()
+				columnsChanged = newModel.showPins || newModel.floatPins
+			case key.Matches(msg, showPinsKey):
+				newModel.togglePinsView()
+				columnsChanged = true
+			case key.Matches(msg, markRowKey):
+				newModel.toggleMark()
+			case key.Matches(msg, deleteKey) && store.IsReadOnly(newModel.store):
+				stateChangeMessage = "This history source is read-only"
+			case key.Matches(msg, deleteKey):
+				newModel.confirmingDeletion = newModel.deletionTargets()
+				if newModel.confirmingDeletion == nil && len(newModel.marked) > 0 {
+					stateChangeMessage = "None of the marked entries are shown"
+				} else if newModel.confirmingDeletion == nil {
+					stateChangeMessage = "Nothing to delete"
+				}
+			case key.Matches(msg, undoDeleteKey):
+				newModel.undoDeletion()
+				columnsChanged = true
+			case key.Matches(msg, annotateKey):
+				cmd := newModel.startAnnotating()
+				return &newModel, cmd
+			case key.Matches(msg, toggleDebugKey):
+				newModel.showDebug = !newModel.showDebug
 			case key.Matches(msg, markSessionKey):
 				slog.Log(context.TODO(), slog.LevelInfo, "this session is noteworthy")
-				stateChangeMessage = "Session marked as noteworthy"
-				stateChangeMessageLevel = slog.LevelInfo
+				if id, err := newModel.markNoteworthy(); err != nil {
+					stateChangeMessage = fmt.Sprintf("Unable to record noteworthy marker: %v", err)
+					stateChangeMessageLevel = slog.LevelError
+				} else {
+					stateChangeMessage = fmt.Sprintf("Session marked as noteworthy (see histdb-browser noteworthy show %d)", id)
+					stateChangeMessageLevel = slog.LevelInfo
+				}
 			}
 
 			if stateChangeMessage != "" {
@@ -342,89 +641,282 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		newModel.input, inputCmd = newModel.input.Update(msg)
 	}
 
-	if query := newModel.input.Value(); query != previousQuery || columnsChanged {
-		selectClauseColumns := make([]string, 0)
-
-		if newModel.showTimestamp {
-			selectClauseColumns = append(selectClauseColumns, "timestamp")
-		}
-		if newModel.showSessionID {
-			selectClauseColumns = append(selectClauseColumns, "session_id")
-		}
-		if newModel.showWorkingDirectory {
-			selectClauseColumns = append(selectClauseColumns, "cwd")
-		}
-
-		selectClauseColumns = append(selectClauseColumns, "entry")
-
-		selectClause := strings.Join(selectClauseColumns, ", ")
+	if newModel.input.Value() != previousQuery || columnsChanged {
+		newModel.refreshRows()
+	}
 
-		whereClausePredicates := []string{
-			"timestamp IS NOT NULL",
-		}
-		queryParams := make([]any, 0)
+	newModel.updateVisibleRows()
 
-		if query != "" {
-			whereClausePredicates = append(whereClausePredicates, "entry MATCH ?")
-			queryParams = append(queryParams, query)
-		}
+	// XXX is the batching order here correct?
+	return &newModel, tea.Batch(tableCmd, inputCmd)
+}
 
-		if !newModel.showFailedCommands {
-			whereClausePredicates = append(whereClausePredicates, "exit_status IN (0, 148)")
-		}
+// selectRow picks row as the one to output, and quits
+func (m *model) selectRow(row table.Row) tea.Cmd {
+	selectedRow := row.Data
+	if selectedRow != nil {
+		rowAttrs := make([]slog.Attr, 0, len(selectedRow))
+		for k, v := range selectedRow {
+			// keep secrets out of the log
+			if k == "entry" || k == "raw_entry" {
+				continue
+			} else if k == "redacted_entry" {
+				k = "entry"
+			}
 
-		if !newModel.showGlobalCommands && newModel.horizonTimestamp.Unix() != 0 {
-			whereClausePredicates = append(whereClausePredicates, "(raw_timestamp <= ? OR session_id = ?)")
-			queryParams = append(queryParams, newModel.horizonTimestamp.Unix())
-			queryParams = append(queryParams, newModel.sessionID)
+			rowAttrs = append(rowAttrs, slog.Attr{Key: k, Value: slog.AnyValue(v)})
 		}
+		slog.LogAttrs(context.TODO(), slog.LevelInfo, "selected row", rowAttrs...)
+		m.selection = selectedRow
+	} else {
+		slog.Info("no row selected")
+		m.selection = nil
+	}
+	return tea.Quit
+}
 
-		whereClause := strings.Join(whereClausePredicates, " AND ")
-
-		columns, rows, err := newModel.getRowsFromQuery(fmt.Sprintf("SELECT rowid, %s, COALESCE(exit_status, '') AS exit_status FROM h WHERE %s ORDER BY timestamp DESC LIMIT 100", selectClause, whereClause), queryParams...)
-		if err != nil {
-			panic(err)
+// refreshRows re-runs the query for the model's current state
+func (m *model) refreshRows() {
+	var columns []table.Column
+	var rows []table.Row
+	var err error
+
+	if m.showPins {
+		sql, params := pins.SQL(m.input.Value())
+		columns, rows, err = m.getRows(sql, params, func() ([]string, []map[string]string, int, error) {
+			if exists, err := pins.Exists(m.db); err != nil || !exists {
+				// nothing's been pinned yet
+				return pins.Columns, []map[string]string{}, 0, err
+			}
+			// pins aren't history, so there's no counting what's scanned
+			columns, results, err := query.Run(m.db, sql, params...)
+			return columns, results, -1, err
+		})
+	} else {
+		f := m.queryFilter()
+		if m.db != nil {
+			// so that the SQL below is what's actually run; the store sees
+			// that it's been adjusted already and doesn't check again
+			f, err = f.ForDatabase(m.db)
+			if err != nil {
+				panic(err)
+			}
 		}
-
-		newModel.table = newModel.table.WithColumns(columns)
-		newModel.table = newModel.table.WithRows(rows)
+		// this is what a SQLite store runs, which describes the search well
+		// enough for any other store
+		sql, params := f.SQL()
+		columns, rows, err = m.getRows(sql, params, func() ([]string, []map[string]string, int, error) {
+			columns, results, err := m.store.Search(f)
+			return columns, results, store.RowsScanned(m.store), err
+		})
+	}
+	if err != nil {
+		panic(err)
 	}
 
-	highlightedIndex := newModel.table.GetHighlightedRowIndex()
-	rows := newModel.table.GetVisibleRows()
+	m.table = m.table.WithColumns(columns)
+	m.table = m.table.WithRows(rows)
+}
+
+// updateVisibleRows shows the highlighted row's entry in full and tracks
+// which rows are marked
+func (m *model) updateVisibleRows() {
+	highlightedIndex := m.table.GetHighlightedRowIndex()
+	rows := m.table.GetVisibleRows()
 	for idx, row := range rows {
 		if idx == highlightedIndex {
-			row.Data["entry"] = row.Data["raw_entry"]
+			row.Data["entry"] = row.Data["redacted_entry"]
 		} else {
 			if entry, isString := row.Data["entry"].(string); isString {
 				row.Data["entry"] = stringTruncate(entry, entryLengthLimit)
 			}
 		}
+
+		id, _ := rowid(row.Data)
+		row.Data["marked"] = m.marked[id]
 	}
+}
 
-	// XXX is the batching order here correct?
-	return &newModel, tea.Batch(tableCmd, inputCmd)
+// debugView describes the last query and how queries have been performing
+func (m *model) debugView() string {
+	last, found := m.telemetry.Last()
+	if !found {
+		return debugStyle.Render("no queries yet")
+	}
+
+	cache := ""
+	if last.CacheHit {
+		cache = " (cached)"
+	}
+
+	summary := m.telemetry.Summary()
+
+	return debugStyle.Render(strings.Join([]string{
+		"SQL:  " + last.SQL,
+		"args: " + last.Args,
+		fmt.Sprintf("last: %s%s, %d rows (%s)", last.Duration, cache, last.Results, describeRowsScanned(last.RowsScanned)),
+		fmt.Sprintf("all:  %d queries (%d cached), p50 %s, p95 %s, max %s, %d rows scanned", summary.Queries, summary.CacheHits, summary.P50, summary.P95, summary.Max, summary.RowsScanned),
+	}, "\n"))
+}
+
+func describeRowsScanned(n int) string {
+	if n < 0 {
+		return "rows scanned unknown"
+	}
+	return fmt.Sprintf("%d scanned", n)
 }
 
 func (m *model) View() string {
 	if m.showHelp {
 		return m.help.View(m.keyMap)
+	} else if m.showStats {
+		switch {
+		case m.statsErr != nil:
+			return fmt.Sprintf("Unable to compute statistics: %v", m.statsErr)
+		case m.stats == nil:
+			return "Computing statistics…"
+		default:
+			return m.stats.Render(m.width)
+		}
 	} else {
-		return strings.Join([]string{
+		message := m.flashMessage
+		if m.confirmingDeletion != nil {
+			n := len(m.confirmingDeletion)
+			message = fmt.Sprintf("Delete %d %s from history? [y/N]", n, pluralize(n, "entry", "entries"))
+			if hidden := len(m.marked) - n; len(m.marked) > 0 && hidden > 0 {
+				message = fmt.Sprintf("Delete %d %s from history (not counting %d marked %s out of view)? [y/N]", n, pluralize(n, "entry", "entries"), hidden, pluralize(hidden, "entry", "entries"))
+			}
+		}
+
+		bottomLine := flashMessageStyle.Render(message)
+		if m.annotating {
+			bottomLine = m.annotationInput.View()
+		}
+
+		lines := []string{
 			m.input.View(),
 			m.table.View(),
-			flashMessageStyle.Render(m.flashMessage),
-		}, "\n")
+			bottomLine,
+		}
+
+		if m.showDebug {
+			lines = append(lines, m.debugView())
+		}
+
+		return strings.Join(lines, "\n")
+	}
+}
+
+// modelOptions are the settings a browser session starts with
+type modelOptions struct {
+	initialQuery       string
+	initialQueryCursor int // -1 for the end of the query
+	horizonTimestamp   time.Time
+	sessionID          string
+	redactor           *redact.Redactor
+	floatPins          bool
+	backend            query.Backend
+	// compute statistics within Update rather than in a command, for replays,
+	// which drop the commands Update returns
+	synchronousStats bool
+	// what double clicks are timed by; nil for time.Now
+	clock func() time.Time
+}
+
+// logStart logs how the browser was started, which is where replays start
+// from
+func logStart(opts modelOptions, backendName string, rf redactFlags, sourcePath string) {
+	slog.Info("starting browser",
+		"query", opts.initialQuery,
+		"query_cursor", opts.initialQueryCursor,
+		"session_id", opts.sessionID,
+		"horizon_timestamp", opts.horizonTimestamp.Unix(),
+		"float_pins", opts.floatPins,
+		"backend", backendName,
+		"redact", !rf.disabled,
+		"redact_rules", rf.rulesPath,
+		"source", sourcePath)
+}
+
+func newModel(historyStore store.HistoryStore, db *sql.DB, opts modelOptions) *model {
+	input := textinput.New()
+	input.SetValue(opts.initialQuery)
+	if opts.initialQueryCursor >= 0 {
+		input.SetCursor(opts.initialQueryCursor)
+	}
+
+	t := table.New(nil).
+		// styling
+		HeaderStyle(headerStyle).
+		WithBaseStyle(defaultStyle).
+		WithRowStyleFunc(func(in table.RowStyleFuncInput) lipgloss.Style {
+			style := defaultStyle
+			if in.IsHighlighted {
+				style = highlightStyle
+			} else {
+				exitStatus := in.Row.Data["exit_status"].(string)
+				if exitStatus != "" && exitStatus != "0" && exitStatus != "148" {
+					style = failedCommandStyle
+				}
+			}
+			if marked, _ := in.Row.Data["marked"].(bool); marked {
+				style = style.Inherit(markedStyle)
+			}
+			return style
+		})
+	if opts.clock != nil {
+		t = t.WithClock(opts.clock)
 	}
+
+	m := &model{
+		store: historyStore,
+		db:    db,
+		input: input,
+		table: t,
+		help:  help.New(),
+
+		showTimestamp:      true,
+		showFailedCommands: true,
+		showGlobalCommands: true,
+
+		horizonTimestamp: opts.horizonTimestamp,
+		sessionID:        opts.sessionID,
+
+		redactor:  opts.redactor,
+		floatPins: opts.floatPins,
+		backend:   opts.backend,
+
+		synchronousStats: opts.synchronousStats,
+
+		startedAt: time.Now(),
+
+		queryCache: query.NewCache(queryCacheSize),
+		telemetry:  telemetry.New(),
+	}
+	m.help.ShowAll = true
+
+	return m
 }
 
 func main() {
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
 	horizonTimestamp := uint64(0)
 	sessionID := ""
 	logFilename := ""
 	logLevel := "info"
 	logFormat := "text"
 	printOID := false
+	outputFormatName := string(output.FormatRaw)
+	qf := queryFlags{}
+	databasePath := defaultDatabasePath()
+	rf := redactFlags{}
+	bf := backendFlags{}
+	floatPins := false
+	sourcePath := ""
+	sourceFormat := ""
 
 	pflag.Uint64Var(&horizonTimestamp, "horizon-timestamp", 0, "The maximum timestamp to consider for results outside of this session")
 	pflag.StringVar(&sessionID, "session-id", strconv.Itoa(os.Getppid()), "The current session ID")
@@ -432,8 +924,43 @@ func main() {
 	pflag.StringVar(&logLevel, "log-level", "info", "The log level to log at")
 	pflag.StringVar(&logFormat, "log-format", logFormat, "The log format to log in (text, json)")
 	pflag.BoolVar(&printOID, "print-oid", printOID, "Output the OID of the selected row, rather than the entry")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n       %s %s [args]\n", os.Args[0], os.Args[0], strings.Join(subcommandNames(), "|"))
+		pflag.PrintDefaults()
+	}
+	pflag.StringVar(&outputFormatName, "output", outputFormatName, "The format to output the selection in (raw, nul, shell, json, tsv)")
+	qf.register(pflag.CommandLine)
+	pflag.StringVar(&databasePath, "database", databasePath, "The history database to browse (defaults to $HISTDB_PATH)")
+	pflag.BoolVar(&floatPins, "float-pins", floatPins, "Show pinned commands before other matching results")
+	rf.register(pflag.CommandLine, true)
+	bf.register(pflag.CommandLine)
+	pflag.StringVar(&sourcePath, "source", sourcePath, "Browse this shell history file (read-only) instead of the history database")
+	pflag.StringVar(&sourceFormat, "source-format", sourceFormat, "The format of --source ("+strings.Join(histfile.Formats, ", ")+"); guessed from its name if omitted")
 	pflag.Parse()
 
+	outputFormat, err := output.ParseFormat(outputFormatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if printOID && outputFormat.IncludesAllFields() {
+		fmt.Fprintf(os.Stderr, "--print-oid can't be used with --output %s, which already includes the rowid\n", outputFormatName)
+		os.Exit(2)
+	}
+
+	redactor, err := rf.redactor()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	backend, err := bf.backend()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	var buildLogHandler func(io.Writer, *slog.HandlerOptions) slog.Handler = func(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
 		return slog.NewTextHandler(w, opts)
 	}
@@ -491,118 +1018,119 @@ func main() {
 
 	slog.SetDefault(slog.Default().With("browser_pid", os.Getpid()))
 
-	wd, err := os.Getwd()
-	if err != nil {
-		slog.Error("unable to get working directory", "error", err)
-	} else {
-		slog.Debug("current working directory", "directory", wd)
-	}
+	// mouse events come with positions on the screen, which only line up with
+	// the browser's if it starts in the top left corner - so it gets the
+	// whole screen to itself, rather than being drawn under the prompt
+	programOptions := []tea.ProgramOption{tea.WithOutput(os.Stderr), tea.WithMouseCellMotion(), tea.WithAltScreen()}
 
-	exe, err := os.Executable()
+	readStdin, err := qf.readStdin(os.Stdin)
 	if err != nil {
 		panic(err)
 	}
+	if readStdin {
+		// we've used up standard input, so read keypresses from the terminal directly
+		programOptions = append(programOptions, tea.WithInputTTY())
+	}
 
-	s, err := os.Stat(exe)
+	wd, err := os.Getwd()
 	if err != nil {
-		panic(err)
+		slog.Error("unable to get working directory", "error", err)
+	} else {
+		slog.Debug("current working directory", "directory", wd)
 	}
 
-	ourModTime := s.ModTime()
+	var historyStore store.HistoryStore
+	var db *sql.DB
+	var source *store.HistoryFile
 
-	var extensionModTime time.Time
-
-	s, err = os.Stat("/home/rob/.cache/lua-vtable.so")
-
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		panic(err)
-	} else if err == nil {
-		extensionModTime = s.ModTime()
-	}
-
-	if extensionModTime.Before(ourModTime) {
-		// we need to delete any existing version first, because it could be mapped into the address
-		// space for any currently running version, and writing over the existing file's contents will
-		// mess with that and likely cause a segfault 😬
-		err := os.Remove("/home/rob/.cache/lua-vtable.so")
-		if err != nil && !errors.Is(err, os.ErrNotExist) {
-			panic(err)
+	if sourcePath != "" {
+		source, err = store.LoadHistoryFile(sourcePath, sourceFormat)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-
-		err = os.WriteFile("/home/rob/.cache/lua-vtable.so", vtableExtension, 0o700)
+		historyStore = source
+	} else {
+		db, backend, err = openDatabase(databasePath, backend)
 		if err != nil {
 			panic(err)
 		}
-	}
-
-	sql.Register("sqlite3-histdb-extensions", &sqlite3.SQLiteDriver{
-		Extensions: []string{
-			"/home/rob/.cache/lua-vtable.so",
-		},
-	})
-
-	db, err := sql.Open("sqlite3-histdb-extensions", "/home/rob/.zsh_history.db")
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	_, err = db.Exec("SELECT lua_create_module_from_source(?)", histDBSource)
-	if err != nil {
-		panic(err)
+		defer db.Close()
+		historyStore = store.NewSQLite(db, backend)
 	}
 
 	lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(os.Stderr))
 
-	input := textinput.New()
-
-	t := table.New(nil).
-		// styling
-		HeaderStyle(headerStyle).
-		WithBaseStyle(defaultStyle).
-		WithRowStyleFunc(func(in table.RowStyleFuncInput) lipgloss.Style {
-			if in.IsHighlighted {
-				return highlightStyle
-			}
-			exitStatus := in.Row.Data["exit_status"].(string)
-			if exitStatus != "" && exitStatus != "0" && exitStatus != "148" {
-				return failedCommandStyle
-			}
-			return defaultStyle
-		})
-
-	m := &model{
-		db:    db,
-		input: input,
-		table: t,
-		help:  help.New(),
-
-		showTimestamp:      true,
-		showFailedCommands: true,
-		showGlobalCommands: true,
-
-		horizonTimestamp: time.Unix(int64(horizonTimestamp), 0),
-		sessionID:        sessionID,
+	opts := modelOptions{
+		initialQuery:       qf.query,
+		initialQueryCursor: qf.cursor,
+		horizonTimestamp:   time.Unix(int64(horizonTimestamp), 0),
+		sessionID:          sessionID,
+		redactor:           redactor,
+		floatPins:          floatPins,
+		backend:            backend,
 	}
-	m.help.ShowAll = true
+	logStart(opts, bf.name, rf, sourcePath)
+
+	m := newModel(historyStore, db, opts)
 
 	// there's currently a bug of sorts in bubbletea (at least with urxvt) where calling Update before
 	// help.View() hangs the terminal until more keys are typed due to some color detection or
 	// something - just calling help.View() here works around that bug
 	m.help.View(m.keyMap)
 
-	resModel, err := tea.NewProgram(m, tea.WithOutput(os.Stderr)).Run()
+	resModel, err := tea.NewProgram(m, programOptions...).Run()
 	if err != nil {
 		panic(err)
 	}
 
 	if resModel != nil {
 		m = resModel.(*model)
+
+		// the user can't undo a deletion once they've left the browser
+		if err := m.commitPendingDeletion(); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to delete entries: %v\n", err)
+		}
+
+		m.telemetry.Summary().Log()
+
+		if len(m.noteworthyIDs) > 0 && m.selection != nil {
+			if err := noteworthy.SetSelection(db, m.noteworthyIDs, noteworthyRow(m.selection)); err != nil {
+				slog.Error("unable to record selection for noteworthy markers", "error", err)
+			}
+		}
+
 		if m.selection != nil {
-			if printOID {
-				fmt.Println(m.selection["rowid"])
+			if outputFormat.IncludesAllFields() {
+				var row map[string]string
+
+				if m.selection["rowid"] == "" {
+					// a pinned command whose history row is gone
+					row = map[string]string{"entry": fmt.Sprint(m.selection["raw_entry"])}
+				} else if source != nil {
+					id, _ := rowid(m.selection)
+					row, _ = source.Get(id, output.Fields)
+				} else {
+					row, err = getFullRow(db, backend, m.selection["rowid"])
+					if err != nil {
+						panic(err)
+					}
+				}
+
+				err = output.WriteRow(os.Stdout, outputFormat, output.Fields, row)
+				if err != nil {
+					panic(err)
+				}
 			} else {
-				fmt.Println(m.selection["raw_entry"])
+				value := m.selection["raw_entry"]
+				if printOID {
+					value = m.selection["rowid"]
+				}
+
+				err := output.WriteValue(os.Stdout, outputFormat, fmt.Sprint(value))
+				if err != nil {
+					panic(err)
+				}
 			}
 		}
 	}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/merge"
+)
+
+func runMerge(args []string) error {
+	databasePath := defaultDatabasePath()
+
+	flags := pflag.NewFlagSet("merge", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser merge [flags] OTHER-DATABASE...")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to merge into (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("expected at least one database to merge")
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, otherPath := range flags.Args() {
+		other, err := history.OpenReadOnly(otherPath)
+		if err != nil {
+			return err
+		}
+
+		result, err := merge.Databases(db, other)
+		other.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", otherPath, err)
+		}
+
+		fmt.Printf("%s: %d commands read, %d merged\n", otherPath, result.Read, result.Inserted)
+	}
+
+	return nil
+}
+
+func runSync(args []string) error {
+	databasePath := defaultDatabasePath()
+	kf := keyFlags{}
+
+	// failing to determine this is fatal here, since it's how we tell our
+	// commands apart from everyone else's
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	flags := pflag.NewFlagSet("sync", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser sync [flags] DIRECTORY")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exchanges history with other hosts through DIRECTORY, which should be shared")
+		fmt.Fprintln(os.Stderr, "between them by some other means (e.g. Syncthing).  Deleting commands isn't synced.")
+		fmt.Fprintln(os.Stderr, "With encryption, every host needs the same key or passphrase to read the others' changes.")
+		flags.PrintDefaults()
+	}
+	kf.register(flags, true)
+	flags.StringVar(&hostname, "hostname", hostname, "The host whose commands to share")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to sync (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one directory")
+	}
+
+	key, err := kf.key()
+	if err != nil {
+		return err
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := merge.Sync(db, merge.SyncOptions{
+		Dir:      flags.Arg(0),
+		Hostname: hostname,
+		Now:      time.Now(),
+		Key:      key,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Written != "" {
+		fmt.Printf("wrote %d commands to %s\n", result.Exported, result.Written)
+	}
+
+	fmt.Printf("applied %d changesets: %d commands read, %d merged\n", len(result.Applied), result.Read, result.Inserted)
+
+	return nil
+}
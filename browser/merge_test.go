@@ -0,0 +1,225 @@
+package main_test
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/bundle"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/merge"
+)
+
+func mergeTestDB(t *testing.T, hostname string, entries ...string) *sql.DB {
+	t.Helper()
+
+	db, _ := testDB(t)
+	for i, entry := range entries {
+		insertMergeRow(t, db, hostname, int64(i+1), entry)
+	}
+
+	return db
+}
+
+func insertMergeRow(t *testing.T, db *sql.DB, hostname string, historyID int64, entry string) {
+	t.Helper()
+
+	_, err := history.Insert(db, history.Row{
+		Hostname:  hostname,
+		SessionID: hostname + "-session",
+		// offset by host so that commands from different hosts never tie
+		Timestamp: time.Unix(1641340800+historyID*60+int64(len(hostname)), 0),
+		HistoryID: &historyID,
+		Entry:     entry,
+	})
+	require.NoError(t, err)
+}
+
+func mergeTestEntries(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+
+	entries := make([]string, 0)
+	require.NoError(t, history.Each(db, history.Filter{}, func(r history.Row) error {
+		entries = append(entries, r.Hostname+": "+r.Entry)
+		return nil
+	}))
+	return entries
+}
+
+func TestMergeDatabases(t *testing.T) {
+	laptop := mergeTestDB(t, "laptop", "vim file1", "make test")
+	workstation := mergeTestDB(t, "workstation", "git status")
+
+	result, err := merge.Databases(workstation, laptop)
+	require.NoError(t, err)
+	require.Equal(t, merge.Result{Read: 2, Inserted: 2}, result)
+
+	// merging again is a no-op
+	result, err = merge.Databases(workstation, laptop)
+	require.NoError(t, err)
+	require.Equal(t, merge.Result{Read: 2, Inserted: 0}, result)
+
+	// as is merging back the other way, aside from picking up the workstation's command
+	result, err = merge.Databases(laptop, workstation)
+	require.NoError(t, err)
+	require.Equal(t, merge.Result{Read: 3, Inserted: 1}, result)
+
+	require.Equal(t, []string{"laptop: vim file1", "workstation: git status", "laptop: make test"}, mergeTestEntries(t, workstation))
+	require.Equal(t, mergeTestEntries(t, workstation), mergeTestEntries(t, laptop))
+}
+
+func TestMergeSync(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+
+	laptop := mergeTestDB(t, "laptop", "vim file1", "make test")
+	workstation := mergeTestDB(t, "workstation", "git status")
+
+	result, err := merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Exported)
+	require.Empty(t, result.Applied)
+
+	result, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(time.Second)})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Exported)
+	require.Len(t, result.Applied, 1)
+	require.Equal(t, 2, result.Inserted)
+
+	// the workstation shouldn't send the laptop's commands back to it
+	insertMergeRow(t, workstation, "workstation", 2, "ls")
+	result, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(2 * time.Second)})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Exported)
+	require.Empty(t, result.Applied)
+
+	result, err = merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now.Add(3 * time.Second)})
+	require.NoError(t, err)
+	require.Empty(t, result.Written, "nothing new on the laptop")
+	require.Len(t, result.Applied, 2)
+	require.Equal(t, 2, result.Inserted)
+
+	require.Equal(t, mergeTestEntries(t, workstation), mergeTestEntries(t, laptop))
+	require.Len(t, mergeTestEntries(t, laptop), 4)
+
+	// once everyone's caught up, syncing again doesn't do anything
+	for _, host := range []struct {
+		name string
+		db   *sql.DB
+	}{{"laptop", laptop}, {"workstation", workstation}} {
+		result, err = merge.Sync(host.db, merge.SyncOptions{Dir: dir, Hostname: host.name, Now: now.Add(4 * time.Second)})
+		require.NoError(t, err)
+		require.Equal(t, merge.SyncResult{}, result)
+	}
+}
+
+func TestMergeSyncUnfinished(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+
+	laptop := mergeTestDB(t, "laptop", "vim file1")
+	workstation := mergeTestDB(t, "workstation")
+
+	// a command that's still running, the way record start leaves it, and one
+	// run alongside it that's finished
+	finished := int64(0)
+	running, err := history.Insert(laptop, history.Row{Hostname: "laptop", SessionID: "laptop-session", Timestamp: now.Add(-time.Minute), Entry: "make test"})
+	require.NoError(t, err)
+	_, err = history.Insert(laptop, history.Row{Hostname: "laptop", SessionID: "laptop-session-2", Timestamp: now.Add(-time.Second), Entry: "ls", ExitStatus: &finished})
+	require.NoError(t, err)
+
+	result, err := merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Exported, "the running command waits for a later sync")
+
+	require.NoError(t, history.Finish(laptop, running, now.Add(time.Minute), 2))
+
+	result, err = merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now.Add(2 * time.Minute)})
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Exported, "the finished command goes out, along with the one after it")
+
+	_, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(3 * time.Minute)})
+	require.NoError(t, err)
+	require.Equal(t, mergeTestEntries(t, laptop), mergeTestEntries(t, workstation))
+
+	var exitStatus sql.NullInt64
+	require.NoError(t, workstation.QueryRow("SELECT exit_status FROM history WHERE entry = 'make test'").Scan(&exitStatus))
+	require.Equal(t, sql.NullInt64{Int64: 2, Valid: true}, exitStatus)
+
+	// a command that never finishes goes out eventually
+	_, err = history.Insert(laptop, history.Row{Hostname: "laptop", SessionID: "laptop-session", Timestamp: now.Add(5 * time.Minute), Entry: "sleep infinity"})
+	require.NoError(t, err)
+
+	result, err = merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now.Add(6 * time.Minute)})
+	require.NoError(t, err)
+	require.Zero(t, result.Exported)
+
+	result, err = merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now.Add(48 * time.Hour)})
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Exported)
+}
+
+func TestMergeSyncEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+
+	key, err := bundle.ParseKeyFile([]byte(bundle.GenerateKeyFile()))
+	require.NoError(t, err)
+
+	laptop := mergeTestDB(t, "laptop", "vim file1", "make test")
+	workstation := mergeTestDB(t, "workstation", "git status")
+
+	result, err := merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now, Key: key})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join("laptop", "1700000000000000000.jsonl.enc"), result.Written)
+
+	contents, err := os.ReadFile(filepath.Join(dir, result.Written))
+	require.NoError(t, err)
+	require.NotContains(t, string(contents), "make test")
+
+	// a host without the key can't apply the changeset, and keeps trying
+	_, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(time.Second)})
+	require.ErrorIs(t, err, merge.ErrEncryptedChangeset)
+
+	wrongKey, err := bundle.ParseKeyFile([]byte(bundle.GenerateKeyFile()))
+	require.NoError(t, err)
+	_, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(time.Second), Key: wrongKey})
+	require.ErrorIs(t, err, bundle.ErrWrongKey)
+
+	result, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now.Add(2 * time.Second), Key: key})
+	require.NoError(t, err)
+	require.Len(t, result.Applied, 1)
+	require.Equal(t, 2, result.Inserted)
+
+	result, err = merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now.Add(3 * time.Second), Key: key})
+	require.NoError(t, err)
+	require.Equal(t, mergeTestEntries(t, workstation), mergeTestEntries(t, laptop))
+}
+
+func TestMergeSyncTamperedChangeset(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+
+	key, err := bundle.ParseKeyFile([]byte(bundle.GenerateKeyFile()))
+	require.NoError(t, err)
+
+	laptop := mergeTestDB(t, "laptop", "vim file1")
+	workstation := mergeTestDB(t, "workstation")
+
+	result, err := merge.Sync(laptop, merge.SyncOptions{Dir: dir, Hostname: "laptop", Now: now, Key: key})
+	require.NoError(t, err)
+
+	changeset := filepath.Join(dir, result.Written)
+	contents, err := os.ReadFile(changeset)
+	require.NoError(t, err)
+	contents[len(contents)-1] ^= 1
+	require.NoError(t, os.WriteFile(changeset, contents, 0o600))
+
+	_, err = merge.Sync(workstation, merge.SyncOptions{Dir: dir, Hostname: "workstation", Now: now, Key: key})
+	require.ErrorIs(t, err, bundle.ErrTampered)
+	require.Empty(t, mergeTestEntries(t, workstation))
+}
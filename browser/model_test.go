@@ -0,0 +1,125 @@
+package main
+
+// Like the benchmarks, the model's tests live in package main rather than
+// main_test, since they drive the model directly.
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+// memoryModel creates a browser over an in-memory history of entries, run in
+// that order, sized like a typical terminal
+func memoryModel(t *testing.T, opts modelOptions, entries ...string) *model {
+	t.Helper()
+
+	rows := make([]history.Row, 0, len(entries))
+	for i, entry := range entries {
+		rows = append(rows, history.Row{
+			Hostname:  "host1",
+			SessionID: "1",
+			Timestamp: time.Unix(1641340800+int64(i), 0),
+			Entry:     entry,
+		})
+	}
+
+	m := newModel(store.NewMemory(rows...), nil, opts)
+	m.Init()
+	updated, _ := m.Update(tea.WindowSizeMsg{Width: 120, Height: 30})
+
+	return updated.(*model)
+}
+
+// visibleEntries are the entries of the rows m shows, in order
+func visibleEntries(m tea.Model) []string {
+	entries := make([]string, 0)
+	for _, row := range m.(*model).table.GetVisibleRows() {
+		entries = append(entries, row.Data["redacted_entry"].(string))
+	}
+	return entries
+}
+
+func TestModelMouse(t *testing.T) {
+	var m tea.Model = memoryModel(t, modelOptions{initialQueryCursor: -1}, "git status", "make test", "git push", "ls")
+	require.Equal(t, []string{"ls", "git push", "make test", "git status"}, visibleEntries(m))
+
+	click := func(x, y int) tea.Cmd {
+		var cmd tea.Cmd
+		m, cmd = m.Update(tea.MouseMsg{X: x, Y: y, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress})
+		return cmd
+	}
+
+	// the input takes up the first line, and the table's header the next
+	// three, so the third row is on the screen's seventh line
+	require.Nil(t, click(20, 6))
+	require.Equal(t, 2, m.(*model).table.GetHighlightedRowIndex())
+
+	// double clicking selects it
+	cmd := click(20, 6)
+	require.NotNil(t, cmd)
+	m, cmd = m.Update(cmd())
+	require.NotNil(t, cmd, "selecting quits")
+	require.Equal(t, "make test", m.(*model).selection["raw_entry"])
+
+	// clicking the entry column's header sorts by it
+	m = memoryModel(t, modelOptions{initialQueryCursor: -1}, "git status", "make test", "git push", "ls")
+	click(40, 2)
+	require.Equal(t, []string{"git push", "git status", "ls", "make test"}, visibleEntries(m))
+	require.Contains(t, m.View(), "entry ▲ of 4", "just the results shown are sorted")
+
+	// the mouse is ignored while the help is shown
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyF1})
+	click(40, 2)
+	require.Equal(t, []string{"git push", "git status", "ls", "make test"}, visibleEntries(m))
+}
+
+func TestModelInitialQuery(t *testing.T) {
+	seeded := func(args ...string) *model {
+		t.Helper()
+
+		qf := queryFlags{}
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		qf.register(flags)
+		require.NoError(t, flags.Parse(args))
+		_, err := qf.readStdin(strings.NewReader("git st\n"))
+		require.NoError(t, err)
+
+		return memoryModel(t, modelOptions{initialQuery: qf.query, initialQueryCursor: qf.cursor}, "git status", "make test")
+	}
+
+	m := seeded("--query", "sudo apt", "--query-cursor", "4")
+	require.Equal(t, "sudo apt", m.input.Value())
+	require.Equal(t, 4, m.input.Position())
+
+	// the cursor defaults to the end of the query
+	m = seeded("--query", "sudo apt")
+	require.Equal(t, "sudo apt", m.input.Value())
+	require.Equal(t, 8, m.input.Position())
+
+	// and is kept within it
+	m = seeded("--query", "sudo", "--query-cursor", "10")
+	require.Equal(t, 4, m.input.Position())
+
+	m = seeded("--query", "-", "--query-cursor", "3")
+	require.Equal(t, "git st", m.input.Value())
+	require.Equal(t, 3, m.input.Position())
+	require.Equal(t, []string{"git status"}, visibleEntries(m))
+
+	t.Setenv("HISTDB_BROWSER_QUERY", "make")
+	m = seeded()
+	require.Equal(t, "make", m.input.Value())
+	require.Equal(t, 4, m.input.Position())
+	require.Equal(t, []string{"make test"}, visibleEntries(m))
+
+	// the flag overrides the environment
+	m = seeded("--query", "git")
+	require.Equal(t, "git", m.input.Value())
+}
@@ -2,6 +2,12 @@
 
 package main
 
-func init() {
-	panic("Sorry, you need to build me with -tags libsqlite3")
+import "errors"
+
+// without the system SQLite there's no Lua vtable extension to load, so h has
+// to be emulated
+const defaultBackend = "go"
+
+func vtableDriver() (string, error) {
+	return "", errors.New("the vtable backend needs a build with -tags libsqlite3 (try --backend go)")
 }
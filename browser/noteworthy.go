@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/noteworthy"
+	"hoelz.ro/histdb-browser/internal/table"
+)
+
+func noteworthyRow(data table.RowData) noteworthy.Row {
+	// the timestamp column may be toggled off
+	rowid, _ := data["rowid"].(string)
+	timestamp, _ := data["timestamp"].(string)
+	entry, _ := data["redacted_entry"].(string)
+
+	return noteworthy.Row{
+		Rowid:     rowid,
+		Timestamp: timestamp,
+		Entry:     entry,
+	}
+}
+
+// markNoteworthy records a snapshot of the browser's current state
+func (m *model) markNoteworthy() (int64, error) {
+	if m.db == nil {
+		return 0, errors.New("noteworthy markers aren't available for this history source")
+	}
+
+	highlightedIndex := m.table.GetHighlightedRowIndex()
+	visibleRows := m.table.GetVisibleRows()
+	rows := make([]noteworthy.Row, 0, len(visibleRows))
+
+	for i, row := range visibleRows {
+		r := noteworthyRow(row.Data)
+		r.Highlighted = i == highlightedIndex
+		rows = append(rows, r)
+	}
+
+	f := m.queryFilter()
+
+	marker := &noteworthy.Marker{
+		MarkedAt:         time.Now(),
+		BrowserStartedAt: m.startedAt,
+		Query:            m.input.Value(),
+		State: noteworthy.State{
+			Columns:            f.Columns,
+			ShowFailedCommands: f.ShowFailedCommands,
+			ShowGlobalCommands: f.ShowGlobalCommands,
+			HorizonTimestamp:   f.HorizonTimestamp.Unix(),
+			SessionID:          f.SessionID,
+			ShowPins:           m.showPins,
+		},
+		Rows: rows,
+	}
+
+	if err := noteworthy.Record(m.db, marker); err != nil {
+		return 0, err
+	}
+
+	m.noteworthyIDs = append(append([]int64{}, m.noteworthyIDs...), marker.ID)
+
+	return marker.ID, nil
+}
+
+func runNoteworthy(args []string) error {
+	databasePath := defaultDatabasePath()
+
+	flags := pflag.NewFlagSet("noteworthy", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser noteworthy [flags] list")
+		fmt.Fprintln(os.Stderr, "       histdb-browser noteworthy [flags] show ID")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Reviews the browser sessions marked as noteworthy with F12.")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&databasePath, "database", databasePath, "The history database the markers are in (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("expected list or show")
+	}
+
+	db, err := history.OpenReadOnly(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch flags.Arg(0) {
+	case "list":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("list doesn't take any arguments")
+		}
+
+		markers, err := noteworthy.List(db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tMARKED AT\tROWS\tSELECTED\tQUERY")
+		for _, m := range markers {
+			selected := "-"
+			if m.Selection != nil {
+				selected = m.Selection.Rowid
+			}
+			fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%q\n", m.ID, m.MarkedAt.Format("2006-01-02 15:04:05"), len(m.Rows), selected, m.Query)
+		}
+		return w.Flush()
+	case "show":
+		if flags.NArg() != 2 {
+			flags.Usage()
+			return errors.New("show takes a marker ID")
+		}
+
+		id, err := strconv.ParseInt(flags.Arg(1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid marker ID %q", flags.Arg(1))
+		}
+
+		m, err := noteworthy.Get(db, id)
+		if err != nil {
+			return err
+		}
+
+		return noteworthy.WriteReport(os.Stdout, m)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown action %q (expected list or show)", flags.Arg(0))
+	}
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/noteworthy"
+)
+
+func TestNoteworthyMarkers(t *testing.T) {
+	db, _ := testDB(t)
+
+	require.NoError(t, noteworthy.EnsureSchema(db))
+
+	startedAt := time.Unix(1700000000, 0)
+
+	first := &noteworthy.Marker{
+		MarkedAt:         startedAt.Add(5 * time.Second),
+		BrowserStartedAt: startedAt,
+		Query:            "git push",
+		State: noteworthy.State{
+			Columns:            []string{"timestamp"},
+			ShowFailedCommands: true,
+			SessionID:          "737207",
+		},
+		Rows: []noteworthy.Row{
+			{Rowid: "12", Timestamp: "2023-11-14 22:13:25", Entry: "git push origin main"},
+			{Rowid: "7", Timestamp: "2023-11-14 21:00:00", Entry: "git push --force", Highlighted: true},
+		},
+	}
+	require.NoError(t, noteworthy.Record(db, first))
+
+	second := &noteworthy.Marker{
+		MarkedAt:         startedAt.Add(10 * time.Second),
+		BrowserStartedAt: startedAt,
+		Query:            "git push -f",
+		Rows:             []noteworthy.Row{},
+	}
+	require.NoError(t, noteworthy.Record(db, second))
+	require.NotEqual(t, first.ID, second.ID)
+
+	selection := noteworthy.Row{Rowid: "7", Entry: "git push --force"}
+	require.NoError(t, noteworthy.SetSelection(db, []int64{first.ID, second.ID}, selection))
+
+	markers, err := noteworthy.List(db)
+	require.NoError(t, err)
+	require.Len(t, markers, 2)
+	require.Equal(t, second.ID, markers[0].ID, "newest first")
+	require.Equal(t, &selection, markers[0].Selection)
+
+	got, err := noteworthy.Get(db, first.ID)
+	require.NoError(t, err)
+	first.Selection = &selection
+	require.Equal(t, *first, got)
+
+	_, err = noteworthy.Get(db, second.ID+1)
+	require.ErrorIs(t, err, noteworthy.ErrNoSuchMarker)
+
+	var report strings.Builder
+	require.NoError(t, noteworthy.WriteReport(&report, got))
+	require.Contains(t, report.String(), `Query:           "git push"`)
+	require.Contains(t, report.String(), "Failed commands: shown\nGlobal commands: hidden\n")
+	require.Contains(t, report.String(), "Visible rows (2):\n      12  2023-11-14 22:13:25  git push origin main\n>      7  2023-11-14 21:00:00  git push --force\n")
+	require.Contains(t, report.String(), "Selection: 7  git push --force\n")
+}
+
+func TestNoteworthyWithoutMarkers(t *testing.T) {
+	db, _ := testDB(t)
+
+	// nothing's been marked, so there's no table to read markers from
+	markers, err := noteworthy.List(db)
+	require.NoError(t, err)
+	require.Empty(t, markers)
+
+	_, err = noteworthy.Get(db, 1)
+	require.ErrorIs(t, err, noteworthy.ErrNoSuchMarker)
+
+	exists, err := noteworthy.Exists(db)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	require.NoError(t, noteworthy.Record(db, &noteworthy.Marker{MarkedAt: time.Unix(1700000000, 0), Query: "git"}))
+
+	markers, err = noteworthy.List(db)
+	require.NoError(t, err)
+	require.Len(t, markers, 1)
+}
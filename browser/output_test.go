@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/output"
+)
+
+var multiLineRow = map[string]string{
+	"rowid":       "42",
+	"hostname":    "host1",
+	"session_id":  "017e12ef-9c00-7a64-ae73-cffc1360299c",
+	"timestamp":   "2022-01-01 00:00:00",
+	"cwd":         "/home/rob",
+	"duration":    "10",
+	"exit_status": "0",
+	"entry":       "for f in *; do\n\techo \"$f\" 'it''s'\ndone",
+}
+
+func TestOutputParseFormat(t *testing.T) {
+	for _, name := range []string{"raw", "nul", "shell", "json", "tsv"} {
+		f, err := output.ParseFormat(name)
+		require.NoError(t, err)
+		require.Equal(t, name, string(f))
+	}
+
+	_, err := output.ParseFormat("yaml")
+	require.Error(t, err)
+}
+
+func TestOutputJSON(t *testing.T) {
+	var b bytes.Buffer
+
+	require.NoError(t, output.WriteRow(&b, output.FormatJSON, output.Fields, multiLineRow))
+	require.True(t, strings.HasSuffix(b.String(), "}\n"))
+	require.Equal(t, 1, strings.Count(b.String(), "\n"), "JSON output should be a single line")
+	require.True(t, strings.HasPrefix(b.String(), `{"rowid":"42","hostname":"host1",`), "fields should be in order")
+
+	var got map[string]string
+	require.NoError(t, json.Unmarshal(b.Bytes(), &got))
+	require.Equal(t, multiLineRow, got)
+}
+
+func TestOutputTSV(t *testing.T) {
+	var b bytes.Buffer
+
+	require.NoError(t, output.WriteRow(&b, output.FormatTSV, output.Fields, multiLineRow))
+
+	fields := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\t")
+	require.Len(t, fields, len(output.Fields))
+	require.Equal(t, "host1", fields[1])
+	require.Equal(t, `for f in *; do\n\techo "$f" 'it''s'\ndone`, fields[len(fields)-1])
+}
+
+func TestOutputNUL(t *testing.T) {
+	var b bytes.Buffer
+
+	require.NoError(t, output.WriteValue(&b, output.FormatNUL, multiLineRow["entry"]))
+	require.Equal(t, multiLineRow["entry"]+"\x00", b.String())
+}
+
+func TestOutputShell(t *testing.T) {
+	var b bytes.Buffer
+
+	require.NoError(t, output.WriteValue(&b, output.FormatShell, multiLineRow["entry"]))
+
+	// make sure a real shell round-trips the quoted value
+	out, err := exec.Command("sh", "-c", `eval "x=$1"; printf %s "$x"`, "sh", b.String()).Output()
+	require.NoError(t, err)
+	require.Equal(t, multiLineRow["entry"], string(out))
+}
+
+func TestOutputValueOnlyFormatsRejectRows(t *testing.T) {
+	var b bytes.Buffer
+
+	require.Error(t, output.WriteValue(&b, output.FormatJSON, "ls"))
+}
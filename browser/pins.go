@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"hoelz.ro/histdb-browser/internal/pins"
+)
+
+// togglePin pins the highlighted command, or unpins it if it's already pinned
+func (m *model) togglePin() {
+	if m.db == nil {
+		m.flashMessage = "Pins aren't available for this history source"
+		return
+	}
+
+	row := m.table.HighlightedRow().Data
+	entry, isString := row["raw_entry"].(string)
+	if !isString {
+		m.flashMessage = "Nothing to pin"
+		return
+	}
+
+	pinned, err := pins.IsPinned(m.db, entry)
+	if err == nil {
+		if pinned {
+			err = pins.Unpin(m.db, entry)
+		} else {
+			id, _ := rowid(row)
+			err = pins.Pin(m.db, entry, id, time.Now())
+		}
+	}
+
+	if err != nil {
+		slog.Error("unable to update pins", "error", err)
+		m.flashMessage = fmt.Sprintf("Unable to update pins: %v", err)
+		return
+	}
+	m.queryCache.Clear()
+
+	if pinned {
+		slog.Info("unpinned command")
+		m.flashMessage = "Unpinned"
+	} else {
+		slog.Info("pinned command")
+		m.flashMessage = "Pinned"
+	}
+}
+
+// togglePinsView switches between the search results and the pinned commands
+func (m *model) togglePinsView() {
+	if m.db == nil && !m.showPins {
+		m.flashMessage = "Pins aren't available for this history source"
+		return
+	}
+
+	m.showPins = !m.showPins
+
+	if m.showPins {
+		m.input.Prompt = "pinned> "
+		m.flashMessage = "Showing pinned commands"
+	} else {
+		m.input.Prompt = "> "
+		m.flashMessage = "Showing search results"
+	}
+}
@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+func TestPins(t *testing.T) {
+	db, rowids := annotationTestDB(t)
+	require.NoError(t, pins.EnsureSchema(db))
+
+	now := time.Unix(1700000000, 0)
+
+	pinned, err := pins.IsPinned(db, "ls")
+	require.NoError(t, err)
+	require.False(t, pinned)
+
+	require.NoError(t, pins.Pin(db, "ls", rowids[2], now))
+	require.NoError(t, pins.Pin(db, "sudo resolvectl flush-caches", rowids[1], now.Add(time.Second)))
+	require.NoError(t, pins.Pin(db, "ls", rowids[2], now.Add(2*time.Second)), "pinning twice is fine")
+
+	pinned, err = pins.IsPinned(db, "ls")
+	require.NoError(t, err)
+	require.True(t, pinned)
+
+	sql, args := pins.SQL("")
+	_, rows, err := query.Run(db, sql, args...)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, "ls", rows[0]["entry"], "most recently pinned first")
+	require.Equal(t, "sudo resolvectl flush-caches", rows[1]["entry"])
+
+	// words match separately, like they do in the browser's search
+	for _, q := range []string{"flush sudo", "RESOLVE  caches"} {
+		sql, args = pins.SQL(q)
+		_, rows, err = query.Run(db, sql, args...)
+		require.NoError(t, err, q)
+		require.Len(t, rows, 1, q)
+		require.Equal(t, "sudo resolvectl flush-caches", rows[0]["entry"], q)
+	}
+
+	// pins outlive the history rows they came from
+	_, err = history.Delete(db, []int64{rowids[1]})
+	require.NoError(t, err)
+	_, rows, err = query.Run(db, sql, args...)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "", rows[0]["rowid"])
+
+	require.NoError(t, pins.Unpin(db, "ls"))
+	pinned, err = pins.IsPinned(db, "ls")
+	require.NoError(t, err)
+	require.False(t, pinned)
+}
+
+func TestPinsFloat(t *testing.T) {
+	db, _ := annotationTestDB(t)
+	require.NoError(t, pins.EnsureSchema(db))
+
+	require.NoError(t, pins.Pin(db, "kubectl rollout restart deploy/api", 0, time.Unix(1700000000, 0)))
+
+	f := query.Filter{ShowFailedCommands: true, ShowGlobalCommands: true}
+
+	for floatPins, expected := range map[bool]string{false: "ls", true: "kubectl rollout restart deploy/api"} {
+		f.FloatPins = floatPins
+		_, rows, err := query.Search(db, f)
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		require.Equal(t, expected, rows[0]["entry"], "float pins: %v", floatPins)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// queryFlags are the flags that seed the browser's query, which the shell
+// widgets use to pass along the command line being edited
+type queryFlags struct {
+	query  string
+	cursor int // -1 for the end of the query
+}
+
+func (qf *queryFlags) register(flags *pflag.FlagSet) {
+	flags.StringVar(&qf.query, "query", os.Getenv("HISTDB_BROWSER_QUERY"), "The query to start with (- to read it from standard input; defaults to $HISTDB_BROWSER_QUERY)")
+	flags.IntVar(&qf.cursor, "query-cursor", -1, "The cursor position within the starting query (defaults to the end)")
+}
+
+// readStdin replaces a query of - with what stdin holds, minus a trailing
+// newline, reporting whether it did so
+func (qf *queryFlags) readStdin(stdin io.Reader) (bool, error) {
+	if qf.query != "-" {
+		return false, nil
+	}
+
+	queryBytes, err := io.ReadAll(stdin)
+	if err != nil {
+		return false, err
+	}
+	qf.query = strings.TrimSuffix(string(queryBytes), "\n")
+	return true, nil
+}
@@ -0,0 +1,161 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/query"
+)
+
+func TestQueryFilterDefaults(t *testing.T) {
+	sql, args := query.Filter{
+		Columns:            []string{"timestamp"},
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+	}.SQL()
+
+	require.Equal(t, "SELECT rowid, timestamp, entry, COALESCE(exit_status, '') AS exit_status FROM h WHERE timestamp IS NOT NULL ORDER BY timestamp DESC LIMIT 100", sql)
+	require.Empty(t, args)
+}
+
+func TestQueryFilterAllPredicates(t *testing.T) {
+	sql, args := query.Filter{
+		Query:            "git commit",
+		Columns:          []string{"timestamp", "cwd"},
+		HorizonTimestamp: time.Unix(1641340800, 0),
+		SessionID:        "737207",
+		Limit:            5,
+	}.SQL()
+
+	require.Equal(t, "SELECT rowid, timestamp, cwd, entry, COALESCE(exit_status, '') AS exit_status FROM h WHERE timestamp IS NOT NULL AND entry MATCH ? AND exit_status IN (0, 148) AND (raw_timestamp <= ? OR session_id = ?) ORDER BY timestamp DESC LIMIT 5", sql)
+	require.Equal(t, []any{"git commit", int64(1641340800), "737207"}, args)
+}
+
+func TestQueryFilterUnsetHorizon(t *testing.T) {
+	// without a horizon there's nothing to distinguish global commands by
+	sql, args := query.Filter{
+		HorizonTimestamp: time.Unix(0, 0),
+		SessionID:        "737207",
+	}.SQL()
+
+	require.NotContains(t, sql, "raw_timestamp")
+	require.Empty(t, args)
+}
+
+func TestQueryFilterValidate(t *testing.T) {
+	require.NoError(t, query.Filter{Columns: []string{"cwd", "hostname"}}.Validate())
+	require.Error(t, query.Filter{Columns: []string{"cwd; DROP TABLE history"}}.Validate())
+}
+
+func TestQueryFilterScope(t *testing.T) {
+	where, args := query.Filter{
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+		Since:              time.Unix(1640995200, 0),
+		Until:              time.Unix(1641081600, 0),
+		Hostname:           "host1",
+	}.Where()
+
+	require.Equal(t, "timestamp IS NOT NULL AND raw_timestamp >= ? AND raw_timestamp < ? AND hostname = ?", where)
+	require.Equal(t, []any{int64(1640995200), int64(1641081600), "host1"}, args)
+}
+
+func TestQueryFilterExcludeRowids(t *testing.T) {
+	where, args := query.Filter{
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+		Hostname:           "host1",
+		ExcludeRowids:      []int64{3, 5},
+	}.Where()
+
+	require.Equal(t, "timestamp IS NOT NULL AND hostname = ? AND rowid NOT IN (?, ?)", where)
+	require.Equal(t, []any{"host1", int64(3), int64(5)}, args)
+}
+
+func TestQueryFilterTags(t *testing.T) {
+	where, args := query.Filter{
+		Query:              "kubectl tag:deploy apply tag:#k8s",
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+	}.Where()
+
+	require.Equal(t, "timestamp IS NOT NULL AND entry MATCH ? AND rowid IN (SELECT history_rowid FROM histdb_tags WHERE tag = ?) AND rowid IN (SELECT history_rowid FROM histdb_tags WHERE tag = ?)", where)
+	require.Equal(t, []any{"kubectl apply", "deploy", "k8s"}, args)
+
+	require.NoError(t, query.Filter{Columns: []string{"tags", "note"}}.Validate())
+}
+
+func TestQueryParseTerms(t *testing.T) {
+	require.Equal(t, query.Terms{Text: "git  push"}, query.ParseTerms("git  push"))
+	require.Equal(t, query.Terms{
+		Text:       "git push",
+		Tags:       []string{"deploy"},
+		Cwds:       []string{"histdb"},
+		Timestamps: []string{"since 3 days ago", "yesterday"},
+	}, query.ParseTerms(`git cwd:histdb timestamp:"since 3 days ago" push tag:#deploy timestamp:yesterday`))
+
+	// a quote that hasn't been closed yet runs to the end of the query
+	require.Equal(t, query.Terms{Text: "git", Timestamps: []string{"since 3"}}, query.ParseTerms(`git timestamp:"since 3`))
+
+	where, args := query.Filter{
+		Query:              "cwd:histdb timestamp:yesterday timestamp:next_tuesday",
+		ShowFailedCommands: true,
+		ShowGlobalCommands: true,
+	}.Where()
+
+	require.Equal(t, "timestamp IS NOT NULL AND cwd MATCH ? AND timestamp MATCH ? AND 0", where)
+	require.Equal(t, []any{"histdb", "yesterday"}, args)
+}
+
+func TestQueryParseTime(t *testing.T) {
+	now := time.Date(2022, 3, 10, 12, 0, 0, 0, time.Local)
+
+	for input, expected := range map[string]time.Time{
+		"2022-03-01":          time.Date(2022, 3, 1, 0, 0, 0, 0, time.Local),
+		"2022-03-01 08:15:00": time.Date(2022, 3, 1, 8, 15, 0, 0, time.Local),
+		"7d":                  now.Add(-7 * 24 * time.Hour),
+		"2w":                  now.Add(-14 * 24 * time.Hour),
+		"36h":                 now.Add(-36 * time.Hour),
+	} {
+		got, err := query.ParseTime(input, now)
+		require.NoError(t, err, input)
+		require.True(t, expected.Equal(got), "%s: expected %s, got %s", input, expected, got)
+	}
+
+	_, err := query.ParseTime("last tuesday", now)
+	require.Error(t, err)
+}
+
+func TestQueryCache(t *testing.T) {
+	db, _ := annotationTestDB(t)
+	c := query.NewCache(2)
+
+	run := func(sql string, args ...any) ([]map[string]string, bool) {
+		_, results, hit, err := c.Run(sql, args, func() ([]string, []map[string]string, error) {
+			return query.Run(db, sql, args...)
+		})
+		require.NoError(t, err)
+		return results, hit
+	}
+
+	results, hit := run("SELECT entry FROM h WHERE entry = ?", "ls")
+	require.False(t, hit)
+	require.Len(t, results, 1)
+
+	_, hit = run("SELECT entry FROM h WHERE entry = ?", "ls")
+	require.True(t, hit)
+
+	_, hit = run("SELECT entry FROM h WHERE entry = ?", "sudo resolvectl flush-caches")
+	require.False(t, hit, "different arguments are a different query")
+
+	// the oldest entry is evicted to make room
+	run("SELECT entry FROM h")
+	_, hit = run("SELECT entry FROM h WHERE entry = ?", "ls")
+	require.False(t, hit)
+
+	c.Clear()
+	_, hit = run("SELECT entry FROM h")
+	require.False(t, hit)
+}
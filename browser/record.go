@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/history"
+)
+
+const recordUsage = `usage: histdb-browser record start [flags] COMMAND
+       histdb-browser record finish [flags]
+
+start records COMMAND as having just started and prints the new row's OID,
+which finish then takes to record the exit status and duration.  For example,
+in zsh:
+
+  zshaddhistory() { _histdb_oid=$(histdb-browser record start --history-id $HISTCMD -- "${1%%$'\n'}") }
+  precmd() { local s=$?; [[ -n $_histdb_oid ]] && histdb-browser record finish --oid $_histdb_oid --exit-status $s; _histdb_oid= }
+`
+
+func runRecord(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, recordUsage)
+		return errors.New("expected start or finish")
+	}
+
+	switch args[0] {
+	case "start":
+		return runRecordStart(args[1:])
+	case "finish":
+		return runRecordFinish(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, recordUsage)
+		return fmt.Errorf("unknown phase %q", args[0])
+	}
+}
+
+func runRecordStart(args []string) error {
+	historyID := int64(-1)
+	databasePath := defaultDatabasePath()
+	r := history.Row{
+		Timestamp: time.Now(),
+	}
+
+	r.SessionID = os.Getenv("HISTDB_SESSION_ID")
+	if r.SessionID == "" {
+		r.SessionID = strconv.Itoa(os.Getppid())
+	}
+
+	// failing to determine these isn't fatal - they'll just be NULL
+	r.Hostname, _ = os.Hostname()
+	r.Cwd, _ = os.Getwd()
+
+	flags := pflag.NewFlagSet("record start", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, recordUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&r.SessionID, "session-id", r.SessionID, "The session the command ran in (defaults to $HISTDB_SESSION_ID or the parent PID)")
+	flags.Int64Var(&historyID, "history-id", historyID, "The shell's history number for the command, if any")
+	flags.StringVar(&r.Hostname, "hostname", r.Hostname, "The host the command ran on")
+	flags.StringVar(&r.Cwd, "cwd", r.Cwd, "The directory the command ran in")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to record to (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one command")
+	}
+
+	r.Entry = flags.Arg(0)
+	if r.Entry == "-" {
+		entryBytes, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		r.Entry = strings.TrimSuffix(string(entryBytes), "\n")
+	}
+
+	if strings.TrimSpace(r.Entry) == "" {
+		return nil
+	}
+
+	if historyID >= 0 {
+		r.HistoryID = &historyID
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rowid, err := history.Insert(db, r)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(rowid)
+
+	return nil
+}
+
+func runRecordFinish(args []string) error {
+	rowid := int64(0)
+	exitStatus := 0
+	databasePath := defaultDatabasePath()
+
+	flags := pflag.NewFlagSet("record finish", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, recordUsage)
+		flags.PrintDefaults()
+	}
+	flags.Int64Var(&rowid, "oid", rowid, "The OID printed by record start")
+	flags.IntVar(&exitStatus, "exit-status", exitStatus, "The command's exit status")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to record to (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if !flags.Changed("oid") {
+		flags.Usage()
+		return errors.New("--oid is required")
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return history.Finish(db, rowid, time.Now(), exitStatus)
+}
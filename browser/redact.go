@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/redact"
+)
+
+// redactFlags are the flags shared by everything that redacts secrets from
+// the commands it shows or writes
+type redactFlags struct {
+	rulesPath string
+	disabled  bool
+}
+
+func (rf *redactFlags) register(flags *pflag.FlagSet, withDisable bool) {
+	flags.StringVar(&rf.rulesPath, "redact-rules", redact.DefaultRulesPath(), "A file of extra redaction rules (defaults to $HISTDB_REDACT_RULES)")
+	if withDisable {
+		flags.BoolVar(&rf.disabled, "no-redact", false, "Don't redact secrets from commands")
+	}
+}
+
+// redactor returns the redactor selected by the flags; a nil *Redactor leaves
+// everything alone
+func (rf *redactFlags) redactor() (*redact.Redactor, error) {
+	if rf.disabled {
+		return nil, nil
+	}
+	return redact.Load(rf.rulesPath)
+}
+
+func runRedact(args []string) error {
+	rf := redactFlags{}
+	apply := false
+	assumeYes := false
+	databasePath := defaultDatabasePath()
+
+	flags := pflag.NewFlagSet("redact", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser redact [flags]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Lists the commands, pins and notes in history containing secrets, as they'd look redacted.")
+		fmt.Fprintln(os.Stderr, "With --apply, rewrites them in the database, leaving no trace of the secrets - this can't be undone!")
+		flags.PrintDefaults()
+	}
+	rf.register(flags, false)
+	flags.BoolVar(&apply, "apply", apply, "Rewrite the matching commands, pins and notes in the database")
+	flags.BoolVarP(&assumeYes, "yes", "y", assumeYes, "Don't ask for confirmation before rewriting commands")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to redact (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	redactor, err := rf.redactor()
+	if err != nil {
+		return err
+	}
+
+	db, err := history.Open(databasePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	redacted := make(map[int64]string)
+	rowids := make([]int64, 0)
+
+	err = history.Each(db, history.Filter{}, func(r history.Row) error {
+		if entry := redactor.Redact(r.Entry); entry != r.Entry {
+			redacted[r.Rowid] = entry
+			rowids = append(rowids, r.Rowid)
+			fmt.Printf("%d\t%s\n", r.Rowid, strings.ReplaceAll(entry, "\n", "\\n"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// pins and notes keep copies of commands too
+	pinned, err := pins.Entries(db)
+	if err != nil {
+		return err
+	}
+
+	redactedPins := make(map[string]string)
+	for _, entry := range pinned {
+		if redactedEntry := redactor.Redact(entry); redactedEntry != entry {
+			redactedPins[entry] = redactedEntry
+			fmt.Printf("pin\t%s\n", strings.ReplaceAll(redactedEntry, "\n", "\\n"))
+		}
+	}
+
+	notes, err := annotation.Notes(db)
+	if err != nil {
+		return err
+	}
+
+	redactedNotes := make(map[int64]string)
+	for _, rowid := range slices.Sorted(maps.Keys(notes)) {
+		note := notes[rowid]
+		if redactedNote := redactor.Redact(note); redactedNote != note {
+			redactedNotes[rowid] = redactedNote
+			fmt.Printf("note %d\t%s\n", rowid, strings.ReplaceAll(redactedNote, "\n", "\\n"))
+		}
+	}
+
+	changes := len(rowids) + len(redactedPins) + len(redactedNotes)
+	if !apply || changes == 0 {
+		return nil
+	}
+
+	prompt := fmt.Sprintf("Rewrite %d %s, %d %s and %d %s? This can't be undone. [y/N] ",
+		len(rowids), pluralize(len(rowids), "command", "commands"),
+		len(redactedPins), pluralize(len(redactedPins), "pin", "pins"),
+		len(redactedNotes), pluralize(len(redactedNotes), "note", "notes"))
+	if !assumeYes && !confirm(os.Stdin, prompt) {
+		return errors.New("not confirmed")
+	}
+
+	// the secrets shouldn't be left behind in the database file's free pages
+	err = history.WithSecureDelete(db, func(tx *sql.Tx) error {
+		for _, rowid := range rowids {
+			if err := history.SetEntry(tx, rowid, redacted[rowid]); err != nil {
+				return err
+			}
+		}
+
+		for entry, redactedEntry := range redactedPins {
+			if err := pins.Rename(tx, entry, redactedEntry); err != nil {
+				return err
+			}
+		}
+
+		for rowid, redactedNote := range redactedNotes {
+			if err := annotation.SetNote(tx, rowid, redactedNote); err != nil {
+				return err
+			}
+		}
+
+		return fts.Reindex(tx, rowids)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "rewrote %d %s, %d %s and %d %s\n",
+		len(rowids), pluralize(len(rowids), "command", "commands"),
+		len(redactedPins), pluralize(len(redactedPins), "pin", "pins"),
+		len(redactedNotes), pluralize(len(redactedNotes), "note", "notes"))
+
+	return nil
+}
+
+// confirm asks the user a yes or no question on standard error
+func confirm(r io.Reader, prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/replay"
+	"hoelz.ro/histdb-browser/internal/store"
+	"hoelz.ro/histdb-browser/internal/table"
+)
+
+// replayLog drives a model through the events in l, returning the queries it
+// ran and the rowid of the row it selected
+func replayLog(db *sql.DB, l *replay.Log, opts modelOptions) ([]replay.Query, string) {
+	recorder := &replay.Recorder{}
+
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(recorder))
+	defer slog.SetDefault(previousLogger)
+
+	// clicks are timed by when they were logged, so that double clicks are
+	// replayed as double clicks and nothing else is
+	var now time.Time
+	opts.clock = func() time.Time { return now }
+
+	opts.synchronousStats = true
+	var m tea.Model = newModel(store.NewSQLite(db, opts.backend), db, opts)
+	// like bubbletea does, or the input isn't focused and ignores keypresses;
+	// the command it returns only makes the cursor blink
+	m.Init()
+
+	for _, event := range l.Events {
+		var msg tea.Msg
+		now = event.Time
+
+		switch event.Kind {
+		case replay.KeyPress:
+			msg = event.Key
+		case replay.Mouse:
+			msg = event.Mouse
+		case replay.Resize:
+			msg = tea.WindowSizeMsg{Width: event.Width, Height: event.Height}
+		case replay.UndoWindowPassed:
+			msg = deletionTimeoutMsg{id: event.DeletionID}
+		}
+
+		// commands are deliberately dropped: they're timers, which are
+		// replayed from the log (the undo window passing), or computing
+		// statistics, which synchronousStats has the model do within Update.
+		// The exception is double clicks, which select rows with a command.
+		var cmd tea.Cmd
+		m, cmd = m.Update(msg)
+		if event.Kind == replay.Mouse && cmd != nil {
+			if selected, isSelectedMsg := cmd().(table.SelectedMsg); isSelectedMsg {
+				m, _ = m.Update(selected)
+			}
+		}
+	}
+
+	selection := ""
+	if final := m.(*model); final.selection != nil {
+		selection, _ = final.selection["rowid"].(string)
+	}
+
+	return recorder.Queries, selection
+}
+
+func runReplay(args []string) error {
+	databasePath := defaultDatabasePath()
+	pid := ""
+	sessionID := ""
+	horizonTimestamp := uint64(0)
+	initialQuery := ""
+	verbose := false
+	bf := backendFlags{}
+	rf := redactFlags{}
+
+	flags := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser replay [flags] LOG-FILE")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Re-drives the browser through the keypresses and mouse events in a log written with")
+		fmt.Fprintln(os.Stderr, "--log-format json --log-level debug, against a copy of the database, and")
+		fmt.Fprintln(os.Stderr, "reports where the queries run or the row selected differ from the log.")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to replay against (defaults to $HISTDB_PATH)")
+	flags.StringVar(&pid, "pid", pid, "The browser session to replay, by PID (defaults to the first in the log)")
+	flags.StringVar(&sessionID, "session-id", sessionID, "Override the session ID the browser started with")
+	flags.Uint64Var(&horizonTimestamp, "horizon-timestamp", horizonTimestamp, "Override the horizon timestamp the browser started with")
+	flags.StringVar(&initialQuery, "query", initialQuery, "Override the query the browser started with")
+	flags.BoolVarP(&verbose, "verbose", "v", verbose, "Print every query, not just the ones that differ")
+	bf.register(flags)
+	rf.register(flags, true)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one log file")
+	}
+
+	logFile, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	l, err := replay.Parse(logFile, pid)
+	if err != nil {
+		return err
+	}
+
+	opts := modelOptions{initialQueryCursor: -1}
+	if l.Start != nil {
+		opts = modelOptions{
+			initialQuery:       l.Start.Query,
+			initialQueryCursor: l.Start.QueryCursor,
+			horizonTimestamp:   time.Unix(l.Start.HorizonTimestamp, 0),
+			sessionID:          l.Start.SessionID,
+			floatPins:          l.Start.FloatPins,
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "warning: the log doesn't say how the browser was started; use --session-id, --horizon-timestamp, and --query if it matters")
+	}
+
+	if flags.Changed("session-id") {
+		opts.sessionID = sessionID
+	}
+	if flags.Changed("horizon-timestamp") {
+		opts.horizonTimestamp = time.Unix(int64(horizonTimestamp), 0)
+	}
+	if l.Start != nil && l.Start.Backend != "" && !flags.Changed("backend") {
+		bf.name = l.Start.Backend
+	}
+	opts.backend, err = bf.backend()
+	if err != nil {
+		return err
+	}
+
+	if flags.Changed("query") {
+		opts.initialQuery = initialQuery
+		opts.initialQueryCursor = -1
+	}
+
+	// redact the way the browser did, since it changes what the rows show
+	if l.Start != nil && !flags.Changed("no-redact") {
+		rf.disabled = l.Start.NoRedact
+	}
+	if l.Start != nil && l.Start.RedactRules != "" && !flags.Changed("redact-rules") {
+		rf.rulesPath = l.Start.RedactRules
+	}
+	opts.redactor, err = rf.redactor()
+	if err != nil {
+		return err
+	}
+
+	// replay against a copy, since the session may have deleted rows, pinned
+	// commands, and so on
+	db, backend, dir, err := snapshotDatabase(databasePath, opts.backend)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	defer db.Close()
+	opts.backend = backend
+
+	queries, selection := replayLog(db, l, opts)
+
+	divergences := replay.Diff(l.Queries, queries)
+
+	if verbose {
+		for i, q := range queries {
+			fmt.Printf("query %d: %s\n", i+1, q)
+		}
+	}
+
+	for _, d := range divergences {
+		fmt.Printf("query %d differs:\n", d.Index+1)
+		fmt.Printf("  logged:   %s\n", describeQuery(d.Logged))
+		fmt.Printf("  replayed: %s\n", describeQuery(d.Replayed))
+	}
+
+	selectionDiffers := l.SelectionLogged && selection != l.Selection
+	if selectionDiffers {
+		fmt.Printf("selection differs:\n  logged:   %s\n  replayed: %s\n", describeSelection(l.Selection), describeSelection(selection))
+	}
+
+	fmt.Printf("replayed %d events from browser %s: %d queries, %d differ\n", len(l.Events), l.PID, len(queries), len(divergences))
+
+	if len(divergences) > 0 || selectionDiffers {
+		return errors.New("replay diverged from the log")
+	}
+
+	return nil
+}
+
+func describeQuery(q *replay.Query) string {
+	if q == nil {
+		return "(none)"
+	}
+	return q.String()
+}
+
+func describeSelection(rowid string) string {
+	if rowid == "" {
+		return "(nothing selected)"
+	}
+	return "rowid " + rowid
+}
+
+// snapshotDatabase copies the database at path into a new temporary
+// directory and opens the copy
+func snapshotDatabase(path string, backend query.Backend) (*sql.DB, query.Backend, string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, nil, "", err
+	}
+
+	// VACUUM INTO counts as a write as far as _query_only is concerned, so
+	// open the database read-only at the file level instead
+	src, err := sql.Open("sqlite3", history.DSN("file:"+path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer src.Close()
+
+	dir, err := os.MkdirTemp("", "histdb-replay-")
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	snapshotPath := filepath.Join(dir, "history.db")
+	if _, err := src.Exec("VACUUM INTO ?", snapshotPath); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, "", fmt.Errorf("unable to copy %s: %w", path, err)
+	}
+
+	db, backend, err := openDatabase(snapshotPath, backend)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, "", err
+	}
+
+	return db, backend, dir, nil
+}
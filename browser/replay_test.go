@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/replay"
+)
+
+func TestReplayKeyMsg(t *testing.T) {
+	for _, msg := range []tea.KeyMsg{
+		{Type: tea.KeyRunes, Runes: []rune("g")},
+		{Type: tea.KeyRunes, Runes: []rune("é")},
+		{Type: tea.KeyRunes, Runes: []rune("x"), Alt: true},
+		{Type: tea.KeyRunes, Runes: []rune("git status"), Paste: true},
+		{Type: tea.KeySpace},
+		{Type: tea.KeyEnter},
+		{Type: tea.KeyEsc},
+		{Type: tea.KeyBackspace},
+		{Type: tea.KeyCtrlC},
+		{Type: tea.KeyCtrlJ},
+		{Type: tea.KeyCtrlZ},
+		{Type: tea.KeyUp},
+		{Type: tea.KeyDown, Alt: true},
+		{Type: tea.KeyF1},
+		{Type: tea.KeyF12},
+	} {
+		got := replay.KeyMsg(msg.String())
+		require.Equal(t, msg.String(), got.String())
+		require.Equal(t, msg, got, msg.String())
+	}
+}
+
+const replayTestLog = `time=2024-01-05T00:00:00Z level=INFO msg="not JSON"
+{"time":"2024-01-05T00:00:00Z","level":"INFO","msg":"starting browser","browser_pid":100,"query":"git","query_cursor":-1,"session_id":"737207","horizon_timestamp":1641340800,"float_pins":true,"redact":false,"redact_rules":"/home/rob/.config/histdb/redact-rules"}
+{"time":"2024-01-05T00:00:00Z","level":"INFO","msg":"starting browser","browser_pid":200,"query":"","query_cursor":-1,"session_id":"1","horizon_timestamp":0,"float_pins":false}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"window resized","browser_pid":100,"width":120,"height":40}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"running SQL","browser_pid":100,"query":"SELECT 1","args":"[]interface {}{\"git\"}"}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"got keypress","browser_pid":200,"key":"x"}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"got keypress","browser_pid":100,"key":" "}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"got keypress","browser_pid":100,"key":"f9"}
+{"time":"2024-01-05T00:00:01.25Z","level":"DEBUG","msg":"got mouse event","browser_pid":100,"mouse":"ctrl+left press","x":10,"y":7,"button":1,"action":0,"alt":false,"ctrl":true,"shift":false}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"undo window passed","browser_pid":100,"deletion_id":1}
+{"time":"2024-01-05T00:00:00Z","level":"DEBUG","msg":"got keypress","browser_pid":100,"key":"enter"}
+{"time":"2024-01-05T00:00:00Z","level":"INFO","msg":"selected row","browser_pid":100,"rowid":"42","entry":"git status"}
+`
+
+func TestReplayParse(t *testing.T) {
+	l, err := replay.Parse(strings.NewReader(replayTestLog), "")
+	require.NoError(t, err)
+
+	require.Equal(t, "100", l.PID, "the first session in the log")
+	require.Equal(t, &replay.Start{Query: "git", QueryCursor: -1, SessionID: "737207", HorizonTimestamp: 1641340800, FloatPins: true, NoRedact: true, RedactRules: "/home/rob/.config/histdb/redact-rules"}, l.Start)
+	logged := time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, []replay.Event{
+		{Kind: replay.Resize, Time: logged, Width: 120, Height: 40},
+		{Kind: replay.KeyPress, Time: logged, Key: tea.KeyMsg{Type: tea.KeySpace}},
+		{Kind: replay.KeyPress, Time: logged, Key: tea.KeyMsg{Type: tea.KeyF9}},
+		{Kind: replay.Mouse, Time: logged.Add(1250 * time.Millisecond), Mouse: tea.MouseMsg{X: 10, Y: 7, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress, Ctrl: true}},
+		{Kind: replay.UndoWindowPassed, Time: logged, DeletionID: 1},
+		{Kind: replay.KeyPress, Time: logged, Key: tea.KeyMsg{Type: tea.KeyEnter}},
+	}, l.Events)
+	require.Equal(t, []replay.Query{{SQL: "SELECT 1", Args: `[]interface {}{"git"}`}}, l.Queries)
+	require.True(t, l.SelectionLogged)
+	require.Equal(t, "42", l.Selection)
+
+	l, err = replay.Parse(strings.NewReader(replayTestLog), "200")
+	require.NoError(t, err)
+	require.Equal(t, "1", l.Start.SessionID)
+	require.Len(t, l.Events, 1)
+	require.False(t, l.SelectionLogged)
+
+	_, err = replay.Parse(strings.NewReader(replayTestLog), "300")
+	require.Error(t, err)
+
+	_, err = replay.Parse(strings.NewReader("time=2024-01-05T00:00:00Z msg=text\n"), "")
+	require.Error(t, err)
+}
+
+func TestReplayRecorderAndDiff(t *testing.T) {
+	recorder := &replay.Recorder{}
+	logger := slog.New(recorder).With("browser_pid", 100)
+
+	logger.Debug("got keypress", "key", "g")
+	logger.Debug("running SQL", "query", "SELECT 1", "args", "[]interface {}{}")
+	logger.Debug("running SQL", "query", "SELECT 2", "args", "[]interface {}{}")
+
+	require.Equal(t, []replay.Query{
+		{SQL: "SELECT 1", Args: "[]interface {}{}"},
+		{SQL: "SELECT 2", Args: "[]interface {}{}"},
+	}, recorder.Queries)
+
+	require.Empty(t, replay.Diff(recorder.Queries, recorder.Queries))
+
+	logged := []replay.Query{recorder.Queries[0], {SQL: "SELECT 3", Args: "[]interface {}{}"}, recorder.Queries[1]}
+	divergences := replay.Diff(logged, recorder.Queries)
+	require.Len(t, divergences, 2)
+	require.Equal(t, 1, divergences[0].Index)
+	require.Equal(t, "SELECT 3", divergences[0].Logged.SQL)
+	require.Equal(t, "SELECT 2", divergences[0].Replayed.SQL)
+	require.Equal(t, 2, divergences[1].Index)
+	require.Nil(t, divergences[1].Replayed)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/output"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+func runSearch(args []string) error {
+	ff := filterFlags{}
+	bf := backendFlags{}
+	outputFormatName := "table"
+	databasePath := defaultDatabasePath()
+	f := query.Filter{}
+
+	flags := pflag.NewFlagSet("search", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser search [flags] [query...]")
+		flags.PrintDefaults()
+	}
+	ff.register(flags, &f)
+	bf.register(flags)
+	flags.StringSliceVar(&f.Columns, "columns", []string{"timestamp"}, "The columns to output alongside the entry ("+strings.Join(query.Columns, ", ")+")")
+	flags.IntVar(&f.Limit, "limit", query.DefaultLimit, "The maximum number of results")
+	flags.StringVar(&outputFormatName, "output", outputFormatName, "The format to output results in (table, json, tsv, raw, nul, shell)")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to search (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		f.Query = strings.TrimSpace(f.Query + " " + strings.Join(flags.Args(), " "))
+	}
+
+	if err := ff.apply(&f); err != nil {
+		return err
+	}
+
+	var outputFormat output.Format
+	if outputFormatName != "table" {
+		var err error
+		outputFormat, err = output.ParseFormat(outputFormatName)
+		if err != nil {
+			return err
+		}
+	}
+
+	backend, err := bf.backend()
+	if err != nil {
+		return err
+	}
+
+	db, backend, err := openDatabase(databasePath, backend)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	f.Backend = backend
+
+	_, rows, err := store.NewSQLite(db, backend).Search(f)
+	if err != nil {
+		return err
+	}
+
+	fields := append(append([]string{}, f.Columns...), "entry")
+
+	if outputFormat == "" {
+		return output.WriteTable(os.Stdout, fields, rows)
+	}
+
+	if outputFormat.IncludesAllFields() {
+		// the machine-readable formats should identify rows and carry their status too
+		fields = append([]string{"rowid"}, append(fields, "exit_status")...)
+	}
+
+	for _, row := range rows {
+		if err := output.WriteRow(os.Stdout, outputFormat, fields, row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/shellinit"
+)
+
+func runInit(args []string) error {
+	opts := shellinit.Options{}
+
+	flags := pflag.NewFlagSet("init", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: histdb-browser init [flags] %s\n", strings.Join(shellinit.Shells, "|"))
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&opts.PrintOID, "print-oid", false, "Have the widget store the selected row's OID in $HISTDB_BROWSER_OID rather than inserting its entry")
+	flags.StringVar(&opts.InsertMode, "insert-mode", shellinit.InsertReplace, "Whether the selection replaces the command line, or just the word before the cursor, which is what it searches for (replace, append)")
+	flags.StringVar(&opts.Executable, "executable", "", "The path to histdb-browser the widget should run (defaults to this binary)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one shell name")
+	}
+
+	if opts.Executable == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		opts.Executable = exe
+	}
+
+	return shellinit.Write(os.Stdout, flags.Arg(0), opts)
+}
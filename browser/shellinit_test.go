@@ -0,0 +1,53 @@
+package main_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/charmbracelet/x/exp/golden"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/shellinit"
+)
+
+func TestShellInitGolden(t *testing.T) {
+	for _, shell := range shellinit.Shells {
+		variants := map[string]shellinit.Options{
+			shell:                {},
+			shell + "-print-oid": {PrintOID: true},
+			shell + "-append":    {InsertMode: shellinit.InsertAppend},
+		}
+
+		for name, opts := range variants {
+			t.Run(name, func(t *testing.T) {
+				var b bytes.Buffer
+
+				opts.Executable = "/usr/local/bin/histdb-browser"
+				err := shellinit.Write(&b, shell, opts)
+				require.NoError(t, err)
+
+				golden.RequireEqual(t, b.Bytes())
+
+				// if the shell is around, make sure it at least parses the script
+				shellPath, err := exec.LookPath(shell)
+				if err != nil {
+					return
+				}
+				script := filepath.Join(t.TempDir(), name)
+				require.NoError(t, os.WriteFile(script, b.Bytes(), 0o644))
+				out, err := exec.Command(shellPath, "-n", script).CombinedOutput()
+				require.NoError(t, err, string(out))
+			})
+		}
+	}
+}
+
+func TestShellInitUnsupportedShell(t *testing.T) {
+	var b bytes.Buffer
+
+	require.Error(t, shellinit.Write(&b, "tcsh", shellinit.Options{Executable: "histdb-browser"}))
+	require.Error(t, shellinit.Write(&b, "zsh", shellinit.Options{Executable: "histdb-browser", InsertMode: "prepend"}))
+}
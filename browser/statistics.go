@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/stats"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+func runStats(args []string) error {
+	ff := filterFlags{}
+	bf := backendFlags{}
+	width := 80
+	databasePath := defaultDatabasePath()
+	f := query.Filter{}
+
+	flags := pflag.NewFlagSet("stats", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: histdb-browser stats [flags] [query...]")
+		flags.PrintDefaults()
+	}
+	ff.register(flags, &f)
+	bf.register(flags)
+	flags.IntVar(&width, "width", width, "The width to lay the statistics out in")
+	flags.StringVar(&databasePath, "database", databasePath, "The history database to analyze (defaults to $HISTDB_PATH)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		f.Query = strings.TrimSpace(f.Query + " " + strings.Join(flags.Args(), " "))
+	}
+
+	if width < stats.MinWidth {
+		return fmt.Errorf("--width must be at least %d", stats.MinWidth)
+	}
+
+	if err := ff.apply(&f); err != nil {
+		return err
+	}
+
+	backend, err := bf.backend()
+	if err != nil {
+		return err
+	}
+
+	db, backend, err := openDatabase(databasePath, backend)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	f.Backend = backend
+
+	s, err := store.NewSQLite(db, backend).Stats(f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(s.Render(width))
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package main_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/stats"
+)
+
+func statsRow(entry string, timestamp time.Time, duration time.Duration, exitStatus int) stats.Row {
+	return stats.Row{
+		Entry:      entry,
+		Cwd:        "/home/rob",
+		Timestamp:  timestamp,
+		Duration:   duration,
+		ExitStatus: &exitStatus,
+	}
+}
+
+func TestStatsAccumulator(t *testing.T) {
+	day := time.Date(2022, 1, 1, 9, 30, 0, 0, time.Local)
+
+	a := stats.NewAccumulator()
+	for i := range 6 {
+		exitStatus := 0
+		if i%2 == 0 {
+			exitStatus = 1
+		}
+		a.Add(statsRow(fmt.Sprintf("make test%d", i), day, time.Duration(i)*time.Second, exitStatus))
+	}
+	a.Add(statsRow("sudo vim /etc/hosts", day.AddDate(0, 0, 2).Add(5*time.Hour), time.Hour, 0))
+	a.Add(statsRow("FOO=bar git status", day.AddDate(0, 0, 2), -1, 148))
+
+	s := a.Stats()
+
+	require.Equal(t, 8, s.Total)
+	require.Equal(t, []stats.Count{{Name: "make", Count: 6}, {Name: "git", Count: 1}, {Name: "vim", Count: 1}}, s.TopCommands)
+	require.Equal(t, []stats.Count{{Name: "/home/rob", Count: 8}}, s.TopDirectories)
+
+	// days without any commands are still included
+	require.Equal(t, []stats.Count{{Name: "2022-01-01", Count: 6}, {Name: "2022-01-02", Count: 0}, {Name: "2022-01-03", Count: 2}}, s.PerDay)
+	require.Equal(t, 7, s.PerHour[9])
+	require.Equal(t, 1, s.PerHour[14])
+
+	// git was suspended rather than failing, and only ran once anyway
+	require.Equal(t, []stats.FailureRate{{Command: "make", Runs: 6, Failures: 3}}, s.FailureRates)
+
+	require.Equal(t, "sudo vim /etc/hosts", s.LongestRunning[0].Entry)
+	require.Len(t, s.LongestRunning, 7, "rows without a duration aren't included")
+}
+
+func TestStatsCommandName(t *testing.T) {
+	require.Equal(t, "git", stats.CommandName("git commit -m 'x'"))
+	require.Equal(t, "apt", stats.CommandName("sudo apt install zsh"))
+	require.Equal(t, "make", stats.CommandName("CC=clang CFLAGS=-O2 make"))
+	require.Equal(t, "", stats.CommandName("   "))
+}
+
+func TestStatsSparkline(t *testing.T) {
+	require.Equal(t, "▁ ▄█", stats.Sparkline([]int{1, 0, 4, 8}))
+	require.Equal(t, "  ", stats.Sparkline([]int{0, 0}))
+}
+
+func TestStatsBar(t *testing.T) {
+	require.Equal(t, "██████████", stats.Bar(10, 10, 10))
+	require.Equal(t, "█████", stats.Bar(5, 10, 10))
+	require.Equal(t, "▌", stats.Bar(1, 20, 10))
+	require.Equal(t, "", stats.Bar(0, 10, 10))
+}
+
+func TestStatsRender(t *testing.T) {
+	a := stats.NewAccumulator()
+	a.Add(statsRow("ls -l", time.Date(2022, 1, 1, 9, 30, 0, 0, time.Local), 2*time.Second, 0))
+
+	out := a.Stats().Render(80)
+
+	for _, section := range []string{"Top commands", "Top directories", "Commands per hour", "Commands per day", "Failure rates", "Longest-running commands"} {
+		require.Contains(t, out, section)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		require.LessOrEqual(t, len([]rune(line)), 80, line)
+	}
+}
+
+func TestStatsRenderNarrow(t *testing.T) {
+	a := stats.NewAccumulator()
+	for i := range 100 {
+		a.Add(statsRow("some-rather-long-command-name --with-arguments", time.Date(2022, 1, 1+i, 9, 30, 0, 0, time.Local), time.Duration(i)*time.Second, i%2))
+	}
+	s := a.Stats()
+
+	// before the browser knows how wide the terminal is, it's zero
+	for _, width := range []int{0, 2, stats.MinWidth} {
+		for _, line := range strings.Split(s.Render(width), "\n") {
+			require.LessOrEqual(t, len([]rune(line)), stats.MinWidth, line)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/histfile"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/output"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+func storeTestRows() []history.Row {
+	rows := make([]history.Row, 0)
+	for i, r := range []history.Row{
+		{SessionID: "1", Cwd: "/home/rob/histdb", Entry: "git commit -m 'Fix the browser'", ExitStatus: ptr(int64(0)), Duration: ptr(int64(2))},
+		{SessionID: "1", Cwd: "/home/rob/histdb", Entry: "git push origin", ExitStatus: ptr(int64(1)), Duration: ptr(int64(4))},
+		{SessionID: "2", Cwd: "/tmp", Entry: "make test"},
+		{SessionID: "2", Cwd: "/tmp", Entry: "vim Makefile", ExitStatus: ptr(int64(148))},
+		{SessionID: "3", Cwd: "/home/rob", Entry: "GIT status", ExitStatus: ptr(int64(0))},
+	} {
+		r.Hostname = "host1"
+		if i == 4 {
+			r.Hostname = "host2"
+		}
+		r.Timestamp = time.Unix(1641340800+int64(i)*3600, 0)
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func TestStoresAgree(t *testing.T) {
+	db, _ := testDB(t)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	sqliteStore := store.NewSQLite(db, query.HistoryTableBackend{})
+	memoryStore := store.NewMemory()
+
+	for _, r := range storeTestRows() {
+		sqliteRowid, err := sqliteStore.Insert(r)
+		require.NoError(t, err)
+		memoryRowid, err := memoryStore.Insert(r)
+		require.NoError(t, err)
+		require.Equal(t, sqliteRowid, memoryRowid)
+	}
+
+	for name, f := range map[string]query.Filter{
+		"everything": {ShowFailedCommands: true, ShowGlobalCommands: true},
+		"words":      {Query: "git   origin", ShowFailedCommands: true, ShowGlobalCommands: true},
+		"case":       {Query: "git", Columns: []string{"cwd"}, ShowFailedCommands: true, ShowGlobalCommands: true},
+		"failed":     {ShowGlobalCommands: true, Columns: []string{"session_id", "hostname"}},
+		"horizon":    {ShowFailedCommands: true, HorizonTimestamp: time.Unix(1641340800+3600, 0), SessionID: "3", Columns: []string{"timestamp", "session_id"}},
+		"scope":      {ShowFailedCommands: true, ShowGlobalCommands: true, Since: time.Unix(1641340800+3600, 0), Until: time.Unix(1641340800+4*3600, 0), Hostname: "host1"},
+		"exclude":    {ShowFailedCommands: true, ShowGlobalCommands: true, ExcludeRowids: []int64{1, 3}, Limit: 2},
+		"cwd":        {Query: "git cwd:HISTDB", ShowFailedCommands: true, ShowGlobalCommands: true},
+		"timestamp":  {Query: `timestamp:"since 2022-01-01"`, ShowFailedCommands: true, ShowGlobalCommands: true},
+		"bad-time":   {Query: `timestamp:""`, ShowFailedCommands: true, ShowGlobalCommands: true},
+		"tags":       {Query: "tag:release", ShowFailedCommands: true, ShowGlobalCommands: true, Columns: []string{"tags", "note"}},
+	} {
+		sqliteColumns, sqliteRows, err := sqliteStore.Search(f)
+		require.NoError(t, err, name)
+		memoryColumns, memoryRows, err := memoryStore.Search(f)
+		require.NoError(t, err, name)
+
+		require.Equal(t, sqliteColumns, memoryColumns, name)
+		require.Equal(t, sqliteRows, memoryRows, name)
+
+		sqliteStats, err := sqliteStore.Stats(f)
+		require.NoError(t, err, name)
+		memoryStats, err := memoryStore.Stats(f)
+		require.NoError(t, err, name)
+		require.Equal(t, sqliteStats, memoryStats, name)
+	}
+}
+
+func TestStoreChanges(t *testing.T) {
+	db, _ := testDB(t)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	for name, s := range map[string]store.HistoryStore{
+		"sqlite": store.NewSQLite(db, query.HistoryTableBackend{}),
+		"memory": store.NewMemory(),
+	} {
+		rowids := make([]int64, 0)
+		for _, r := range storeTestRows() {
+			rowid, err := s.Insert(r)
+			require.NoError(t, err, name)
+			rowids = append(rowids, rowid)
+		}
+
+		session, err := s.Session("2")
+		require.NoError(t, err, name)
+		require.Len(t, session, 2, name)
+		require.Equal(t, "make test", session[0].Entry, name)
+		require.Equal(t, rowids[3], session[1].Rowid, name)
+
+		deleted, err := s.Delete([]int64{rowids[2], rowids[4] + 100})
+		require.NoError(t, err, name)
+		require.Equal(t, 1, deleted, name)
+
+		session, err = s.Session("2")
+		require.NoError(t, err, name)
+		require.Len(t, session, 1, name)
+		require.Equal(t, "vim Makefile", session[0].Entry, name)
+	}
+}
+
+func TestStoreSecureDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := history.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rowids := make([]int64, 0)
+	for i, entry := range []string{"make test", "curl -H 'Authorization: hunter2hunter2'", "make test", "ls"} {
+		rowid, err := history.Insert(db, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+		require.NoError(t, err)
+		rowids = append(rowids, rowid)
+	}
+
+	// the index has a copy of the command too, if SQLite has FTS5
+	if fts.Create(db) == nil {
+		_, err = fts.Refresh(db)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, pins.Pin(db, "make test", rowids[2], time.Unix(1641340900, 0)))
+	require.NoError(t, pins.Pin(db, "curl -H 'Authorization: hunter2hunter2'", rowids[1], time.Unix(1641340900, 0)))
+
+	deleted, err := store.NewSQLite(db, query.HistoryTableBackend{}).Delete(rowids[1:3])
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+
+	// the pin of the command that's gone goes with it, and the other one moves
+	// to the row that's left
+	pinned := make(map[string]int64)
+	rows, err := db.Query("SELECT entry, source_rowid FROM histdb_pins")
+	require.NoError(t, err)
+	for rows.Next() {
+		var entry string
+		var sourceRowid int64
+		require.NoError(t, rows.Scan(&entry, &sourceRowid))
+		pinned[entry] = sourceRowid
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, map[string]int64{"make test": rowids[0]}, pinned)
+
+	// and nothing's left of it on disk
+	require.NoError(t, db.Close())
+	for _, suffix := range []string{"", "-wal"} {
+		contents, err := os.ReadFile(path + suffix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(contents, []byte("hunter2")), "history.db%s still has the deleted command", suffix)
+	}
+}
+
+func TestHistoryFileSource(t *testing.T) {
+	path := filepath.Join("testdata", "import", "bash_history")
+
+	source, err := store.LoadHistoryFile(path, "")
+	require.NoError(t, err)
+
+	// the same commands, imported into a database
+	db, _ := testDB(t)
+	require.NoError(t, annotation.EnsureSchema(db))
+
+	s, err := os.Stat(path)
+	require.NoError(t, err)
+	hostname, _ := os.Hostname()
+	fileRows := histfile.Rows(parseFixture(t, "bash", "bash_history"), history.Row{Hostname: hostname, SessionID: "file:" + path})
+	histfile.DateUndated(fileRows, s.ModTime())
+
+	imported := store.NewSQLite(db, query.HistoryTableBackend{})
+	for _, r := range fileRows {
+		_, err := imported.Insert(r)
+		require.NoError(t, err)
+	}
+
+	everything := query.Filter{Columns: []string{"timestamp", "session_id"}, ShowFailedCommands: true, ShowGlobalCommands: true}
+
+	expectedColumns, expectedRows, err := imported.Search(everything)
+	require.NoError(t, err)
+	require.Len(t, expectedRows, 3)
+
+	// history files don't know about exit statuses or sessions, so hiding
+	// failed commands or other sessions' commands shouldn't hide anything
+	for name, f := range map[string]query.Filter{
+		"everything":  everything,
+		"hide-failed": {Columns: everything.Columns, ShowGlobalCommands: true},
+		"hide-global": {Columns: everything.Columns, ShowFailedCommands: true, HorizonTimestamp: time.Unix(1641340800, 0), SessionID: "737207"},
+	} {
+		columns, rows, err := source.Search(f)
+		require.NoError(t, err, name)
+		require.Equal(t, expectedColumns, columns, name)
+		require.Equal(t, expectedRows, rows, name)
+
+		s, err := source.Stats(f)
+		require.NoError(t, err, name)
+		require.Equal(t, 3, s.Total, name)
+	}
+
+	// searching works the same as it does against the database
+	expectedColumns, expectedRows, err = imported.Search(query.Filter{Query: "STATUS", ShowFailedCommands: true, ShowGlobalCommands: true})
+	require.NoError(t, err)
+	columns, rows, err := source.Search(query.Filter{Query: "STATUS"})
+	require.NoError(t, err)
+	require.Equal(t, expectedColumns, columns)
+	require.Equal(t, expectedRows, rows)
+
+	row, found := source.Get(3, output.Fields)
+	require.True(t, found)
+	require.Equal(t, "git status", row["entry"])
+	require.Equal(t, "", row["exit_status"])
+
+	require.True(t, store.IsReadOnly(source))
+	require.False(t, store.IsReadOnly(imported))
+	_, err = source.Delete([]int64{1})
+	require.ErrorIs(t, err, store.ErrReadOnly)
+	_, err = source.Insert(history.Row{Entry: "ls"})
+	require.ErrorIs(t, err, store.ErrReadOnly)
+
+	// without timestamps, commands keep the order they were run in
+	source, err = store.LoadHistoryFile(filepath.Join("testdata", "import", "bash_history_untimestamped"), "bash")
+	require.NoError(t, err)
+	_, rows, err = source.Search(query.Filter{Columns: []string{"timestamp"}})
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, []string{"pwd", "cd /tmp", "ls -l"}, []string{rows[0]["entry"], rows[1]["entry"], rows[2]["entry"]})
+	require.Equal(t, "", rows[0]["timestamp"], "they aren't given made-up timestamps")
+
+	// and aren't picked up by time filters
+	_, rows, err = source.Search(query.Filter{Since: time.Unix(1641340800, 0)})
+	require.NoError(t, err)
+	require.Empty(t, rows)
+
+	_, err = store.LoadHistoryFile(filepath.Join("testdata", "import", "unknown"), "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unable to guess")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// a subcommand receives the arguments following its name
+type subcommand func(args []string) error
+
+var subcommands = map[string]subcommand{
+	"decrypt":    runDecrypt,
+	"export":     runExport,
+	"import":     runImport,
+	"index":      runIndex,
+	"init":       runInit,
+	"keygen":     runKeygen,
+	"merge":      runMerge,
+	"noteworthy": runNoteworthy,
+	"record":     runRecord,
+	"redact":     runRedact,
+	"replay":     runReplay,
+	"search":     runSearch,
+	"stats":      runStats,
+	"sync":       runSync,
+}
+
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runSubcommand runs the subcommand named by the first argument, if any,
+// reporting whether it found one
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	run, found := subcommands[args[0]]
+	if !found {
+		return false
+	}
+
+	if err := run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	return true
+}
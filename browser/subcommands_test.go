@@ -0,0 +1,183 @@
+package main
+
+// Like the model's tests, these live in package main rather than main_test,
+// since they run the subcommands directly.
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/annotation"
+	"hoelz.ro/histdb-browser/internal/fts"
+	"hoelz.ro/histdb-browser/internal/history"
+	"hoelz.ro/histdb-browser/internal/pins"
+	"hoelz.ro/histdb-browser/internal/query"
+	"hoelz.ro/histdb-browser/internal/replay"
+	"hoelz.ro/histdb-browser/internal/store"
+)
+
+// withStdio runs fn with standard input reading input, returning what it
+// wrote to standard output
+func withStdio(t *testing.T, input string, fn func()) string {
+	t.Helper()
+
+	stdin, err := os.CreateTemp(t.TempDir(), "stdin")
+	require.NoError(t, err)
+	defer stdin.Close()
+	_, err = stdin.WriteString(input)
+	require.NoError(t, err)
+	_, err = stdin.Seek(0, 0)
+	require.NoError(t, err)
+
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	require.NoError(t, err)
+	defer stdout.Close()
+
+	oldStdin, oldStdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	defer func() { os.Stdin, os.Stdout = oldStdin, oldStdout }()
+
+	fn()
+
+	output, err := os.ReadFile(stdout.Name())
+	require.NoError(t, err)
+	return string(output)
+}
+
+func TestRunRedactApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := history.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	rowids := make([]int64, 0)
+	for i, entry := range []string{"export API_KEY=0xdeadbeef", "ls"} {
+		rowid, err := history.Insert(db, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+		require.NoError(t, err)
+		rowids = append(rowids, rowid)
+	}
+
+	indexed := fts.Create(db) == nil
+	if indexed {
+		_, err = fts.Refresh(db)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, pins.Pin(db, "export API_KEY=0xdeadbeef", rowids[0], time.Unix(1641340900, 0)))
+	require.NoError(t, annotation.Set(db, rowids[1], annotation.Parse("db_password=0xdeadbeef #staging")))
+
+	args := []string{"--database", path, "--redact-rules", filepath.Join(t.TempDir(), "no-rules"), "--apply"}
+
+	entry := func() string {
+		var entry string
+		require.NoError(t, db.QueryRow("SELECT entry FROM history WHERE rowid = ?", rowids[0]).Scan(&entry))
+		return entry
+	}
+
+	// nothing changes unless the prompt's answered yes
+	withStdio(t, "n\n", func() {
+		require.EqualError(t, runRedact(args), "not confirmed")
+	})
+	require.Equal(t, "export API_KEY=0xdeadbeef", entry())
+
+	withStdio(t, "", func() {
+		require.NoError(t, runRedact(append(args, "--yes")))
+	})
+	require.Equal(t, "export API_KEY=[REDACTED]", entry())
+
+	pinned, err := pins.Entries(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"export API_KEY=[REDACTED]"}, pinned)
+
+	a, err := annotation.Get(db, rowids[1])
+	require.NoError(t, err)
+	require.Equal(t, annotation.Parse("db_password=[REDACTED] #staging"), a)
+
+	if indexed {
+		// the index finds the redacted command, and not the secret
+		var matches int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM histdb_fts WHERE histdb_fts MATCH '"REDACTED"'`).Scan(&matches))
+		require.Equal(t, 1, matches)
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM histdb_fts WHERE histdb_fts MATCH '"deadbeef"'`).Scan(&matches))
+		require.Equal(t, 0, matches)
+	}
+
+	// and nothing's left of the secret on disk
+	require.NoError(t, db.Close())
+	for _, suffix := range []string{"", "-wal"} {
+		contents, err := os.ReadFile(path + suffix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(contents, []byte("deadbeef")), "history.db%s still has the secret", suffix)
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := history.Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i, entry := range []string{"git status", "make test", "git push", "export API_KEY=0xdeadbeef"} {
+		_, err := history.Insert(db, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340800+int64(i), 0), Entry: entry})
+		require.NoError(t, err)
+	}
+
+	// run the browser the way main does, logging to a JSON log
+	browserDB, backend, err := openDatabase(path, query.HistoryTableBackend{})
+	require.NoError(t, err)
+	defer browserDB.Close()
+
+	var logged bytes.Buffer
+	previousLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug})).With("browser_pid", 100))
+
+	rf := redactFlags{rulesPath: filepath.Join(t.TempDir(), "no-rules"), disabled: true}
+	opts := modelOptions{initialQuery: "g", initialQueryCursor: -1, sessionID: "2", horizonTimestamp: time.Unix(1641340900, 0), backend: backend}
+	logStart(opts, "go", rf, "")
+
+	var m tea.Model = newModel(store.NewSQLite(browserDB, backend), browserDB, opts)
+	m.Init()
+	for _, msg := range []tea.Msg{
+		tea.WindowSizeMsg{Width: 120, Height: 30},
+		replay.KeyMsg("i"),
+		replay.KeyMsg("t"),
+		replay.KeyMsg("down"),
+		replay.KeyMsg("enter"),
+	} {
+		m, _ = m.Update(msg)
+	}
+	slog.SetDefault(previousLogger)
+	require.Equal(t, "git status", m.(*model).selection["raw_entry"])
+
+	logPath := filepath.Join(t.TempDir(), "browser.log")
+	require.NoError(t, os.WriteFile(logPath, logged.Bytes(), 0o600))
+
+	// replaying it runs the same queries and selects the same row
+	output := withStdio(t, "", func() {
+		err = runReplay([]string{"--database", path, logPath})
+	})
+	require.NoError(t, err, output)
+	require.Contains(t, output, "replayed 5 events from browser 100:")
+	require.Contains(t, output, ", 0 differ")
+
+	// unless the database has changed since
+	_, err = history.Insert(db, history.Row{Hostname: "host1", SessionID: "1", Timestamp: time.Unix(1641340804, 0), Entry: "git log"})
+	require.NoError(t, err)
+
+	output = withStdio(t, "", func() {
+		err = runReplay([]string{"--database", path, logPath})
+	})
+	require.EqualError(t, err, "replay diverged from the log", output)
+	require.Contains(t, output, ", 0 differ")
+	require.Contains(t, output, "selection differs:")
+}
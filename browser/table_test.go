@@ -1,7 +1,10 @@
 package main_test
 
 import (
+	"fmt"
 	"io"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,13 +31,30 @@ func (m *testModel) View() string {
 	return m.t.View()
 }
 
-// XXX highlighted row should always be visible
-// XXX header should always be visible
-// XXX filtering rows further should do XYZ wrt. the highlighted row
-// XXX all parts of a multi-line highlighted row should be visible
-// XXX handle case where the terminal height is less than the number of lines in a multi-line entry
-// XXX handle case where the entry is a single line, but it's a loooooong one
-// XXX handle case where there are no rows
+// renderTable returns what tbl looks like in a terminal of the given size
+func renderTable(t *testing.T, tbl *table.Table, width, height int) string {
+	tm := teatest.NewTestModel(t, &testModel{t: tbl}, teatest.WithInitialTermSize(width, height))
+	output, err := io.ReadAll(tm.FinalOutput(t, teatest.WithFinalTimeout(time.Second*10)))
+	require.NoError(t, err)
+
+	return string(output)
+}
+
+func numberedRows(n int) []table.Row {
+	rows := make([]table.Row, n)
+	for i := range rows {
+		rows[i] = table.NewRow(map[string]any{
+			"id":    i,
+			"entry": fmt.Sprintf("entry number %d.", i),
+		})
+	}
+	return rows
+}
+
+var testColumns = []table.Column{
+	table.NewColumn("id", "id", 5),
+	table.NewFlexColumn("entry", "entry", 1),
+}
 
 func TestTableBasic(t *testing.T) {
 	testWidth := 300
@@ -147,3 +167,391 @@ func TestTableTooSmall(t *testing.T) {
 
 	// XXX assert that "one" is highlighted?
 }
+
+// bodyLines returns the lines of a table's view below its header, given how
+// tall its viewport is, with any blank padding at the end dropped
+func bodyLines(view string, viewportHeight int) []string {
+	lines := strings.Split(view, "\n")
+	lines = lines[len(lines)-viewportHeight:]
+
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func TestTableScrolling(t *testing.T) {
+	testWidth := 80
+	testHeight := 12
+
+	columns := []table.Column{
+		table.NewColumn("id", "id", 5),
+		table.NewFlexColumn("entry", "entry", 1),
+	}
+
+	rows := make([]table.Row, 30)
+	for i := range rows {
+		// a mix of single and multi-line rows, some taller than the viewport
+		lines := make([]string, []int{1, 1, 3, 1, 2, 10}[i%6])
+		for j := range lines {
+			lines[j] = fmt.Sprintf("row %d line %d", i, j)
+		}
+
+		rows[i] = table.NewRow(map[string]any{
+			"id":    i,
+			"entry": strings.Join(lines, "\n"),
+		})
+	}
+
+	tbl := table.New(columns).
+		WithRows(rows).
+		WithTargetWidth(testWidth).
+		WithTargetHeight(testHeight)
+	viewportHeight := testHeight - 4
+
+	// the same table, tall enough to show every row
+	full := tbl.WithTargetHeight(1000)
+
+	check := func(step string) {
+		highlighted := tbl.HighlightedRow().Data["entry"].(string)
+		fullBody := bodyLines(full.View(), 1000-4)
+		body := bodyLines(tbl.View(), viewportHeight)
+
+		require.NotEmpty(t, body, step)
+		require.LessOrEqual(t, len(body), viewportHeight, step)
+
+		// what's shown is a slice of the whole table, besides any lines saying
+		// how much of a tall row is hidden...
+		body = slices.DeleteFunc(body, func(line string) bool {
+			return strings.Contains(line, " more line")
+		})
+		offset := slices.Index(fullBody, body[0])
+		require.NotEqual(t, -1, offset, step)
+		require.Equal(t, fullBody[offset:offset+len(body)], body, step)
+
+		// ...which includes the highlighted row, or as much of it as fits - a
+		// row too tall for the viewport gives up one of its lines to saying
+		// how many more are hidden, hence viewportHeight-1
+		highlightedLines := strings.Split(highlighted, "\n")
+		for _, line := range highlightedLines[:min(len(highlightedLines), viewportHeight-1)] {
+			require.Contains(t, strings.Join(body, "\n"), line, step)
+		}
+	}
+
+	check("initially")
+
+	for i := range len(rows) - 1 {
+		tbl = tbl.MoveHighlight(1)
+		full = full.MoveHighlight(1)
+		check(fmt.Sprintf("moving down to %d", i+1))
+	}
+
+	// the highlight stops at the last row
+	tbl = tbl.MoveHighlight(1)
+	full = full.MoveHighlight(1)
+	require.Equal(t, len(rows)-1, tbl.GetHighlightedRowIndex())
+	check("past the end")
+
+	for i := len(rows) - 1; i > 0; i-- {
+		tbl = tbl.MoveHighlight(-1)
+		full = full.MoveHighlight(-1)
+		check(fmt.Sprintf("moving up to %d", i-1))
+	}
+
+	// jumping a long way at once
+	tbl = tbl.MoveHighlight(20)
+	full = full.MoveHighlight(20)
+	check("jumping down")
+
+	tbl = tbl.MoveHighlight(-15)
+	full = full.MoveHighlight(-15)
+	check("jumping up")
+
+	// fewer rows than the viewport is scrolled past
+	tbl = tbl.WithRows(rows[:2])
+	full = full.WithRows(rows[:2])
+	check("after the rows shrink")
+}
+
+func TestTableHighlightedRowVisible(t *testing.T) {
+	tbl := table.New(testColumns).
+		WithRows(numberedRows(50)).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(30)
+
+	output := renderTable(t, tbl, 80, 10)
+	require.Contains(t, output, "entry number 30.")
+	require.NotContains(t, output, "entry number 0.")
+
+	// moving back up scrolls back up
+	output = renderTable(t, tbl.MoveHighlight(-25), 80, 10)
+	require.Contains(t, output, "entry number 5.")
+	require.NotContains(t, output, "entry number 30.")
+
+	// as does the viewport shrinking
+	output = renderTable(t, tbl.WithTargetHeight(6), 80, 6)
+	require.Contains(t, output, "entry number 30.")
+}
+
+func TestTableHeaderVisible(t *testing.T) {
+	tbl := table.New(testColumns).
+		WithRows(numberedRows(50)).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(40)
+
+	// the first line with anything on it is the header, ahead of the rows
+	header := func(view string) []string {
+		for _, line := range strings.Split(view, "\n") {
+			if strings.TrimSpace(line) != "" {
+				return strings.Fields(line)
+			}
+		}
+		return nil
+	}
+
+	output := renderTable(t, tbl, 80, 10)
+	require.Contains(t, output, "entry number 40.")
+	require.Equal(t, []string{"id", "entry"}, header(tbl.View()))
+
+	// even with no room for anything else
+	tbl = tbl.WithTargetHeight(2)
+	require.Equal(t, []string{"id", "entry"}, header(tbl.View()))
+	require.Contains(t, tbl.View(), "entry number 40.")
+}
+
+func TestTableHighlightedRowVisibleAfterFiltering(t *testing.T) {
+	rows := numberedRows(50)
+
+	tbl := table.New(testColumns).
+		WithRows(rows).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(45)
+
+	// the highlight stays where it was, as long as there are enough rows...
+	filtered := tbl.WithRows(rows[2:])
+	require.Equal(t, 45, filtered.GetHighlightedRowIndex())
+	require.Contains(t, renderTable(t, filtered, 80, 10), "entry number 47.")
+
+	// ...otherwise it moves to the last row, which is brought into view
+	filtered = tbl.WithRows(rows[:10])
+	require.Equal(t, 9, filtered.GetHighlightedRowIndex())
+	output := renderTable(t, filtered, 80, 10)
+	require.Contains(t, output, "entry number 9.")
+	require.Contains(t, output, "entry number 4.")
+}
+
+func TestTableMultilineHighlightVisible(t *testing.T) {
+	rows := numberedRows(20)
+	rows[10] = table.NewRow(map[string]any{
+		"id":    10,
+		"entry": "for i in $(seq 3); do\n  echo $i\ndone",
+	})
+
+	tbl := table.New(testColumns).
+		WithRows(rows).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(10)
+
+	output := renderTable(t, tbl, 80, 10)
+	require.Contains(t, output, "for i in $(seq 3); do")
+	require.Contains(t, output, "echo $i")
+	require.Contains(t, output, "done")
+
+	// even when the row grows after the highlight has moved to it, like the
+	// browser's rows do when it shows the highlighted entry in full
+	rows[12].Data["entry"] = "line one\nline two\nline three\nline four"
+	output = renderTable(t, tbl.MoveHighlight(2), 80, 10)
+	require.Contains(t, output, "line one")
+	require.Contains(t, output, "line four")
+}
+
+func TestTableHighlightTallerThanViewport(t *testing.T) {
+	lines := make([]string, 20)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("echo line %d.", i)
+	}
+
+	rows := numberedRows(5)
+	rows[2] = table.NewRow(map[string]any{
+		"id":    2,
+		"entry": strings.Join(lines, "\n"),
+	})
+
+	tbl := table.New(testColumns).
+		WithRows(rows).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(2)
+
+	// the start of the row is shown, along with how much more there is
+	output := renderTable(t, tbl, 80, 10)
+	require.Contains(t, output, "echo line 0.")
+	require.NotContains(t, output, "echo line 19.")
+	require.Contains(t, output, "more lines")
+
+	// and the rest can be scrolled to
+	output = renderTable(t, tbl.ScrollHighlightedRow(100), 80, 10)
+	require.Contains(t, output, "echo line 19.")
+	require.NotContains(t, output, "echo line 0.")
+	require.Contains(t, output, "more lines")
+
+	// moving on to the next row leaves it
+	output = renderTable(t, tbl.ScrollHighlightedRow(100).MoveHighlight(1), 80, 10)
+	require.Contains(t, output, "entry number 3.")
+}
+
+func TestTableLongSingleLine(t *testing.T) {
+	rows := numberedRows(5)
+	rows[1] = table.NewRow(map[string]any{
+		"id":    1,
+		"entry": "echo START" + strings.Repeat(" and so on", 20) + " END",
+	})
+
+	tbl := table.New(testColumns).
+		WithRows(rows).
+		WithTargetWidth(60).
+		WithTargetHeight(20).
+		MoveHighlight(1)
+
+	// the line is wrapped, rather than cut off
+	output := renderTable(t, tbl, 60, 20)
+	require.Contains(t, output, "START")
+	require.Contains(t, output, "END")
+	require.Contains(t, output, "entry number 4.")
+}
+
+func TestTableNoRows(t *testing.T) {
+	tbl := table.New(testColumns).
+		WithTargetWidth(80).
+		WithTargetHeight(10).
+		MoveHighlight(1).
+		ScrollHighlightedRow(1)
+
+	require.Nil(t, tbl.HighlightedRow().Data)
+
+	output := renderTable(t, tbl, 80, 10)
+	require.Contains(t, output, "id")
+	require.Contains(t, output, "entry")
+}
+
+func mouseTestTable(n int) *table.Table {
+	// a 9-line viewport under the 3-line header
+	return table.New(testColumns).WithRows(numberedRows(n)).WithTargetWidth(80).WithTargetHeight(13)
+}
+
+func wheel(button tea.MouseButton) tea.MouseMsg {
+	return tea.MouseMsg{Button: button, Action: tea.MouseActionPress}
+}
+
+func leftClick(x, y int) tea.MouseMsg {
+	return tea.MouseMsg{X: x, Y: y, Button: tea.MouseButtonLeft, Action: tea.MouseActionPress}
+}
+
+func TestTableMouseWheel(t *testing.T) {
+	tbl := mouseTestTable(50)
+
+	tbl, cmd := tbl.Update(wheel(tea.MouseButtonWheelDown))
+	require.Nil(t, cmd)
+
+	view := tbl.View()
+	require.NotContains(t, view, "entry number 2.")
+	require.Contains(t, view, "entry number 3.")
+	require.Contains(t, view, "entry number 11.")
+	require.Equal(t, 3, tbl.GetHighlightedRowIndex(), "the highlight moves along to stay in view")
+
+	tbl, _ = tbl.Update(wheel(tea.MouseButtonWheelUp))
+	view = tbl.View()
+	require.Contains(t, view, "entry number 0.")
+	require.Equal(t, 3, tbl.GetHighlightedRowIndex(), "the highlight stays put while it's in view")
+
+	for range 50 {
+		tbl, _ = tbl.Update(wheel(tea.MouseButtonWheelDown))
+	}
+	view = tbl.View()
+	require.Contains(t, view, "entry number 41.")
+	require.Contains(t, view, "entry number 49.")
+	require.Equal(t, 41, tbl.GetHighlightedRowIndex())
+}
+
+func TestTableMouseClick(t *testing.T) {
+	tbl := mouseTestTable(50)
+	tbl, _ = tbl.Update(wheel(tea.MouseButtonWheelDown))
+
+	// the fifth line of the viewport shows the row at index 3 + 4
+	tbl, cmd := tbl.Update(leftClick(10, 3+4))
+	require.Nil(t, cmd)
+	require.Equal(t, 7, tbl.GetHighlightedRowIndex())
+
+	tbl, cmd = tbl.Update(leftClick(10, 3+5))
+	require.Nil(t, cmd, "clicks on different rows aren't a double click")
+	require.Equal(t, 8, tbl.GetHighlightedRowIndex())
+
+	tbl, cmd = tbl.Update(leftClick(20, 3+5))
+	require.NotNil(t, cmd, "a double click selects the row")
+	msg, isSelectedMsg := cmd().(table.SelectedMsg)
+	require.True(t, isSelectedMsg)
+	require.Equal(t, 8, msg.Row.Data["id"])
+
+	// clicks too far apart aren't a double click either
+	now := time.Unix(1700000000, 0)
+	tbl = tbl.WithClock(func() time.Time { return now })
+	tbl, _ = tbl.Update(leftClick(10, 3+4))
+	now = now.Add(time.Second)
+	tbl, cmd = tbl.Update(leftClick(10, 3+4))
+	require.Nil(t, cmd)
+	require.Equal(t, 7, tbl.GetHighlightedRowIndex())
+
+	// clicking past the last row does nothing
+	tbl = table.New(testColumns).WithRows(numberedRows(2)).WithTargetWidth(80).WithTargetHeight(13)
+	tbl, cmd = tbl.Update(leftClick(10, 3+5))
+	require.Nil(t, cmd)
+	require.Equal(t, 0, tbl.GetHighlightedRowIndex())
+}
+
+func TestTableMouseSort(t *testing.T) {
+	ids := func(tbl *table.Table) []int {
+		ids := make([]int, 0)
+		for _, row := range tbl.GetVisibleRows() {
+			ids = append(ids, row.Data["id"].(int))
+		}
+		return ids
+	}
+
+	tbl := mouseTestTable(12)
+	tbl = tbl.MoveHighlight(5)
+
+	// the entry column starts after the 5-wide id column and a space
+	tbl, _ = tbl.Update(leftClick(10, 1))
+	require.Equal(t, []int{0, 1, 10, 11, 2, 3, 4, 5, 6, 7, 8, 9}, ids(tbl))
+	require.Equal(t, 0, tbl.GetHighlightedRowIndex(), "sorting goes back to the top")
+	require.Contains(t, tbl.View(), "entry ▲ of 12", "the header says the sort only covers the rows the table has")
+
+	tbl, _ = tbl.Update(leftClick(2, 1))
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, ids(tbl))
+	view := tbl.View()
+	require.Contains(t, view, "id ▲")
+	require.NotContains(t, view, "entry ▲")
+
+	tbl, _ = tbl.Update(leftClick(2, 1))
+	require.Equal(t, []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}, ids(tbl))
+	require.Contains(t, tbl.View(), "id ▼")
+
+	// the sort sticks when the rows change
+	tbl = tbl.WithRows(numberedRows(3))
+	require.Equal(t, []int{2, 1, 0}, ids(tbl))
+
+	tbl, _ = tbl.Update(leftClick(2, 1))
+	require.Equal(t, []int{0, 1, 2}, ids(tbl))
+	require.NotContains(t, tbl.View(), "▲")
+
+	// the space between columns isn't either of them
+	tbl, _ = tbl.Update(leftClick(6, 1))
+	require.NotContains(t, tbl.View(), "▲")
+	tbl, _ = tbl.Update(leftClick(7, 1))
+	require.Contains(t, tbl.View(), "entry ▲ of 3")
+}
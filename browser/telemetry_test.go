@@ -0,0 +1,50 @@
+package main_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"hoelz.ro/histdb-browser/internal/telemetry"
+)
+
+func TestTelemetrySummary(t *testing.T) {
+	c := telemetry.New()
+
+	_, found := c.Last()
+	require.False(t, found)
+
+	for i := 1; i <= 20; i++ {
+		c.Record(telemetry.Sample{
+			SQL:         "SELECT entry FROM h",
+			Duration:    time.Duration(i) * time.Millisecond,
+			RowsScanned: 100,
+			Results:     10,
+		})
+	}
+	// a store that doesn't know how many rows it scanned
+	c.Record(telemetry.Sample{SQL: "SELECT entry FROM pins", Duration: time.Millisecond, RowsScanned: -1, Results: 10})
+	c.Record(telemetry.Sample{SQL: "SELECT entry FROM h", Duration: time.Microsecond, Results: 10, CacheHit: true})
+
+	last, found := c.Last()
+	require.True(t, found)
+	require.True(t, last.CacheHit)
+
+	s := c.Summary()
+	require.Equal(t, 22, s.Queries)
+	require.Equal(t, 1, s.CacheHits)
+	require.Equal(t, 10*time.Millisecond, s.P50, "cache hits don't count towards latency")
+	require.Equal(t, 19*time.Millisecond, s.P95)
+	require.Equal(t, 20*time.Millisecond, s.Max)
+	require.Equal(t, 2000, s.RowsScanned)
+	require.Equal(t, 220, s.Results)
+
+	require.Len(t, s.Slowest, 5)
+	require.Equal(t, 20*time.Millisecond, s.Slowest[0].Duration)
+	require.Equal(t, 16*time.Millisecond, s.Slowest[4].Duration)
+
+	var nilCollector *telemetry.Collector
+	nilCollector.Record(telemetry.Sample{})
+	require.Zero(t, nilCollector.Summary())
+}
@@ -0,0 +1,105 @@
+//go:build libsqlite3
+
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+
+	_ "embed"
+)
+
+//go:embed histdb.lua
+var histDBSource string
+
+//go:embed lua-vtable.so
+var vtableExtension []byte
+
+const vtableExtensionPath = "/home/rob/.cache/lua-vtable.so"
+
+// the Lua vtable extension is only built into binaries linked against the
+// system SQLite
+const defaultBackend = "vtable"
+
+// installVTableExtension writes out the embedded Lua vtable extension if the
+// copy on disk is older than this binary
+func installVTableExtension() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	s, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+
+	ourModTime := s.ModTime()
+
+	var extensionModTime time.Time
+
+	s, err = os.Stat(vtableExtensionPath)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if err == nil {
+		extensionModTime = s.ModTime()
+	}
+
+	if extensionModTime.Before(ourModTime) {
+		// we need to delete any existing version first, because it could be mapped into the address
+		// space for any currently running version, and writing over the existing file's contents will
+		// mess with that and likely cause a segfault 😬
+		err := os.Remove(vtableExtensionPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		err = os.WriteFile(vtableExtensionPath, vtableExtension, 0o700)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var (
+	registerDriverOnce sync.Once
+	// why the driver couldn't be registered, if it couldn't
+	registerDriverErr error
+)
+
+// vtableDriver returns the name of a database/sql driver with the h virtual
+// table available on every connection
+func vtableDriver() (string, error) {
+	registerDriverOnce.Do(func() {
+		registerDriverErr = installVTableExtension()
+		if registerDriverErr != nil {
+			return
+		}
+
+		sql.Register("sqlite3-histdb-extensions", &sqlite3.SQLiteDriver{
+			Extensions: []string{
+				vtableExtensionPath,
+			},
+			// the module only lives as long as the connection, so make sure that
+			// any new connections database/sql opens get it too
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				_, err := conn.Exec("SELECT lua_create_module_from_source(?)", []driver.Value{histDBSource})
+				return err
+			},
+		})
+	})
+	if registerDriverErr != nil {
+		return "", registerDriverErr
+	}
+
+	return "sqlite3-histdb-extensions", nil
+}